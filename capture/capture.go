@@ -0,0 +1,274 @@
+//
+package capture
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"net"
+	"os"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The capture records the packets crossing the kernel and the network endpoints into a pcapng file.
+// Every endpoint gets an interface per direction, named after the identifier of the endpoint. The
+// payload of a packet is a raw IP frame in both cases, therefore all interfaces share the link type.
+
+// Direction of a packet from the point of view of shila.
+type Direction string
+
+const (
+	Ingress Direction = "in"  // Received through the endpoint.
+	Egress  Direction = "out" // Sent through the endpoint.
+)
+
+// Nil if the capture is disabled.
+var recorder *fileRecorder
+
+type interfaceKey struct {
+	name      string
+	direction Direction
+}
+
+type fileRecorder struct {
+	lock        sync.Mutex
+	path        string
+	maxFileSize int64
+	maxFiles    int
+	filter      map[shila.TCPFlowKey]bool
+	file        *os.File
+	output      *countingWriter
+	writer      *pcapgo.NgWriter // Nil until the first packet is written to the current file.
+	interfaces  map[interfaceKey]int
+}
+
+// Init opens the capture file if the capture is enabled.
+func Init() error {
+
+	if !config.Config.Capture.Enabled {
+		return nil
+	}
+
+	filter, err := parseFilter(config.Config.Capture.Filter)
+	if err != nil {
+		return err
+	}
+
+	rec := &fileRecorder{
+		path:        config.Config.Capture.Path,
+		maxFileSize: int64(config.Config.Capture.MaxFileSize),
+		maxFiles:    config.Config.Capture.MaxFiles,
+		filter:      filter,
+	}
+	if err := rec.open(); err != nil {
+		return err
+	}
+	recorder = rec
+
+	go recorder.serveFlush(time.Duration(config.Config.Capture.FlushInterval) * time.Second)
+
+	log.Verbose.Print("Capturing packets to ", rec.path, ".")
+	return nil
+}
+
+// Close flushes and closes the capture file.
+func Close() {
+	if recorder == nil {
+		return
+	}
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if err := recorder.close(); err != nil {
+		log.Error.Print("Unable to close capture file. ", err.Error())
+	}
+	recorder.file = nil
+}
+
+// Record writes the packet crossing the endpoint in the given direction to the capture.
+func Record(endpoint shila.Endpoint, direction Direction, p *shila.Packet) {
+
+	if recorder == nil {
+		return
+	}
+	if len(recorder.filter) > 0 && !recorder.filter[p.Flow.TCPFlow.Key()] {
+		return
+	}
+
+	timestamp := time.Now()
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	if err := recorder.write(endpoint.Identifier(), direction, p.Payload, timestamp); err != nil {
+		log.Error.Print("Unable to capture packet. ", err.Error())
+	}
+}
+
+func (rec *fileRecorder) write(name string, direction Direction, data []byte, timestamp time.Time) error {
+
+	if rec.file == nil {
+		// Already closed.
+		return nil
+	}
+
+	if rec.maxFileSize > 0 && rec.output.written > 0 && rec.output.written + int64(len(data)) > rec.maxFileSize {
+		if err := rec.rotate(); err != nil {
+			return err
+		}
+	}
+
+	id, err := rec.interfaceID(name, direction)
+	if err != nil {
+		return err
+	}
+
+	return rec.writer.WritePacket(gopacket.CaptureInfo{
+		Timestamp:      timestamp,
+		CaptureLength:  len(data),
+		Length:         len(data),
+		InterfaceIndex: id,
+	}, data)
+}
+
+// Returns the id of the interface within the current file, the interface is added if necessary.
+func (rec *fileRecorder) interfaceID(name string, direction Direction) (int, error) {
+
+	key := interfaceKey{name, direction}
+	if id, ok := rec.interfaces[key]; ok {
+		return id, nil
+	}
+
+	intf := pcapgo.DefaultNgInterface
+	intf.Name     = fmt.Sprint(name, " [", direction, "]")
+	intf.LinkType = layers.LinkTypeRaw
+
+	var id int
+	if rec.writer == nil {
+		writer, err := pcapgo.NewNgWriterInterface(rec.output, intf, pcapgo.NgWriterOptions{
+			SectionInfo: pcapgo.NgSectionInfo{Application: "shila"},
+		})
+		if err != nil {
+			return 0, err
+		}
+		rec.writer = writer
+	} else {
+		var err error
+		if id, err = rec.writer.AddInterface(intf); err != nil {
+			return 0, err
+		}
+	}
+
+	rec.interfaces[key] = id
+	return id, nil
+}
+
+// The current file becomes <path>.1, the previous ones are shifted by one. The oldest
+// file is dropped such that at most the configured number of files is kept.
+func (rec *fileRecorder) rotate() error {
+
+	if err := rec.close(); err != nil {
+		return err
+	}
+
+	for i := rec.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(rec.rotatedPath(i - 1), rec.rotatedPath(i)); err != nil && !os.IsNotExist(err) {
+			log.Error.Print("Unable to rotate capture file. ", err.Error())
+		}
+	}
+
+	return rec.open()
+}
+
+func (rec *fileRecorder) rotatedPath(n int) string {
+	if n == 0 {
+		return rec.path
+	}
+	return fmt.Sprint(rec.path, ".", n)
+}
+
+func (rec *fileRecorder) open() error {
+	file, err := os.Create(rec.path)
+	if err != nil {
+		return shila.PrependError(shila.CriticalError(err.Error()), "Unable to create capture file.")
+	}
+	rec.file       = file
+	rec.output     = &countingWriter{writer: file}
+	rec.writer     = nil
+	rec.interfaces = make(map[interfaceKey]int)
+	return nil
+}
+
+func (rec *fileRecorder) close() error {
+	if rec.file == nil {
+		return nil
+	}
+	var err error
+	if rec.writer != nil {
+		err = rec.writer.Flush()
+	}
+	if errClose := rec.file.Close(); errClose != nil {
+		err = errClose
+	}
+	return err
+}
+
+func (rec *fileRecorder) serveFlush(interval time.Duration) {
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rec.lock.Lock()
+		if rec.file == nil {
+			rec.lock.Unlock()
+			return
+		}
+		if rec.writer != nil {
+			if err := rec.writer.Flush(); err != nil {
+				log.Error.Print("Unable to flush capture file. ", err.Error())
+			}
+		}
+		rec.lock.Unlock()
+	}
+}
+
+// The entries of the filter are pairs of tcp addresses (<ip>:<port>|<ip>:<port>), the order does not matter.
+func parseFilter(entries []string) (map[shila.TCPFlowKey]bool, error) {
+	filter := make(map[shila.TCPFlowKey]bool)
+	for _, entry := range entries {
+		addrs := strings.Split(strings.TrimSuffix(strings.TrimPrefix(entry, shila.KeyPrefix), shila.KeySuffix), shila.KeyDelimiter)
+		if len(addrs) != 2 {
+			return nil, shila.CriticalError(fmt.Sprint("Invalid capture filter ", entry, "."))
+		}
+		src, errSrc := net.ResolveTCPAddr("tcp", addrs[0])
+		dst, errDst := net.ResolveTCPAddr("tcp", addrs[1])
+		if errSrc != nil || errDst != nil {
+			return nil, shila.CriticalError(fmt.Sprint("Invalid capture filter ", entry, "."))
+		}
+		flow := shila.TCPFlow{Src: *src, Dst: *dst}
+		filter[flow.Key()] = true
+	}
+	return filter, nil
+}
+
+// Counts the bytes handed to the file. The writer of pcapgo buffers its output, a file
+// therefore exceeds the maximal size by at most the size of this buffer.
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
@@ -5,11 +5,20 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"shila/io/structure"
+	"strings"
 )
 
 var Config structure.ConfigJSON
 
+// Set by --cleanup-only, shila just removes the leftovers of a previous run and exits.
+var CleanupOnly bool
+
+// Set by --add-egress-interface or --remove-egress-interface, shila just sends the command to
+// the running instance (through its control socket) and exits.
+var ControlCommand string
+
 func init() {
 	Config = loadConfig()
 
@@ -34,8 +43,20 @@ func loadConfig() structure.ConfigJSON {
 	// Load the default values
 	configJSON := defaultConfig()
 
-	// Get the path to the config file from the command line argument.
-	configPath := flag.String("config", "", "Path to the config file."); flag.Parse()
+	// Get the path to the config file from the command line argument. The flags of a test binary are
+	// registered after the config is loaded, they are left to the testing package.
+	flags 		 := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configPath   := flags.String("config", "", "Path to the config file.")
+	cleanupOnly  := flags.Bool("cleanup-only", false, "Remove the leftovers of a previous run and exit.")
+	addEgress 	 := flags.Bool("add-egress-interface", false, "Add an egress interface to the running instance and exit.")
+	removeEgress := flags.String("remove-egress-interface", "", "Remove the egress interface w/ the given address from the running instance and exit.")
+	_ = flags.Parse(withoutTestFlags(os.Args[1:]))
+	CleanupOnly = *cleanupOnly
+	if *addEgress {
+		ControlCommand = "add"
+	} else if *removeEgress != "" {
+		ControlCommand = fmt.Sprint("remove ", *removeEgress)
+	}
 	if *configPath == "" {
 		return *configJSON
 	}
@@ -49,6 +70,16 @@ func loadConfig() structure.ConfigJSON {
 	return *configJSON
 }
 
+func withoutTestFlags(args []string) []string {
+	filtered := make([]string, 0, len(args))
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-test.") {
+			filtered = append(filtered, arg)
+		}
+	}
+	return filtered
+}
+
 func loadConfigFromDisk(config *structure.ConfigJSON, path string) error {
 
 	data, err := ioutil.ReadFile(path)
@@ -68,6 +99,7 @@ func defaultConfig() *structure.ConfigJSON {
 	return &structure.ConfigJSON{
 		WorkingSide:     structure.WorkingSideConfigJSON{
 			NumberOfWorkerPerChannel: 			 		1,
+			SizeWorkerBuffer:							250,
 		},
 		Connection:      structure.ConnectionConfigJSON{
 			VacuumInterval:                      		5,
@@ -82,15 +114,30 @@ func defaultConfig() *structure.ConfigJSON {
 			EgressNamespace:          					"shila-egress",
 			IngressNamespace:         					"shila-ingress",
 			IngressIP:                					"10.7.0.9",
+			EgressSubnet:								"10.7.1.0/24",
+			EgressSubnetIPv6:							"fd00:7:1::/64",
+			EnableIPv6:									false,
+			IngressIPv6:								"fd00:7::9",
+			StateFilePath:								"/run/shila/kernelState.json",
+			ControlSocketPath:							"/run/shila/control.sock",
+			TransparentInterception:					false,
+			InterceptedPrefixes:						[]string{},
+			InterceptionMark:							0x5a,
+			InterceptionRulePriority:					32765,
+			RoutingTableOffset:							7000,
 		},
 		KernelEndpoint:  structure.KernelEndpointConfigJSON{
 			SizeIngressBuffer:          				250,
 			SizeEgressBuffer:           				250,
-			SizeRawIngressBuffer:       				2500,
-			SizeRawIngressStorage:      				2500,
-			ReadSizeRawIngress:         				30,
+			MaxFrameSize:								2500,
 			WaitingTimeUntilEscalation: 				5,
 		},
+		MPTCP:           structure.MPTCPConfigJSON{
+			PathManager:								"",
+			Scheduler:									"",
+			CongestionControl:							"",
+			Sysctls:									map[string]string{},
+		},
 		Logging:         structure.LoggingConfigJSON{
 			PrintVerbose: 								false,
 			DoEgressTimestamping: 						false,
@@ -100,20 +147,77 @@ func defaultConfig() *structure.ConfigJSON {
 			IngressTimestampLogAdditionalLine:			"",
 			IngressTimestampLogPath:					"",
 			TimestampFlushInterval:						10,
+			StatsInterval:								60,
 		},
 		NetworkSide:     structure.NetworkSideConfigJSON{
 			ContactingServerPort: 						9876,
+			Backbone:									"udp",
 		},
 		NetworkEndpoint: structure.NetworkEndpointConfigJSON{
 			SizeIngressBuffer:              		 	250,
 			SizeEgressBuffer:                		 	250,
 			SizeRawIngressStorage:           		 	2500,
 			WaitingTimeAfterConnectionIssue: 		 	2,
-			ServerResendInterval:            		 	2,
-			SizeHoldingArea:                 		 	100,
+			BackboneIdleTimeout:						300,
+			HoldingTime:								10000,
+			HoldingAreaMaxPackets:						100,
+			HoldingAreaMaxBytes:						150000,
+			HandshakeTimeout:							250,
+			HandshakeAttempts:							5,
+			HeartbeatInterval:							1000,
+			HeartbeatMissThreshold:						5,
+			Batching:									false,
+			BatchingFlushDelay:							200,
+			BatchingMaxDatagramSize:					1280,
+			QUICCertificatePath:						"",
+			QUICKeyPath:								"",
 		},
 		Router: structure.RouterConfigJSON{
 			PathSelection: 								"mtu",
+			ClampMSS:									true,
+			BackboneFramingOverhead:					128,
+			IPPaths:									[]string{},
+		},
+		Security: structure.SecurityConfigJSON{
+			Enabled:									false,
+			SessionAcceptanceWindow:					30,
+			PeerKeys:									[]structure.PeerKeyJSON{},
+		},
+		AccessControl: structure.AccessControlConfigJSON{
+			Enabled:									false,
+			Allow:										[]string{},
+			Deny:										[]string{},
+			AllowedPorts:								[]int{},
+		},
+		Loopback: structure.LoopbackConfigJSON{
+			IP:											"127.0.0.1",
+			Seed:										1,
+			Delay:										0,
+			Loss:										0,
+			Reorder:									0,
+			ReorderDelay:								0,
+			Paths:										[]structure.LoopbackPathJSON{},
+		},
+		Capture: structure.CaptureConfigJSON{
+			Enabled:									false,
+			Path:										"_shila.pcapng",
+			MaxFileSize:								104857600,
+			MaxFiles:									5,
+			FlushInterval:								1,
+			Filter:										[]string{},
+		},
+		Supervisor: structure.SupervisorConfigJSON{
+			MaxRestarts:								5,
+			RestartWindow:								60,
+			InitialBackoff:								500,
+			MaxBackoff:									10000,
+		},
+		QoS: structure.QoSConfigJSON{
+			Enabled:									false,
+			Classes:									[]structure.QoSClassJSON{{Name: "default", Weight: 1}},
+			DefaultClass:								"default",
+			Rules:										[]structure.QoSRuleJSON{},
+			QueueSize:									250,
 		},
 		Config: structure.ConfigConfigJSON{
 			DumpConfig:									false,
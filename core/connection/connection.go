@@ -3,12 +3,13 @@ package connection
 
 import (
 	"fmt"
-	"github.com/scionproto/scion/go/lib/snet"
 	"shila/config"
+	"shila/core/qos"
 	"shila/core/router"
 	"shila/core/shila"
 	"shila/kernelSide"
 	"shila/kernelSide/kernelEndpoint"
+	"shila/layer/tcpip"
 	"shila/log"
 	"shila/networkSide"
 	"sync"
@@ -30,6 +31,12 @@ type Connection struct {
 	router      router.Router
 	flowCount   int
 	sharability int
+	mss         int // Clamp for the MSS of the segments, zero if no clamping is required.
+	kerep       kernelEndpoint.Endpoint // Kernel endpoint used by the connection, released on close.
+	qos         *qos.QoS // Nil if QoS is disabled.
+	class       qos.Class
+	toNetwork   qos.Counter
+	toKernel    qos.Counter
 }
 
 type channels struct {
@@ -38,7 +45,7 @@ type channels struct {
 	Contacting      shila.PacketChannels // End point for connection establishment
 }
 
-func New(flow shila.Flow, kernelSide *kernelSide.Manager, networkSide *networkSide.Manager, router router.Router) *Connection {
+func New(flow shila.Flow, kernelSide *kernelSide.Manager, networkSide *networkSide.Manager, router router.Router, qos *qos.QoS) *Connection {
 	return &Connection{
 		key:         flow.TCPFlow.Key(),
 		flow:        flow,
@@ -48,6 +55,7 @@ func New(flow shila.Flow, kernelSide *kernelSide.Manager, networkSide *networkSi
 		kernelSide:  kernelSide,
 		networkSide: networkSide,
 		router:      router,
+		qos:         qos,
 	}
 }
 
@@ -61,6 +69,11 @@ func (conn *Connection) Close(err error) {
 		return
 	}
 
+	// The packets still queued for the network endpoints are not sent anymore.
+	if conn.qos != nil {
+		conn.qos.Purge(&conn.toNetwork)
+	}
+
 	// Tear down all endpoints possibly associated with this connection
 	_ = conn.networkSide.TeardownContactingClientEndpoint(conn.flow.TCPFlow)
 	_ = conn.networkSide.TeardownTrafficSeverEndpoint(conn.flow)
@@ -69,9 +82,11 @@ func (conn *Connection) Close(err error) {
 	// Remove the entries from the router
 	conn.router.ClearEntry(conn.key)
 
+	conn.releaseKernelEndpoint()
 	conn.setState(closed)
 
 	log.Info.Print(conn.Says(shila.PrependError(err, "Closed.").Error()))
+	log.Info.Print(conn.Says(conn.stats().String()))
 }
 
 func (conn *Connection) ProcessPacket(p *shila.Packet) error {
@@ -101,30 +116,33 @@ func (conn *Connection) processPacketFromKerep(p *shila.Packet) error {
 	switch conn.state.current {
 	case raw:				return conn.processPacketFromKerepStateRaw(p)
 
-	case clientReady:		p.Flow.NetFlow = conn.flow.NetFlow
+	case clientReady:		// Retransmissions of the SYN are clamped as well.
+							p.Flow.NetFlow = conn.flow.NetFlow
 							// conn.touched = time.Now()
-							conn.channels.Contacting.Egress <- p
+							conn.clampMSS(p)
+							conn.sendToNetwork(conn.channels.Contacting.Egress, p)
 							return nil
 
 	case clientEstablished:	p.Flow.NetFlow = conn.flow.NetFlow
 							// conn.touched = time.Now()
-							conn.channels.NetworkEndpoint.Egress <- p
+							conn.clampMSS(p)
+							conn.sendToNetwork(conn.channels.NetworkEndpoint.Egress, p)
 							return nil
 
 	case serverReady: 		// Put packet into egress queue of connection. If the connection is established at one one point, these packets
 							// are sent. If not they are lost. (--> Take care, could block if too many packets are in queue
 							p.Flow.NetFlow = conn.flow.NetFlow
-							conn.channels.NetworkEndpoint.Egress <- p
+							conn.sendToNetwork(conn.channels.NetworkEndpoint.Egress, p)
 							conn.setState(serverEstablished)
 							return nil
 
 	case serverEstablished: p.Flow.NetFlow = conn.flow.NetFlow
-							conn.channels.NetworkEndpoint.Egress <- p
+							conn.sendToNetwork(conn.channels.NetworkEndpoint.Egress, p)
 							return nil
 
 	case established:		p.Flow.NetFlow = conn.flow.NetFlow
 							conn.touched = time.Now()
-							conn.channels.NetworkEndpoint.Egress <- p
+							conn.sendToNetwork(conn.channels.NetworkEndpoint.Egress, p)
 							return nil
 
 	case closed: 			return nil
@@ -143,14 +161,14 @@ func (conn *Connection) processPacketFromContactingEndpoint(p *shila.Packet) err
 
 	case clientEstablished: return shila.CriticalError(fmt.Sprint("Invalid connection state ", conn.state.current, "."))
 
-	case serverReady:		conn.channels.KernelEndpoint.Egress <- p
+	case serverReady:		conn.sendToKernel(p)
 							return nil
 
-	case serverEstablished: conn.channels.KernelEndpoint.Egress <- p
+	case serverEstablished: conn.sendToKernel(p)
 							return nil
 
 	case established: 		conn.touched = time.Now()
-							conn.channels.KernelEndpoint.Egress <- p
+							conn.sendToKernel(p)
 							return nil
 
 	case closed: 		 	return nil
@@ -177,7 +195,8 @@ func (conn *Connection) processPacketFromTrafficEndpoint(p *shila.Packet) error
 							}
 
 							conn.touched = time.Now()
-							conn.channels.KernelEndpoint.Egress <- p
+							conn.clampMSS(p)
+							conn.sendToKernel(p)
 							conn.setState(established)
 
 							log.Verbose.Print(conn.Says("Successfully established!"))
@@ -190,14 +209,16 @@ func (conn *Connection) processPacketFromTrafficEndpoint(p *shila.Packet) error
 							return nil
 
 	case serverEstablished: conn.touched = time.Now()
-							conn.channels.KernelEndpoint.Egress <- p
+							conn.sendToKernel(p)
 							conn.setState(established)
 
 							// log.Info.Print(conn.Says(color.Green("Successfully established!")))
 							return nil
 
-	case established: 		conn.touched 	= time.Now()
-							conn.channels.KernelEndpoint.Egress <- p
+	case established: 		// Retransmissions of the SYN/ACK are clamped as well.
+							conn.touched 	= time.Now()
+							conn.clampMSS(p)
+							conn.sendToKernel(p)
 							return nil
 
 	case closed: 			return nil
@@ -215,19 +236,24 @@ func (conn *Connection) printEstablishmentStatement() {
 	log.Info.Print("| Net-Flow: \t ", conn.flow.NetFlow.Src, " <-> ", conn.flow.NetFlow.Dst)
 	log.Info.Print("| Metrics: \t ", conn.rawMetrics[0], " (mtu) ", conn.rawMetrics[1], " (length)")
 	log.Info.Print("| Sharability: \t ", conn.sharability)
+	if conn.qos != nil {
+		log.Info.Print("| QoS class: \t ", conn.class)
+	}
 	log.Info.Print("| Main-Flow: \t ", conn.mainTcpFlow)
-	// If the path is nil, the destination is within the local iA
+	// If the path is nil, the destination is within the local iA (or the host picks the path)
 	if conn.flow.NetFlow.Path != nil {
-		log.Info.Printf("| %s\n", fmt.Sprintf("%s", conn.flow.NetFlow.Path.(snet.Path)))
+		log.Info.Printf("| %s\n", fmt.Sprintf("%s", conn.flow.NetFlow.Path))
 	}
 }
 
 func (conn *Connection) processPacketFromKerepStateRaw(p *shila.Packet) error {
 	// Assign the channels from the device through which the packet was received.
 	var ep interface{} = p.Entrypoint
-	if entryPoint, ok := ep.(*kernelEndpoint.Device); ok {
+	if entryPoint, ok := ep.(kernelEndpoint.Endpoint); ok {
 		conn.channels.KernelEndpoint.Ingress = entryPoint.TrafficChannels().Ingress // ingress from kernel end point
 		conn.channels.KernelEndpoint.Egress  = entryPoint.TrafficChannels().Egress  // egress towards kernel end point
+		conn.kernelSide.UseEndpoint(entryPoint)
+		conn.kerep = entryPoint
 	} else {
 		return shila.CriticalError("Invalid entry point.")
 	}
@@ -238,9 +264,11 @@ func (conn *Connection) processPacketFromKerepStateRaw(p *shila.Packet) error {
 	} else {
 		conn.processRoutingResponse(response)
 	}
+	conn.classify(p)
 
 	// Update the packet
 	p.Flow.NetFlow = conn.flow.NetFlow
+	conn.clampMSS(p)
 
 	// Create the contacting connection
 	contactingNetFlow, channels, err := conn.networkSide.EstablishNewContactingClientEndpoint(conn.flow)
@@ -256,7 +284,7 @@ func (conn *Connection) processPacketFromKerepStateRaw(p *shila.Packet) error {
 
 	// Send the packet via the contacting channel
 	conn.touched = time.Now()
-	conn.channels.Contacting.Egress <- p
+	conn.sendToNetwork(conn.channels.Contacting.Egress, p)
 
 	// Try to connect to the address via path, a corresponding server should be there listening
 	go func() {
@@ -286,8 +314,9 @@ func (conn *Connection) processPacketFromContactingEndpointStateRaw(p *shila.Pac
 
 	// Get the kernel endpoint from the kernel side manager
 	packetDstKey := p.Flow.TCPFlow.DstIPKey()
-	if channels, ok := conn.kernelSide.GetTrafficChannels(packetDstKey); ok {
-		conn.channels.KernelEndpoint = channels
+	if kerep, ok := conn.kernelSide.AcquireEndpoint(packetDstKey); ok {
+		conn.channels.KernelEndpoint = kerep.TrafficChannels()
+		conn.kerep = kerep
 	} else {
 		conn.state.set(closed)
 		return shila.TolerableError(fmt.Sprint("Cant process packet. No kernel endpoint for ", packetDstKey, ".")) // TODO: TO THINK.
@@ -295,15 +324,17 @@ func (conn *Connection) processPacketFromContactingEndpointStateRaw(p *shila.Pac
 
 	// Send packet to kernel endpoint
 	// --> 	Could still be that connection cannot be established, since we have no idea if there is actually a server listening
-	conn.channels.KernelEndpoint.Egress <- p
+	conn.sendToKernel(p)
 
 	// If the packet is received through the contacting endpoint (server), then it's network connection id
 	// is already set. This is the responsibility of the corresponding network server implementation.
 	conn.flow.NetFlow = p.Flow.NetFlow.Swap()
+	conn.classify(p)
 
 	// Request new incoming connection from network side.
 	// ! The receiving network endpoint is responsible to correctly set the destination network address! !
 	if channels, err := conn.networkSide.EstablishNewTrafficServerEndpoint(conn.flow.NetFlow.Src, conn.key); err != nil {
+		conn.releaseKernelEndpoint()
 		conn.state.set(closed)
 		return shila.TolerableError(fmt.Sprint("Unable to establish server endpoint.", err.Error()))
 	} else {
@@ -316,6 +347,25 @@ func (conn *Connection) processPacketFromContactingEndpointStateRaw(p *shila.Pac
 	return nil
 }
 
+// The kernel side can remove a draining kernel endpoint once no connection uses it anymore.
+func (conn *Connection) releaseKernelEndpoint() {
+	if conn.kerep != nil {
+		conn.kernelSide.ReleaseEndpoint(conn.kerep)
+		conn.kerep = nil
+	}
+}
+
+// Uses is true if the connection uses the kernel endpoint.
+func (conn *Connection) Uses(endpoint shila.Endpoint) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.kerep == nil {
+		return false
+	}
+	var kerep shila.Endpoint = conn.kerep
+	return kerep == endpoint
+}
+
 func (conn *Connection) setState(state stateIdentifier) {
 	conn.state.set(state)
 	if conn.state.previous != conn.state.current {
@@ -339,6 +389,80 @@ func (conn *Connection) processRoutingResponse(response router.Response) {
 	conn.rawMetrics 	= response.RawMetrics
 	conn.sharability 	= response.Sharability
 	conn.flowCount		= response.FlowCount
+	conn.mss			= response.MSS
+}
+
+// Just the client side knows the path and therefore its MTU. It clamps the MSS of both
+// directions, the one of the outgoing SYN and the one of the incoming SYN/ACK.
+func (conn *Connection) clampMSS(p *shila.Packet) {
+	if conn.mss == 0 {
+		return
+	}
+	if clamped, err := tcpip.ClampMSS(p.Payload, uint16(conn.mss)); err != nil {
+		log.Error.Print(conn.Says(shila.PrependError(err, "Unable to clamp MSS.").Error()))
+	} else if clamped {
+		log.Verbose.Print(conn.Says(fmt.Sprint("Clamped MSS to ", conn.mss, ".")))
+	}
+}
+
+// Packets towards the network endpoints are scheduled by the class of the connection (if QoS is enabled).
+func (conn *Connection) sendToNetwork(out shila.PacketChannel, p *shila.Packet) {
+	if conn.qos == nil {
+		out <- p
+		conn.toNetwork.Count(p)
+		return
+	}
+	conn.qos.Send(conn.class, p, out, &conn.toNetwork)
+}
+
+func (conn *Connection) sendToKernel(p *shila.Packet) {
+	conn.channels.KernelEndpoint.Egress <- p
+	conn.toKernel.Count(p)
+}
+
+func (conn *Connection) classify(p *shila.Packet) {
+	if conn.qos == nil {
+		return
+	}
+	conn.class = conn.qos.Classify(conn.flow, p.Payload)
+	log.Verbose.Print(conn.Says(fmt.Sprint("Assigned to QoS class ", conn.class, ".")))
+}
+
+// Stats of a connection.
+type Stats struct {
+	TCPFlow					shila.TCPFlow
+	State					string
+	Class					string		// Empty if QoS is disabled.
+	PacketsToNetwork		uint64
+	BytesToNetwork			uint64
+	DroppedToNetwork		uint64		// Dropped by the scheduler, the queue of the class was full.
+	PacketsToKernel			uint64
+	BytesToKernel			uint64
+}
+
+func (stats Stats) String() string {
+	str := fmt.Sprint("To network: ", stats.PacketsToNetwork, " packets (", stats.BytesToNetwork, " bytes, ",
+		stats.DroppedToNetwork, " dropped), to kernel: ", stats.PacketsToKernel, " packets (", stats.BytesToKernel, " bytes).")
+	if stats.Class != "" {
+		return fmt.Sprint("QoS class ", stats.Class, ". ", str)
+	}
+	return str
+}
+
+func (conn *Connection) Stats() Stats {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	return conn.stats()
+}
+
+func (conn *Connection) stats() Stats {
+	stats := Stats{TCPFlow: conn.flow.TCPFlow, State: conn.state.current.String()}
+	if conn.qos != nil {
+		stats.Class = conn.class.Name
+	}
+	stats.PacketsToNetwork, stats.BytesToNetwork, stats.DroppedToNetwork = conn.toNetwork.Get()
+	stats.PacketsToKernel, stats.BytesToKernel, _ = conn.toKernel.Get()
+	return stats
 }
 
 func (conn *Connection) createHumanReadableConnectionID() string {
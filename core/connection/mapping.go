@@ -3,6 +3,7 @@ package connection
 
 import (
 	"shila/config"
+	"shila/core/qos"
 	"shila/core/router"
 	"shila/core/shila"
 	"shila/kernelSide"
@@ -15,15 +16,17 @@ type Mapping struct {
 	kernelSide  *kernelSide.Manager
 	networkSide *networkSide.Manager
 	routing     router.Router
+	qos         *qos.QoS
 	connections map[shila.TCPFlowKey] *Connection
 	lock        sync.Mutex
 }
 
-func NewMapping(kernelSide *kernelSide.Manager, networkSide *networkSide.Manager, routing router.Router) Mapping {
+func NewMapping(kernelSide *kernelSide.Manager, networkSide *networkSide.Manager, routing router.Router, qos *qos.QoS) Mapping {
 	m := Mapping{
 		kernelSide: 	kernelSide,
 		networkSide: 	networkSide,
 		routing: 		routing,
+		qos:			qos,
 		connections: 	make(map[shila.TCPFlowKey] *Connection)}
 	go m.vacuum()
 	return m
@@ -55,7 +58,7 @@ func (m *Mapping) Retrieve(flow shila.Flow) *Connection {
 	if con, ok := m.connections[key]; ok {
 		return con
 	} else {
-		newCon := New(flow, m.kernelSide, m.networkSide, m.routing)
+		newCon := New(flow, m.kernelSide, m.networkSide, m.routing, m.qos)
 		m.connections[key] = newCon
 		return newCon
 	}
@@ -68,4 +71,35 @@ func (m *Mapping) Close(key shila.TCPFlowKey, err error) {
 		con.Close(err)
 	}
 	// Cannot close a none existent connection.
-}
\ No newline at end of file
+}
+
+// Stats returns the stats of all connections.
+func (m *Mapping) Stats() []Stats {
+	connections := m.snapshot()
+	stats := make([]Stats, 0, len(connections))
+	for _, con := range connections {
+		stats = append(stats, con.Stats())
+	}
+	return stats
+}
+
+// CloseUsing closes all connections using the kernel endpoint.
+func (m *Mapping) CloseUsing(endpoint shila.Endpoint, err error) {
+	for _, con := range m.snapshot() {
+		if con.Uses(endpoint) {
+			con.Close(err)
+		}
+	}
+}
+
+// Returns the connections currently mapped. A connection holds its lock while sending to a channel,
+// it is not taken while holding the lock of the mapping, which would block the lookups meanwhile.
+func (m *Mapping) snapshot() []*Connection {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	connections := make([]*Connection, 0, len(m.connections))
+	for _, con := range m.connections {
+		connections = append(connections, con)
+	}
+	return connections
+}
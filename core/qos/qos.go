@@ -0,0 +1,167 @@
+//
+package qos
+
+import (
+	"fmt"
+	"shila/config"
+	"shila/core/shila"
+	"shila/layer/tcpip"
+	"shila/networkSide"
+	"sync"
+)
+
+// The connections are assigned to classes by the configured rules. The packets a connection sends towards
+// the network endpoints are queued per class and scheduled by weighted fair queuing, such that a bulk
+// connection cannot starve the connections of other classes. The scheduler is shared by both working sides.
+
+type QoS struct {
+	classes      []Class
+	rules        []rule
+	defaultClass Class
+	scheduler    *scheduler
+}
+
+type Class struct {
+	ID     int
+	Name   string
+	Weight int
+}
+
+func (c Class) String() string {
+	return c.Name
+}
+
+type rule struct {
+	class        Class
+	ports        map[int]bool
+	dscps        map[uint8]bool
+	destinations map[shila.NetworkAddressKey]bool
+}
+
+// New creates the classes and rules from the config and starts the scheduler. Nil if QoS is disabled.
+func New() (*QoS, error) {
+
+	if !config.Config.QoS.Enabled {
+		return nil, nil
+	}
+
+	q := &QoS{}
+	classes := make(map[string]Class)
+	for _, c := range config.Config.QoS.Classes {
+		if _, ok := classes[c.Name]; ok {
+			return nil, shila.CriticalError(fmt.Sprint("Duplicate QoS class ", c.Name, "."))
+		}
+		if c.Weight <= 0 {
+			return nil, shila.CriticalError(fmt.Sprint("Invalid weight ", c.Weight, " of QoS class ", c.Name, "."))
+		}
+		class := Class{ID: len(q.classes), Name: c.Name, Weight: c.Weight}
+		classes[c.Name] = class
+		q.classes = append(q.classes, class)
+	}
+
+	var ok bool
+	if q.defaultClass, ok = classes[config.Config.QoS.DefaultClass]; !ok {
+		return nil, shila.CriticalError(fmt.Sprint("Unknown default QoS class ", config.Config.QoS.DefaultClass, "."))
+	}
+
+	generator := networkSide.NewAddressGenerator()
+	for _, r := range config.Config.QoS.Rules {
+		class, ok := classes[r.Class]
+		if !ok {
+			return nil, shila.CriticalError(fmt.Sprint("Unknown QoS class ", r.Class, " in rule."))
+		}
+		parsed := rule{class: class}
+		if len(r.Ports) > 0 {
+			parsed.ports = make(map[int]bool)
+			for _, port := range r.Ports {
+				parsed.ports[port] = true
+			}
+		}
+		if len(r.DSCPs) > 0 {
+			parsed.dscps = make(map[uint8]bool)
+			for _, dscp := range r.DSCPs {
+				if dscp < 0 || dscp > 63 {
+					return nil, shila.CriticalError(fmt.Sprint("Invalid DSCP ", dscp, " in QoS rule."))
+				}
+				parsed.dscps[uint8(dscp)] = true
+			}
+		}
+		if len(r.Destinations) > 0 {
+			parsed.destinations = make(map[shila.NetworkAddressKey]bool)
+			for _, destination := range r.Destinations {
+				addr, err := generator.New(destination)
+				if err != nil {
+					return nil, shila.PrependError(err, fmt.Sprint("Invalid destination ", destination, " in QoS rule."))
+				}
+				parsed.destinations[shila.GetNetworkAddressKey(addr)] = true
+			}
+		}
+		q.rules = append(q.rules, parsed)
+	}
+
+	q.scheduler = newScheduler(q.classes, config.Config.QoS.QueueSize)
+	go q.scheduler.serve()
+
+	return q, nil
+}
+
+// Classify returns the class of the connection, given its flow and its first packet.
+func (q *QoS) Classify(flow shila.Flow, raw []byte) Class {
+	dscp, errDSCP := tcpip.DSCP(raw)
+	for _, r := range q.rules {
+		if r.ports != nil && !r.ports[flow.TCPFlow.Src.Port] && !r.ports[flow.TCPFlow.Dst.Port] {
+			continue
+		}
+		if r.dscps != nil && (errDSCP != nil || !r.dscps[dscp]) {
+			continue
+		}
+		if r.destinations != nil && (flow.NetFlow.Dst == nil || !r.destinations[shila.GetNetworkAddressKey(flow.NetFlow.Dst)]) {
+			continue
+		}
+		return r.class
+	}
+	return q.defaultClass
+}
+
+// Send queues the packet of the given class for the channel towards the network endpoint. The
+// counter is updated as soon as the packet is either handed to the channel or, as the queue of
+// the class is full, dropped.
+func (q *QoS) Send(class Class, p *shila.Packet, out shila.PacketChannel, counter *Counter) {
+	if !q.scheduler.enqueue(class, item{packet: p, out: out, counter: counter}) {
+		counter.Drop()
+	}
+}
+
+// Purge drops the packets still queued w/ the counter of a connection. Called once the connection is
+// closed, its network endpoints no longer take packets.
+func (q *QoS) Purge(counter *Counter) {
+	q.scheduler.purge(counter)
+}
+
+// Counter counts the packets of a connection in one direction, safe for concurrent use.
+type Counter struct {
+	lock    sync.Mutex
+	packets uint64
+	bytes   uint64
+	dropped uint64
+}
+
+func (c *Counter) Count(p *shila.Packet) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.packets++
+	c.bytes += uint64(len(p.Payload))
+}
+
+func (c *Counter) Drop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dropped++
+}
+
+// Get returns the number of packets and bytes counted, as well as the number of packets dropped.
+func (c *Counter) Get() (packets uint64, bytes uint64, dropped uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.packets, c.bytes, c.dropped
+}
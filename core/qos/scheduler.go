@@ -0,0 +1,170 @@
+//
+package qos
+
+import (
+	"math"
+	"shila/core/shila"
+	"sync"
+	"time"
+)
+
+// Self-clocked fair queuing: every packet gets a virtual finish time, advanced by its size over the
+// weight of its class. The packet with the smallest finish time is sent next, the virtual time follows
+// the finish time of the packet sent last. The packets of a class are sent in the order they arrived.
+//
+// A packet stays queued as long as the channel towards its network endpoint does not take it. Meanwhile,
+// the packets of the class towards other network endpoints as well as the ones of other classes are sent.
+// The packets towards a network endpoint therefore keep their order. The scheduler never blocks on a
+// channel, neither on a full one nor on an unbuffered one w/o a receiver. The packets of a connection
+// which got closed are purged, its network endpoints no longer take any.
+
+// Interval to check whether a full channel towards a network endpoint has room again.
+const backedUpPollInterval = time.Millisecond
+
+type scheduler struct {
+	lock        sync.Mutex
+	ready       chan struct{}	// Signaled as soon as a packet is queued.
+	queues      []*queue 		// By class id.
+	queueSize   int
+	virtualTime float64
+}
+
+type queue struct {
+	weight     float64
+	items      []item
+	lastFinish float64
+}
+
+type item struct {
+	packet  *shila.Packet
+	out     shila.PacketChannel
+	counter *Counter
+	finish  float64
+}
+
+func newScheduler(classes []Class, queueSize int) *scheduler {
+	s := &scheduler{queueSize: queueSize, ready: make(chan struct{}, 1)}
+	for _, class := range classes {
+		s.queues = append(s.queues, &queue{weight: float64(class.Weight)})
+	}
+	return s
+}
+
+// Returns false if the queue of the class is full.
+func (s *scheduler) enqueue(class Class, it item) bool {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	q := s.queues[class.ID]
+	if len(q.items) >= s.queueSize {
+		return false
+	}
+
+	it.finish = math.Max(s.virtualTime, q.lastFinish) + float64(len(it.packet.Payload)) / q.weight
+	q.lastFinish = it.finish
+	q.items = append(q.items, it)
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (s *scheduler) serve() {
+	for {
+		sent, backedUp := s.sendNext()
+		if sent {
+			continue
+		}
+		if backedUp {
+			// There is no signal once a channel has room again.
+			select {
+			case <-s.ready:
+			case <-time.After(backedUpPollInterval):
+			}
+		} else {
+			<-s.ready
+		}
+	}
+}
+
+// Sends the next packet whose channel takes it. If there is none, backed up tells whether
+// there are packets waiting for a channel.
+func (s *scheduler) sendNext() (sent bool, backedUp bool) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	refused := make(map[shila.PacketChannel]bool)
+	for {
+		next, nextIndex := s.next(refused)
+		if next == nil {
+			return false, len(refused) > 0
+		}
+
+		it := next.items[nextIndex]
+		select {
+		case it.out <- it.packet:
+		default:
+			refused[it.out] = true
+			continue
+		}
+		it.counter.Count(it.packet)
+
+		copy(next.items[nextIndex:], next.items[nextIndex+1:])
+		next.items[len(next.items)-1] = item{}
+		next.items = next.items[:len(next.items)-1]
+		s.virtualTime = math.Max(s.virtualTime, it.finish)
+		return true, false
+	}
+}
+
+// Returns the queue and the index of the packet w/ the smallest finish time, skipping the packets towards
+// the channels which refused a packet already. The lock has to be held by the caller.
+func (s *scheduler) next(refused map[shila.PacketChannel]bool) (*queue, int) {
+
+	var next *queue
+	nextIndex := -1
+	for _, q := range s.queues {
+		// The first packet of the class which can be sent, the later ones towards a
+		// refusing channel have to wait for the ones in front of them anyway.
+		for index, candidate := range q.items {
+			if refused[candidate.out] {
+				continue
+			}
+			if cap(candidate.out) > 0 && len(candidate.out) >= cap(candidate.out) {
+				refused[candidate.out] = true
+				continue
+			}
+			if next == nil || candidate.finish < next.items[nextIndex].finish {
+				next, nextIndex = q, index
+			}
+			break
+		}
+	}
+	return next, nextIndex
+}
+
+// Removes the packets queued w/ the given counter, they are counted as dropped.
+func (s *scheduler) purge(counter *Counter) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, q := range s.queues {
+		kept := q.items[:0]
+		for _, it := range q.items {
+			if it.counter == counter {
+				counter.Drop()
+				continue
+			}
+			kept = append(kept, it)
+		}
+		for i := len(kept); i < len(q.items); i++ {
+			q.items[i] = item{}
+		}
+		q.items = kept
+	}
+}
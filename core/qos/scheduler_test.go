@@ -0,0 +1,119 @@
+//
+package qos
+
+import (
+	"shila/core/shila"
+	"testing"
+	"time"
+)
+
+func TestBackedUpChannelHoldsPackets(t *testing.T) {
+
+	classes := []Class{{ID: 0, Name: "bulk", Weight: 1}, {ID: 1, Name: "interactive", Weight: 1}}
+	s := newScheduler(classes, 10)
+	go s.serve()
+
+	backedUp := make(shila.PacketChannel, 1)
+	other    := make(shila.PacketChannel, 10)
+	var backedUpCounter, otherCounter Counter
+
+	// The first packet fills the channel, the following ones have to wait for room.
+	packets := make([]*shila.Packet, 3)
+	for i := range packets {
+		packets[i] = &shila.Packet{Payload: []byte{byte(i)}}
+		if !s.enqueue(classes[0], item{packet: packets[i], out: backedUp, counter: &backedUpCounter}) {
+			t.Fatal("Queue full.")
+		}
+	}
+	// Packets of the same class towards another channel, as well as the ones of other classes pass.
+	s.enqueue(classes[0], item{packet: &shila.Packet{}, out: other, counter: &otherCounter})
+	s.enqueue(classes[1], item{packet: &shila.Packet{}, out: other, counter: &otherCounter})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-other:
+		case <-time.After(time.Second):
+			t.Fatal("Packets held back by a full channel.")
+		}
+	}
+
+	for i := range packets {
+		select {
+		case p := <-backedUp:
+			if p != packets[i] {
+				t.Fatalf("Packet %d out of order.", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Packet %d not sent.", i)
+		}
+	}
+	if _, _, dropped := backedUpCounter.Get(); dropped != 0 {
+		t.Fatalf("Dropped %d packets.", dropped)
+	}
+}
+
+func TestFullQueueDrops(t *testing.T) {
+
+	classes := []Class{{ID: 0, Name: "bulk", Weight: 1}}
+	s := newScheduler(classes, 2)
+
+	// Not served, the queue fills up.
+	out := make(shila.PacketChannel)
+	for i := 0; i < 2; i++ {
+		if !s.enqueue(classes[0], item{packet: &shila.Packet{}, out: out, counter: &Counter{}}) {
+			t.Fatalf("Packet %d dropped.", i)
+		}
+	}
+	if s.enqueue(classes[0], item{packet: &shila.Packet{}, out: out, counter: &Counter{}}) {
+		t.Fatal("Packet queued beyond the size of the queue.")
+	}
+}
+
+func TestUnbufferedChannelDoesNotBlock(t *testing.T) {
+
+	classes := []Class{{ID: 0, Name: "bulk", Weight: 1}}
+	s := newScheduler(classes, 10)
+	go s.serve()
+
+	// No one receives from the unbuffered channel (e.g. the endpoint stopped after an issue).
+	stalled := make(shila.PacketChannel)
+	other   := make(shila.PacketChannel, 1)
+	var stalledCounter, otherCounter Counter
+	s.enqueue(classes[0], item{packet: &shila.Packet{}, out: stalled, counter: &stalledCounter})
+	s.enqueue(classes[0], item{packet: &shila.Packet{}, out: other, counter: &otherCounter})
+
+	select {
+	case <-other:
+	case <-time.After(time.Second):
+		t.Fatal("Scheduler blocked on the unbuffered channel.")
+	}
+
+	// Once purged, the packet is counted as dropped and nothing is waiting anymore.
+	s.purge(&stalledCounter)
+	if _, _, dropped := stalledCounter.Get(); dropped != 1 {
+		t.Fatalf("Dropped %d packets, want 1.", dropped)
+	}
+	if sent, backedUp := s.sendNext(); sent || backedUp {
+		t.Fatal("Packet still queued after the purge.")
+	}
+}
+
+func TestPurgeFreesQueue(t *testing.T) {
+
+	classes := []Class{{ID: 0, Name: "bulk", Weight: 1}}
+	s := newScheduler(classes, 2)
+
+	// Not served, the packets of the closed connection occupy the queue.
+	out := make(shila.PacketChannel)
+	var closed, open Counter
+	for i := 0; i < 2; i++ {
+		s.enqueue(classes[0], item{packet: &shila.Packet{}, out: out, counter: &closed})
+	}
+	if s.enqueue(classes[0], item{packet: &shila.Packet{}, out: out, counter: &open}) {
+		t.Fatal("Packet queued beyond the size of the queue.")
+	}
+
+	s.purge(&closed)
+	if !s.enqueue(classes[0], item{packet: &shila.Packet{}, out: out, counter: &open}) {
+		t.Fatal("Queue still full after the purge.")
+	}
+}
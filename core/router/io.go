@@ -9,6 +9,7 @@ import (
 	"shila/core/shila"
 	"shila/io/structure"
 	"shila/log"
+	"shila/networkSide"
 )
 
 func loadRoutingEntriesFromDisk() ([]structure.RoutingEntryJSON, error) {
@@ -29,6 +30,9 @@ func loadRoutingEntriesFromDisk() ([]structure.RoutingEntryJSON, error) {
 
 func (router *Router) batchInsert(entries []structure.RoutingEntryJSON) error {
 
+	// The destination addresses depend on the backbone protocol in use.
+	generator := networkSide.NewAddressGenerator()
+
 	// Invalid entries are silently ignored and not inserted!
 	for _, entry := range entries {
 
@@ -39,7 +43,7 @@ func (router *Router) batchInsert(entries []structure.RoutingEntryJSON) error {
 		}
 		key := shila.GetIPAddressPortKey(ipAddressPort)
 
-		dst, err := entry.Flow.GetNetworkAddress()
+		dst, err := entry.Flow.GetNetworkAddress(generator)
 		if err != nil {
 			log.Error.Println(router.Says(PrependError(err, "Skipped insertion of routing Entry.").Error()))
 			continue
@@ -0,0 +1,31 @@
+//
+package router
+
+import (
+	"fmt"
+	"github.com/scionproto/scion/go/lib/snet"
+	"shila/config"
+	"shila/core/shila"
+	"shila/networkSide/networkEndpoint"
+)
+
+const (
+	innerHeadersLen       = 40 + 20 + 40		// IP (up to IPv6) and TCP header of the segment, incl. maximal TCP options.
+	minMSS                = 88					// Smallest MSS used by Linux, smaller values are raised to it.
+)
+
+// Largest MSS such that no segment sent along the path needs fragmentation on the backbone.
+// Zero if the MTU of the path is unknown or if clamping is disabled. Fails if the MTU of the
+// path is too small for any segment, the path is then of no use.
+func getMSS(path snet.Path) (int, error) {
+
+	if path == nil || !config.Config.Router.ClampMSS || path.MTU() == 0 {
+		return 0, nil
+	}
+
+	mss := int(path.MTU()) - networkEndpoint.BackboneOverhead(path) - innerHeadersLen
+	if mss < minMSS {
+		return 0, shila.TolerableError(fmt.Sprint("MTU ", path.MTU(), " of path too small, MSS would be ", mss, "."))
+	}
+	return mss, nil
+}
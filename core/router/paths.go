@@ -3,11 +3,18 @@ package router
 import (
 	"github.com/netsec-ethz/scion-apps/pkg/appnet"
 	"github.com/scionproto/scion/go/lib/snet"
+	"math"
+	"net"
+	"shila/config"
 	"shila/core/shila"
 	"shila/log"
+	"shila/networkSide"
+	"shila/networkSide/networkEndpoint"
+	"time"
 )
 
 type paths struct {
+	dst 		shila.NetworkAddress
 	storage 	[]PathWrapper
 	mapping 	map[shila.TCPFlowKey] int
 	sharability int
@@ -15,21 +22,47 @@ type paths struct {
 
 type PathWrapper struct {
 	path  		snet.Path
+	ipPath		shila.NetworkPath	// Path to a plain IP destination, nil for SCION destinations.
 	edgeIndices []int
 	nUsed 		int
 	rawMetrics 	[]int
+	mss			int			// Zero if there is no need to clamp the MSS.
+}
+
+// Path handed out to the flows using the wrapped path.
+func (pw *PathWrapper) networkPath() shila.NetworkPath {
+	if pw.ipPath != nil {
+		return pw.ipPath
+	}
+	if pw.path == nil {
+		return nil
+	}
+	return pw.path
 }
 
 // If there is any error in the creation of the paths we just do not specify any. This is oke.
 func newPaths(dstAddr shila.NetworkAddress) (paths, error) {
 
+	if ipPaths, err := wrapIPPaths(dstAddr); err != nil {
+		log.Error.Print("Unable to create IP paths. ", err.Error())
+		return paths{}, err
+	} else if ipPaths != nil {
+		return paths{
+			dst: 			dstAddr,
+			storage: 		trowAwayOddPaths(ipPaths),
+			mapping: 		make(map[shila.TCPFlowKey] int),
+			sharability: 	0,
+		}, nil
+	}
+
 	scionPaths, err := fetchAndWrapSCIONPaths(dstAddr)
 	if err != nil {
 		log.Error.Print("Unable to fetch SCION paths. ", err.Error())
 		return paths{}, err
 	} else if scionPaths == nil {
-		// Destination address is in the local IA
+		// Destination address is in the local IA (or not a SCION address at all)
 		return paths{
+			dst: 			dstAddr,
 			storage: 		[]PathWrapper{{path: nil, rawMetrics: []int{0,0}}},
 			mapping: 		make(map[shila.TCPFlowKey] int),
 			sharability: 	0,
@@ -52,6 +85,7 @@ func newPaths(dstAddr shila.NetworkAddress) (paths, error) {
 	}
 
 	return paths{
+		dst: 			dstAddr,
 		storage: 		scionPaths,
 		mapping: 		make(map[shila.TCPFlowKey] int),
 		sharability: 	sharabilityValue,
@@ -66,16 +100,31 @@ func (p *paths) get(key shila.TCPFlowKey) (*PathWrapper, int) {
 	
 	useCountOfNext := len(p.mapping) / len(p.storage) // #used / #total
 
+	// Among the least used paths, the one w/ the smallest round trip time measured by the heartbeats
+	// of the network endpoints is taken. Paths w/o a measurement come last, in their given order.
+	next := -1
+	var nextRTT time.Duration
 	for index, pathWrapper := range p.storage {
-		if pathWrapper.nUsed == useCountOfNext {
-			p.storage[index].nUsed++
-			p.mapping[key] = index
-
-			return &pathWrapper, len(p.mapping)
+		if pathWrapper.nUsed != useCountOfNext {
+			continue
+		}
+		rtt, ok := networkEndpoint.RTT(p.dst, pathWrapper.networkPath())
+		if !ok {
+			rtt = math.MaxInt64
 		}
+		if next == -1 || rtt < nextRTT {
+			next, nextRTT = index, rtt
+		}
+	}
+	if next == -1 {
+		return nil, -1
 	}
 
-	return nil, -1
+	p.storage[next].nUsed++
+	p.mapping[key] = next
+	pathWrapper := p.storage[next]
+
+	return &pathWrapper, len(p.mapping)
 }
 
 func (p *paths) free(key shila.TCPFlowKey) {
@@ -86,7 +135,12 @@ func (p *paths) free(key shila.TCPFlowKey) {
 }
 
 func fetchAndWrapSCIONPaths(dstAddr shila.NetworkAddress) ([]PathWrapper, error) {
-	dstAddrIA := dstAddr.(*snet.UDPAddr).IA
+	scionAddr, ok := dstAddr.(*snet.UDPAddr)
+	if !ok {
+		// No path functionality w/ plain IP.
+		return nil, nil
+	}
+	dstAddrIA := scionAddr.IA
 	if paths, err := appnet.QueryPaths(dstAddrIA); err != nil {
 		return nil, err
 	} else if paths == nil {
@@ -95,10 +149,34 @@ func fetchAndWrapSCIONPaths(dstAddr shila.NetworkAddress) ([]PathWrapper, error)
 	} else {
 		pathsWrapped := make([]PathWrapper, 0, len(paths))
 		for _, path := range paths {
+			mss, err := getMSS(path)
+			if err != nil {
+				log.Error.Print("Skipping path ", path, ". ", err.Error())
+				continue
+			}
 			rawMetrics := []int{int(path.MTU()), len(path.Interfaces())}
-			pathsWrapped = append(pathsWrapped, PathWrapper{path: path, nUsed: 0, rawMetrics: rawMetrics })
+			pathsWrapped = append(pathsWrapped, PathWrapper{path: path, nUsed: 0, rawMetrics: rawMetrics, mss: mss })
 			//log.Info.Printf("[%2d] %s\n", i, fmt.Sprintf("%s", path))
 		}
+		if len(pathsWrapped) == 0 {
+			return nil, shila.TolerableError("No path w/ a sufficient MTU.")
+		}
 		return pathsWrapped, nil
 	}
-}
\ No newline at end of file
+}
+// The paths to a plain IP destination are the configured local addresses. Nil if there are none.
+func wrapIPPaths(dstAddr shila.NetworkAddress) ([]PathWrapper, error) {
+	if _, ok := dstAddr.(*net.UDPAddr); !ok || len(config.Config.Router.IPPaths) == 0 {
+		return nil, nil
+	}
+	generator := networkSide.NewPathGenerator()
+	pathsWrapped := make([]PathWrapper, 0, len(config.Config.Router.IPPaths))
+	for _, p := range config.Config.Router.IPPaths {
+		path, err := generator.New(p)
+		if err != nil {
+			return nil, err
+		}
+		pathsWrapped = append(pathsWrapped, PathWrapper{ipPath: path, rawMetrics: []int{0,0}})
+	}
+	return pathsWrapped, nil
+}
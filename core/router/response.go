@@ -19,6 +19,7 @@ type Response struct {
 	FlowCount    int
 	RawMetrics   []int
 	Sharability  int
+	MSS          int		// Clamp for the MSS of the segments along the path, zero if no clamping is required.
 }
 
 type FlowCategory uint8
@@ -63,9 +63,10 @@ func (router *Router) InsertEndpointTokenToTCPFlow(p *shila.Packet) error {
 	router.lock.Lock()
 	defer router.lock.Unlock()
 
-	if key, ok, err := mptcp.GetSenderKey(p.Payload); ok {
+	// The version of MPTCP is negotiated per connection, it determines how the token is derived from the key.
+	if key, version, ok, err := mptcp.GetSenderKey(p.Payload); ok {
 		if err == nil {
-			if token, err := mptcp.EndpointKeyToToken(key); err != nil {
+			if token, err := mptcp.EndpointKeyToToken(key, version); err != nil {
 				return shila.PrependError(err, fmt.Sprint("Unable to convert token from key."))
 			} else {
 				if _, ok := router.mainTCPFlows[token]; ok {
@@ -161,8 +162,9 @@ func (router *Router) routeMainFlow(packet *shila.Packet) (Response, error) {
 				FlowCategory: MainFlow,
 				MainTCPFlow:  packet.Flow.TCPFlow,
 				FlowCount:    flowCount,
-				Path:         pathWrapper.path,
+				Path:         pathWrapper.networkPath(),
 				RawMetrics:   pathWrapper.rawMetrics,
+				MSS:          pathWrapper.mss,
 				Sharability:  entry.Paths.sharability,
 			},nil
 		}
@@ -185,8 +187,9 @@ func (router *Router) routeSubFlow(packet *shila.Packet, tcpFlow shila.TCPFlow)
 			FlowCategory: SubFlow,
 			MainTCPFlow:  tcpFlow,
 			FlowCount:    subFlowCount,
-			Path:         pathWrapper.path,
+			Path:         pathWrapper.networkPath(),
 			RawMetrics:   pathWrapper.rawMetrics,
+			MSS:          pathWrapper.mss,
 			Sharability:  entry.Paths.sharability,
 		}, nil
 	}
@@ -1,6 +1,8 @@
 //
 package shila
 
+import "sync/atomic"
+
 type EntityStateIdentifier uint8
 
 const (
@@ -21,26 +23,32 @@ func (es EntityStateIdentifier) String() string {
 	return "Unknown"
 }
 
+// The state of an entity is read by its goroutines while it is torn down, it is therefore accessed atomically.
 type EntityState struct {
-	EntityStateIdentifier
+	state uint32
 }
 
 func NewEntityState() EntityState {
-	return EntityState{EntityStateIdentifier: Uninitialized}
+	return EntityState{state: uint32(Uninitialized)}
 }
 
 func(es *EntityState) Set(s EntityStateIdentifier) {
-	es.EntityStateIdentifier = s
+	atomic.StoreUint32(&es.state, uint32(s))
 }
 
 func (es *EntityState) Not(s EntityStateIdentifier) bool {
-	return es.EntityStateIdentifier != s
+	return es.get() != s
 }
 
 func (es *EntityState) Is(s EntityStateIdentifier) bool {
-	return es.EntityStateIdentifier == s
+	return es.get() == s
+}
+
+// Value receiver, such that the state is printed by fmt as well.
+func (es EntityState) String() string {
+	return es.get().String()
 }
 
-func (es *EntityState) String() string {
-	return es.EntityStateIdentifier.String()
-}
\ No newline at end of file
+func (es *EntityState) get() EntityStateIdentifier {
+	return EntityStateIdentifier(atomic.LoadUint32(&es.state))
+}
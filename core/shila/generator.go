@@ -30,6 +30,7 @@ type NetworkClientEndpoint interface {
 type NetworkServerEndpoint interface {
 	Endpoint
 	SetupAndRun() 	error
+	Key()			NetworkAddressKey
 }
 
 type NetworkAddress interface {
@@ -0,0 +1,302 @@
+//
+package main
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"shila/config"
+	"shila/core/connection"
+	"shila/core/router"
+	"shila/core/shila"
+	"shila/kernelSide"
+	"shila/kernelSide/kernelEndpoint"
+	"shila/layer/mptcp"
+	"shila/log"
+	"shila/networkSide"
+	"shila/networkSide/loopback"
+	"shila/shutdown"
+	"shila/workingSide"
+	"testing"
+	"time"
+)
+
+// Two instances connected through the loopback fabric, the tcp endpoints on both ends are faked:
+//
+//   client app -- egress (A) -- network side (A) ~~ fabric ~~ network side (B) -- ingress (B) -- server app
+//
+// The instance A routes the address of the server app to the instance B.
+var (
+	hostA         = net.ParseIP("10.1.0.1")
+	hostB         = net.ParseIP("10.1.0.2")
+	egressA       = net.ParseIP("10.7.0.2")
+	secondEgressA = net.ParseIP("10.7.0.3")
+	ingressB      = net.ParseIP("10.7.0.9")
+	serverApp     = net.TCPAddr{IP: ingressB, Port: 11111}
+)
+
+const (
+	keyA = 0x1111111111111111
+	keyB = 0x2222222222222222
+	timeout = 10 * time.Second
+)
+
+func TestMain(m *testing.M) {
+
+	log.Init()
+	shutdown.Init()
+
+	config.Config.NetworkSide.Backbone = "loopback"
+	config.Config.Connection.WaitingTimeTrafficConnEstablishment = 0
+	// A silent peer is detected within a fraction of a second.
+	config.Config.NetworkEndpoint.HeartbeatInterval = 20
+	config.Config.NetworkEndpoint.HeartbeatMissThreshold = 5
+	config.Config.NetworkEndpoint.WaitingTimeAfterConnectionIssue = 0
+
+	// The routing entries are loaded from disk by every router.
+	dir, err := ioutil.TempDir("", "shila")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Config.NetFlow.Path = filepath.Join(dir, "routing.json")
+	routing := `[{"key": {"ip": "` + serverApp.IP.String() + `", "port": "11111"}, "flow": {"address": "` + hostB.String() + `:11111"}}]`
+	if err := ioutil.WriteFile(config.Config.NetFlow.Path, []byte(routing), 0644); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type instance struct {
+	kernelSide  *kernelSide.Manager
+	networkSide *networkSide.Manager
+	egress      *workingSide.Manager
+}
+
+// Starts an instance the same way as main does, but w/ fake kernel endpoints and the given fabric.
+func startInstance(t *testing.T, fabric *loopback.Fabric, ip net.IP, endpoints ...*kernelEndpoint.Fake) *instance {
+
+	trafficChannelPubs := shila.PacketChannelPubChannels{
+		Ingress: make(shila.PacketChannelPubChannel),
+		Egress:  make(shila.PacketChannelPubChannel),
+	}
+	endpointIssues := shila.EndpointIssuePubChannels{
+		Ingress: make(shila.EndpointIssuePubChannel),
+		Egress:  make(shila.EndpointIssuePubChannel),
+	}
+
+	i := &instance{
+		kernelSide:  kernelSide.NewWithEndpoints(trafficChannelPubs, endpointIssues, endpoints...),
+		networkSide: networkSide.NewWithSpecificManager(networkSide.NewLoopbackSpecificManager(fabric, ip),
+			trafficChannelPubs, endpointIssues),
+	}
+	if err := i.kernelSide.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.networkSide.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	ingress := workingSide.New(connection.NewMapping(i.kernelSide, i.networkSide, router.New(), nil),
+		trafficChannelPubs.Ingress, endpointIssues.Ingress, workingSide.Ingress)
+	i.egress = workingSide.New(connection.NewMapping(i.kernelSide, i.networkSide, router.New(), nil),
+		trafficChannelPubs.Egress, endpointIssues.Egress, workingSide.Egress)
+	if err := ingress.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.egress.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.networkSide.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.kernelSide.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func (i *instance) stop() {
+	_ = i.networkSide.CleanUp()
+	_ = i.kernelSide.CleanUp()
+}
+
+func newFake(ip net.IP, role shila.EndpointRole) *kernelEndpoint.Fake {
+	return kernelEndpoint.NewFake(ip, role)
+}
+
+// Builds an IPv4/TCP frame carrying the given MPTCP options.
+func frame(t *testing.T, src net.TCPAddr, dst net.TCPAddr, ack bool, options ...mptcp.Option) []byte {
+
+	ip := layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    src.IP.To4(),
+		DstIP:    dst.IP.To4(),
+	}
+	tcp := layers.TCP{
+		SrcPort: layers.TCPPort(src.Port),
+		DstPort: layers.TCPPort(dst.Port),
+		Seq:     1000,
+		SYN:     true,
+		ACK:     ack,
+		Window:  65535,
+	}
+	if ack {
+		tcp.Ack = 1001
+	}
+	for _, option := range options {
+		tcpOption, err := mptcp.SerializeOption(option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tcp.Options = append(tcp.Options, tcpOption)
+	}
+	if err := tcp.SetNetworkLayerForChecksum(&ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, &ip, &tcp); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func capable(key uint64) mptcp.Option {
+	return mptcp.CapableOptionSender{
+		CapableOption: mptcp.CapableOption{Version: mptcp.Version0, H: true},
+		SenderKey:     key,
+	}
+}
+
+// Returns the next frame of the given flow the fake endpoint hands to the kernel.
+func expectFrame(t *testing.T, fake *kernelEndpoint.Fake, src net.TCPAddr, dst net.TCPAddr) []byte {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case raw, ok := <-fake.Frames():
+			if !ok {
+				t.Fatal(fake.Says("Torn down."))
+			}
+			tcpFlow, err := shila.GetTCPFlow(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tcpFlow.Src.String() == src.String() && tcpFlow.Dst.String() == dst.String() {
+				return raw
+			}
+		case <-deadline:
+			t.Fatal(fake.Says("No frame from " + src.String() + " to " + dst.String() + "."))
+		}
+	}
+}
+
+// Establishes the main flow, i.e. the SYN and the SYN/ACK w/ MP_CAPABLE pass both instances.
+func establishMainFlow(t *testing.T, egress *kernelEndpoint.Fake, ingress *kernelEndpoint.Fake, clientApp net.TCPAddr) {
+
+	if err := egress.Inject(frame(t, clientApp, serverApp, false, capable(keyA))); err != nil {
+		t.Fatal(err)
+	}
+	syn := expectFrame(t, ingress, clientApp, serverApp)
+	if key, _, ok, err := mptcp.GetSenderKey(syn); err != nil || !ok || key != keyA {
+		t.Fatalf("SYN does not carry the key of the client, got %x (%v).", key, err)
+	}
+
+	if err := ingress.Inject(frame(t, serverApp, clientApp, true, capable(keyB))); err != nil {
+		t.Fatal(err)
+	}
+	synAck := expectFrame(t, egress, serverApp, clientApp)
+	if key, _, ok, err := mptcp.GetSenderKey(synAck); err != nil || !ok || key != keyB {
+		t.Fatalf("SYN/ACK does not carry the key of the server, got %x (%v).", key, err)
+	}
+}
+
+func TestMainFlowEstablishment(t *testing.T) {
+
+	fabric := loopback.NewFabric(1, loopback.PathProperties{Delay: time.Millisecond})
+	defer fabric.Close()
+
+	egress  := newFake(egressA, shila.EgressKernelEndpoint)
+	ingress := newFake(ingressB, shila.IngressKernelEndpoint)
+	a := startInstance(t, fabric, hostA, egress)
+	defer a.stop()
+	b := startInstance(t, fabric, hostB, ingress)
+	defer b.stop()
+
+	establishMainFlow(t, egress, ingress, net.TCPAddr{IP: egressA, Port: 40000})
+}
+
+func TestSubflowEstablishment(t *testing.T) {
+
+	fabric := loopback.NewFabric(1, loopback.PathProperties{Delay: time.Millisecond})
+	defer fabric.Close()
+
+	egress       := newFake(egressA, shila.EgressKernelEndpoint)
+	secondEgress := newFake(secondEgressA, shila.EgressKernelEndpoint)
+	ingress      := newFake(ingressB, shila.IngressKernelEndpoint)
+	a := startInstance(t, fabric, hostA, egress, secondEgress)
+	defer a.stop()
+	b := startInstance(t, fabric, hostB, ingress)
+	defer b.stop()
+
+	establishMainFlow(t, egress, ingress, net.TCPAddr{IP: egressA, Port: 40001})
+
+	// The subflow is routed by the token of the server, learned from its key in the SYN/ACK of the main flow.
+	token, err := mptcp.EndpointKeyToToken(keyB, mptcp.Version0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subflowApp := net.TCPAddr{IP: secondEgressA, Port: 40002}
+	join := mptcp.JoinOptionSYN{ReceiverToken: uint32(token), SenderRandomNumber: 42}
+	if err := secondEgress.Inject(frame(t, subflowApp, serverApp, false, join)); err != nil {
+		t.Fatal(err)
+	}
+
+	syn := expectFrame(t, ingress, subflowApp, serverApp)
+	if received, err := mptcp.GetReceiverToken(syn); err != nil || received != token {
+		t.Fatalf("SYN of the subflow does not carry the token of the server, got %x (%v).", received, err)
+	}
+
+	if err := ingress.Inject(frame(t, serverApp, subflowApp, true, mptcp.JoinOptionSYNACK{SenderRandomNumber: 43})); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame(t, secondEgress, serverApp, subflowApp)
+}
+
+func TestSilentPeerClosesConnection(t *testing.T) {
+
+	fabric := loopback.NewFabric(1, loopback.PathProperties{Delay: time.Millisecond})
+	defer fabric.Close()
+
+	egress  := newFake(egressA, shila.EgressKernelEndpoint)
+	ingress := newFake(ingressB, shila.IngressKernelEndpoint)
+	a := startInstance(t, fabric, hostA, egress)
+	defer a.stop()
+	b := startInstance(t, fabric, hostB, ingress)
+	defer b.stop()
+
+	clientApp := net.TCPAddr{IP: egressA, Port: 40003}
+	establishMainFlow(t, egress, ingress, clientApp)
+
+	// The instance B no longer answers the heartbeats.
+	fabric.SetPathProperties(hostA, hostB, loopback.PathProperties{Loss: 1})
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, stats := range a.egress.Stats() {
+			if stats.TCPFlow.Src.String() == clientApp.String() && stats.State == "Closed" {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Connection to the silent peer not closed.")
+}
@@ -6,15 +6,23 @@ type ConfigJSON struct {
 	NetFlow				NetFlowConfigJSON
 	KernelSide			KernelSideConfigJSON
 	KernelEndpoint		KernelEndpointConfigJSON
+	MPTCP				MPTCPConfigJSON
 	Logging				LoggingConfigJSON
 	NetworkSide			NetworkSideConfigJSON
 	NetworkEndpoint		NetworkEndpointConfigJSON
 	Router				RouterConfigJSON
+	Security			SecurityConfigJSON
+	Loopback			LoopbackConfigJSON
+	AccessControl		AccessControlConfigJSON
+	Capture				CaptureConfigJSON
+	Supervisor			SupervisorConfigJSON
+	QoS					QoSConfigJSON
 	Config				ConfigConfigJSON
 }
 
 type WorkingSideConfigJSON struct {
-	NumberOfWorkerPerChannel 			int					// Number of worker per packet channel.
+	NumberOfWorkerPerChannel 			int					// Number of worker per packet channel, the packets of a tcp flow are always processed by the same worker.
+	SizeWorkerBuffer					int					// Size (shila packets) of the buffer of each worker. (Just if there are several workers per channel.)
 }
 
 type ConnectionConfigJSON struct {
@@ -34,17 +42,33 @@ type KernelSideConfigJSON struct {
 	EgressNamespace          			string				// The name of the egress namespace.
 	IngressNamespace         			string				// The name of the ingress namespace.
 	IngressIP                			string				// The IP of the ingress virtual interface.
+	EgressSubnet						string				// The subnet (CIDR) the addresses of the egress virtual interfaces are taken from.
+	EgressSubnetIPv6					string				// The subnet (CIDR) the IPv6 addresses of the egress virtual interfaces are taken from.
+	EnableIPv6							bool				// Assign IPv6 addresses to the virtual interfaces as well. (Needs IPv6 on the host.)
+	IngressIPv6							string				// The IPv6 of the ingress virtual interface.
+	StateFilePath						string				// File recording what the kernel side created, to clean up after a crash. (Empty to disable, just the leftovers derived from the configuration are cleaned up then.)
+	ControlSocketPath					string				// Unix socket through which egress interfaces are added and removed while shila is running. (Empty to disable.)
+	TransparentInterception				bool				// Intercept the TCP traffic towards the intercepted prefixes in the default namespace instead of using dedicated namespaces.
+	InterceptedPrefixes					[]string			// Destination prefixes (CIDR) whose TCP traffic is steered through shila in the transparent mode.
+	InterceptionMark					int					// Firewall mark of the connections received through shila in the transparent mode, their replies are routed back through shila.
+	InterceptionRulePriority			int					// Priority of the rules for the intercepted prefixes, has to be lower (i.e. larger) than the one of the rules of the egress interfaces.
+	RoutingTableOffset					int					// The routing table of a virtual interface is its number plus this offset, the range must not be used by the host.
 }
 
 type KernelEndpointConfigJSON struct {
 	SizeIngressBuffer 					int					// Size (shila packets) of the ingress buffer.
 	SizeEgressBuffer  					int					// Size (shila packets) of the egress buffer.
-	SizeRawIngressBuffer 				int					// Size (bytes) of the raw ingress buffer.
-	SizeRawIngressStorage				int					// Size (bytes) of the storage holding raw ingress data.
-	ReadSizeRawIngress    				int					// Minimal number of bytes read from the raw ingress channel at once.
+	MaxFrameSize						int					// Size (bytes) of the largest frame read from the virtual interface.
 	WaitingTimeUntilEscalation			int					// Time to wait until a kernel endpoint escalates after a connection to a tun device has been lost.
 }
 
+type MPTCPConfigJSON struct {
+	PathManager							string				// Path manager of MPTCP (net.mptcp.mptcp_path_manager). (Empty to leave as is.)
+	Scheduler							string				// Scheduler of MPTCP (net.mptcp.mptcp_scheduler). (Empty to leave as is.)
+	CongestionControl					string				// Congestion control of TCP (net.ipv4.tcp_congestion_control). (Empty to leave as is.)
+	Sysctls								map[string]string	// Further kernel parameters, e.g. "net.mptcp.mptcp_checksum": "0".
+}
+
 type LoggingConfigJSON struct {
 	PrintVerbose 						bool				// Print verbose messages.
 	DoEgressTimestamping				bool				// Generates and logs a timestamp before a packet is sent out (Just if packet has specific load.)
@@ -54,6 +78,44 @@ type LoggingConfigJSON struct {
 	IngressTimestampLogPath				string				// Where to dump the log files for the ingress timestamps.
 	EgressTimestampLogAdditionalLine	string				// Additional line which is added to the egress timestamp log.
 	IngressTimestampLogAdditionalLine	string				// Additional line which is added to the ingress timestamp log.
+	StatsInterval						int					// Interval (s) between two logs of the statistics of the connections and holding areas. (Zero disables them.)
+}
+
+type CaptureConfigJSON struct {
+	Enabled								bool				// Record the packets crossing the kernel and network endpoints into a pcapng file.
+	Path								string				// Where to write the capture, rotated files get a numbered suffix (<path>.1, ..).
+	MaxFileSize							int					// Size (bytes) after which the capture file is rotated. (Zero disables the rotation.)
+	MaxFiles							int					// Number of capture files kept, including the current one.
+	FlushInterval						int					// The capture is flushed to file at least in this interval (s).
+	Filter								[]string			// Just record the packets of these TCP flows (any if empty). (<ip>:<port>|<ip>:<port>)
+}
+
+type SupervisorConfigJSON struct {
+	MaxRestarts							int					// Number of failures of an endpoint within the restart window which are recovered, shila is shut down on the next one.
+	RestartWindow						int					// Time (s) during which the failures of an endpoint are counted.
+	InitialBackoff						int					// Time (ms) to wait before the first restart, doubled with every further failure within the window.
+	MaxBackoff							int					// Maximal time (ms) to wait before a restart.
+}
+
+type QoSConfigJSON struct {
+	Enabled								bool				// Schedule the packets towards the network endpoints by the class of their connection (weighted fair queuing).
+	Classes								[]QoSClassJSON		// The classes, each has its own queue.
+	DefaultClass						string				// Class of the connections no rule applies to.
+	Rules								[]QoSRuleJSON		// Assign the connections to the classes, the first matching rule applies.
+	QueueSize							int					// Size (shila packets) of the queue of each class, further packets are dropped.
+}
+
+type QoSClassJSON struct {
+	Name								string				// Name of the class.
+	Weight								int					// Share of the class, relative to the weights of the other classes.
+}
+
+// A rule applies to a connection if all of its (non empty) criteria match.
+type QoSRuleJSON struct {
+	Class								string				// Class of the matching connections.
+	Ports								[]int				// Local or remote tcp ports of the connection (any if empty).
+	DSCPs								[]int				// DSCP of the first packet of the connection (any if empty).
+	Destinations						[]string			// Network addresses as in the routing entries (any if empty). (Just the connecting side knows them.)
 }
 
 type ConfigConfigJSON struct {
@@ -63,6 +125,7 @@ type ConfigConfigJSON struct {
 
 type NetworkSideConfigJSON struct {
 	ContactingServerPort           	 	int					// Default port on which shila is listening for incoming contacting connections.
+	Backbone							string				// Protocol used for the backbone connections. (udp, quic, ip, loopback)
 }
 
 type NetworkEndpointConfigJSON struct {
@@ -70,10 +133,58 @@ type NetworkEndpointConfigJSON struct {
 	SizeEgressBuffer               		int           		// Size (shila packets) of the Egress buffer.
 	SizeRawIngressStorage          	 	int           		// Size (bytes) of the storage holding raw Ingress data.
 	WaitingTimeAfterConnectionIssue	 	int 				// Time to wait after a connection issue has occurred.
-	ServerResendInterval           	 	int 				// Time to wait until a server endpoint tries to resend a packet.
-	SizeHoldingArea                	 	int           		// Initial size (shila packets) of the holding area.
+	BackboneIdleTimeout					int					// Time (s) after which a server removes a backbone connection w/o payload. (Zero disables it.)
+	HoldingTime							int					// Maximal time (ms) a server network endpoint holds a packet w/o backbone connection.
+	HoldingAreaMaxPackets				int					// Maximal number of packets held per destination.
+	HoldingAreaMaxBytes					int					// Maximal number of bytes (payload) held per destination.
+	HandshakeTimeout					int					// Time (ms) a client waits for the acknowledgment of its control message, doubled after every retransmission.
+	HandshakeAttempts					int					// Maximal number of control message transmissions until a client gives up.
+	HeartbeatInterval					int					// Interval (ms) between two heartbeats of a client network endpoint. (Zero disables them.)
+	HeartbeatMissThreshold				int					// Number of intervals w/o any sign of life after which a peer is considered dead.
+	Batching							bool				// Coalesce small payloads bound for the same backbone connection into one datagram.
+	BatchingFlushDelay					int					// Maximal time (µs) a payload waits for further payloads to be batched with.
+	BatchingMaxDatagramSize				int					// Maximal size (bytes) of a batch datagram, lowered to the path MTU if known.
+	QUICCertificatePath					string				// Certificate of the QUIC server network endpoints. (Self signed one is generated if empty.)
+	QUICKeyPath							string				// Key corresponding to the certificate of the QUIC server network endpoints.
+}
+
+type SecurityConfigJSON struct {
+	Enabled								bool				// Encrypt and authenticate all backbone traffic. (Not supported by the QUIC backbone.)
+	SessionAcceptanceWindow				int					// Maximal age (s) of a session accepted by a server network endpoint.
+	PeerKeys							[]PeerKeyJSON		// Pre-shared keys of the peers, all other peers are rejected.
+}
+
+type AccessControlConfigJSON struct {
+	Enabled								bool				// Check the peers of incoming contacting connections.
+	Allow								[]string			// Peers allowed to contact (any if empty). (*, <isd>-<as>, <isd>-<as>,<host>, <host>)
+	Deny								[]string			// Peers not allowed to contact, takes precedence over allow.
+	AllowedPorts						[]int				// Local tcp ports which may be reached (any if empty).
+}
+
+type LoopbackConfigJSON struct {
+	IP									string				// Address of this instance within the in-memory loopback network.
+	Seed								int64				// Seed of the random decisions (loss, reordering) of the loopback network.
+	Delay								int					// Delay (ms) of every datagram.
+	Loss								float64				// Probability that a datagram is lost.
+	Reorder								float64				// Probability that a datagram is reordered.
+	ReorderDelay						int					// Additional delay (ms) of a reordered datagram.
+	Paths								[]LoopbackPathJSON	// Paths w/ other properties than the ones above.
+}
+
+// The path between two addresses of the loopback network, e.g. from one of the IP paths of the
+// router to the address of another instance. The properties apply to both directions.
+type LoopbackPathJSON struct {
+	Local								string
+	Remote								string
+	Delay								int					// Delay (ms) of every datagram.
+	Loss								float64				// Probability that a datagram is lost.
+	Reorder								float64				// Probability that a datagram is reordered.
+	ReorderDelay						int					// Additional delay (ms) of a reordered datagram.
 }
 
 type RouterConfigJSON struct {
 	PathSelection 						string				// What type to use for the path selection. (mtu, shortest)
+	ClampMSS							bool				// Clamp the MSS of the tcp flows such that no segment exceeds the MTU of its path.
+	BackboneFramingOverhead				int					// Bytes (encoding, security) added to a segment by the backbone connection.
+	IPPaths								[]string			// Local addresses the flows to a plain IP destination are spread across, one path each. (Empty to leave it to the host.)
 }
\ No newline at end of file
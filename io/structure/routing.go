@@ -6,6 +6,7 @@ import (
 	"shila/core/shila"
 	"shila/networkSide/network"
 	"strconv"
+	"strings"
 )
 
 type IPAddressPortJSON struct {
@@ -14,16 +15,17 @@ type IPAddressPortJSON struct {
 }
 func (ipj IPAddressPortJSON) GetIPAddressPort() (net.TCPAddr, error) {
 
-	IPv4 := net.ParseIP(ipj.IP)
+	// IPv6 addresses may be given in brackets, as in "[<ipv6>]:<port>".
+	IP := net.ParseIP(strings.Trim(ipj.IP, "[]"))
 	Port, err := strconv.Atoi(ipj.Port)
-	if IPv4 == nil {
-		return net.TCPAddr{}, ParsingError("Unable to parse port.")
+	if IP == nil {
+		return net.TCPAddr{}, ParsingError("Unable to parse IP.")
 	} else if err != nil {
 		return net.TCPAddr{}, err
 	}
 
 	return net.TCPAddr{
-		IP:   IPv4,
+		IP:   IP,
 		Port: Port,
 		Zone: "",
 	}, nil
@@ -43,9 +45,9 @@ type NetworkAddressAndPathJSON struct {
 	Address string
 	//Path    NetworkPathJSON
 }
-func (json NetworkAddressAndPathJSON) GetNetworkAddress() (shila.NetworkAddress, error) {
+func (json NetworkAddressAndPathJSON) GetNetworkAddress(generator shila.NetworkAddressGenerator) (shila.NetworkAddress, error) {
 
-	address, err := network.AddressGenerator{}.New(json.Address)
+	address, err := generator.New(json.Address)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,49 @@
+//
+package structure
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/scionproto/scion/go/lib/addr"
+	"net"
+	"strings"
+)
+
+const sizePeerKey = 32
+
+type PeerKeyJSON struct {
+	Peer string 		// ISD-AS of the peer, optionally followed by ",<host ip>". Just the ip for the plain IP backbone.
+	Key  string			// Hex encoded pre-shared key (32 bytes).
+}
+
+// Returns the normalized peer identifier (<isd-as>, <isd-as>,<host ip> or <ip>).
+func (pkj PeerKeyJSON) GetPeer() (string, error) {
+
+	if ip := net.ParseIP(strings.TrimSpace(pkj.Peer)); ip != nil {
+		return ip.String(), nil
+	}
+
+	parts := strings.SplitN(pkj.Peer, ",", 2)
+
+	ia, err := addr.IAFromString(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return "", ParsingError(fmt.Sprint("Unable to parse ISD-AS of peer ", pkj.Peer, "."))
+	}
+	if len(parts) == 1 {
+		return ia.String(), nil
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(parts[1]))
+	if ip == nil {
+		return "", ParsingError(fmt.Sprint("Unable to parse host of peer ", pkj.Peer, "."))
+	}
+	return fmt.Sprint(ia, ",", ip), nil
+}
+
+func (pkj PeerKeyJSON) GetKey() ([]byte, error) {
+	key, err := hex.DecodeString(pkj.Key)
+	if err != nil || len(key) != sizePeerKey {
+		return nil, ParsingError(fmt.Sprint("Key has to consist of ", sizePeerKey, " hex encoded bytes."))
+	}
+	return key, nil
+}
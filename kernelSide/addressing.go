@@ -0,0 +1,130 @@
+//
+package kernelSide
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"shila/core/shila"
+	"shila/kernelSide/network"
+)
+
+// The egress interfaces get their addresses from a configured subnet. The address follows from the
+// index of the interface: the n-th interface gets the (n+1)-th address of the subnet. As long as the
+// configuration does not change, an interface keeps its address across restarts, independent of the
+// other interfaces. An address already in use (on the host or reserved) is not handed out, just the
+// interface with the corresponding index is affected. The subnet must not overlap with the prefixes
+// of the host, neither with the ones of its interfaces nor with the ones it routes.
+type addressAllocator struct {
+	subnet *net.IPNet
+	taken  map[string]bool
+}
+
+func newAddressAllocator(subnet string, reserved ...net.IP) (*addressAllocator, error) {
+
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, shila.CriticalError(fmt.Sprint("Unable to parse subnet ", subnet, "."))
+	}
+
+	allocator := &addressAllocator{
+		subnet: ipNet,
+		taken:  make(map[string]bool),
+	}
+	if err := allocator.checkOverlap(); err != nil {
+		return nil, err
+	}
+	for _, ip := range reserved {
+		if ip != nil {
+			allocator.taken[ip.String()] = true
+		}
+	}
+
+	return allocator, nil
+}
+
+// allocate returns the address of the interface with the given index. Fails w/ a tolerable error if
+// the address is already in use and w/ a critical one if the subnet is too small.
+func (allocator *addressAllocator) allocate(index int) (net.IP, error) {
+
+	// The first address of the subnet identifies the subnet itself.
+	ip, ok := allocator.hostAddress(int64(index) + 1)
+	if !ok {
+		return nil, shila.CriticalError(fmt.Sprint("No address left in subnet ", allocator.subnet, " for index ", index, "."))
+	}
+	if allocator.taken[ip.String()] {
+		return nil, shila.TolerableError(fmt.Sprint("Address ", ip, " is already in use."))
+	}
+
+	// Addresses added to the host in the meantime
+	hostAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, shila.PrependError(shila.CriticalError(err.Error()), "Unable to get addresses of the host.")
+	}
+	for _, addr := range hostAddrs {
+		if hostNet, ok := addr.(*net.IPNet); ok && hostNet.IP.Equal(ip) {
+			return nil, shila.TolerableError(fmt.Sprint("Address ", ip, " is already present on the host."))
+		}
+	}
+
+	allocator.taken[ip.String()] = true
+	return ip, nil
+}
+
+// release hands the address back, such that it can be allocated again.
+func (allocator *addressAllocator) release(ip net.IP) {
+	if ip != nil {
+		delete(allocator.taken, ip.String())
+	}
+}
+
+// Fails if the subnet overlaps w/ the prefix of an address of the host or w/ a prefix the host
+// routes. (Default routes are not considered.)
+func (allocator *addressAllocator) checkOverlap() error {
+
+	hostAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return shila.PrependError(shila.CriticalError(err.Error()), "Unable to get addresses of the host.")
+	}
+	var prefixes []*net.IPNet
+	for _, addr := range hostAddrs {
+		if hostNet, ok := addr.(*net.IPNet); ok {
+			prefixes = append(prefixes, &net.IPNet{IP: hostNet.IP.Mask(hostNet.Mask), Mask: hostNet.Mask})
+		}
+	}
+	routed, err := network.RoutePrefixes(network.Namespace{}, network.FamilyOf(allocator.subnet.IP))
+	if err != nil {
+		return shila.PrependError(err, "Unable to get the routes of the host.")
+	}
+	prefixes = append(prefixes, routed...)
+
+	for _, prefix := range prefixes {
+		if ones, _ := prefix.Mask.Size(); ones == 0 {
+			continue
+		}
+		if prefix.Contains(allocator.subnet.IP) || allocator.subnet.Contains(prefix.IP) {
+			return shila.CriticalError(fmt.Sprint("Subnet ", allocator.subnet, " overlaps w/ ", prefix, " of the host."))
+		}
+	}
+	return nil
+}
+
+// Returns the n-th address of the subnet, false if the subnet is too small.
+func (allocator *addressAllocator) hostAddress(n int64) (net.IP, bool) {
+
+	ones, bits := allocator.subnet.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits - ones))
+	if bits == 8 * net.IPv4len && bits - ones > 1 {
+		size.Sub(size, big.NewInt(1)) // The last address of an IPv4 subnet is the broadcast address.
+	}
+	if big.NewInt(n).Cmp(size) >= 0 {
+		return nil, false
+	}
+
+	base := allocator.subnet.IP
+	sum  := new(big.Int).Add(new(big.Int).SetBytes(base), big.NewInt(n)).Bytes()
+
+	ip := make(net.IP, len(base))
+	copy(ip[len(ip) - len(sum):], sum)
+	return ip, true
+}
@@ -0,0 +1,47 @@
+//
+package kernelSide
+
+import (
+	"net"
+	"shila/core/shila"
+	"testing"
+)
+
+func TestAddressFollowsIndex(t *testing.T) {
+
+	allocator, err := newAddressAllocator("198.18.77.0/30", net.ParseIP("198.18.77.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reserved address affects just its own index.
+	if ip, err := allocator.allocate(1); err == nil {
+		t.Fatal("Allocated the reserved address ", ip, ".")
+	} else if _, ok := err.(shila.TolerableError); !ok {
+		t.Fatal("Collision is not tolerable. ", err)
+	}
+	ip, err := allocator.allocate(0)
+	if err != nil || !ip.Equal(net.ParseIP("198.18.77.1")) {
+		t.Fatal("Unexpected address ", ip, " for index 0. ", err)
+	}
+	if _, err := allocator.allocate(0); err == nil {
+		t.Fatal("Allocated an address twice.")
+	}
+	allocator.release(ip)
+	if again, err := allocator.allocate(0); err != nil || !again.Equal(ip) {
+		t.Fatal("Released address not handed out again. ", err)
+	}
+
+	// The broadcast address is never handed out.
+	if _, err := allocator.allocate(2); err == nil {
+		t.Fatal("Allocated the broadcast address.")
+	} else if _, ok := err.(shila.CriticalError); !ok {
+		t.Fatal("Exhausted subnet is tolerated. ", err)
+	}
+}
+
+func TestSubnetOverlappingWithHost(t *testing.T) {
+	if _, err := newAddressAllocator("127.0.0.0/16"); err == nil {
+		t.Fatal("Subnet overlapping w/ the loopback prefix accepted.")
+	}
+}
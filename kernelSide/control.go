@@ -0,0 +1,153 @@
+//
+package kernelSide
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"strings"
+	"time"
+)
+
+// While shila is running, egress interfaces are added and removed through a unix socket. Every
+// connection carries a single command line and its reply:
+//
+//   add          -> ok <address of the new interface>
+//   remove <ip>  -> ok
+//
+// A failed command is answered w/ "error <reason>". (Run shila w/ --add-egress-interface or
+// --remove-egress-interface to send a command.)
+
+const (
+	controlCommandAdd    = "add"
+	controlCommandRemove = "remove"
+	controlReplyOk       = "ok"
+	controlReplyError    = "error"
+	controlTimeout       = 30 * time.Second
+)
+
+func (manager *Manager) startControl() error {
+
+	path := config.Config.KernelSide.ControlSocketPath
+	if path == "" || manager.withoutDevices {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return shila.PrependError(err, "Unable to create directory of control socket.")
+	}
+	// A socket left behind by a previous run.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return shila.PrependError(err, "Unable to remove old control socket.")
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return shila.PrependError(err, "Unable to open control socket.")
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return shila.PrependError(err, "Unable to restrict access to control socket.")
+	}
+	manager.control = listener
+
+	go manager.serveControl(listener)
+	return nil
+}
+
+func (manager *Manager) stopControl() error {
+	if manager.control == nil {
+		return nil
+	}
+	// Closing the listener removes the socket file as well.
+	err := manager.control.Close()
+	manager.control = nil
+	return err
+}
+
+func (manager *Manager) serveControl(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if manager.state.Is(shila.Running) {
+				log.Error.Print("Control socket failed. ", err.Error())
+			}
+			return
+		}
+		go manager.handleControl(conn)
+	}
+}
+
+func (manager *Manager) handleControl(conn net.Conn) {
+
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		log.Error.Print("Unable to read control command. ", err.Error())
+		return
+	}
+	reply := manager.executeControl(strings.Fields(line))
+	if _, err := fmt.Fprintln(conn, reply); err != nil {
+		log.Error.Print("Unable to reply to control command. ", err.Error())
+	}
+}
+
+func (manager *Manager) executeControl(command []string) string {
+
+	if len(command) == 1 && command[0] == controlCommandAdd {
+		ip, err := manager.AddEgressInterface()
+		if err != nil {
+			return fmt.Sprint(controlReplyError, " ", err.Error())
+		}
+		return fmt.Sprint(controlReplyOk, " ", ip)
+	}
+
+	if len(command) == 2 && command[0] == controlCommandRemove {
+		ip := net.ParseIP(command[1])
+		if ip == nil {
+			return fmt.Sprint(controlReplyError, " Invalid address ", command[1], ".")
+		}
+		if err := manager.RemoveEgressInterface(ip); err != nil {
+			return fmt.Sprint(controlReplyError, " ", err.Error())
+		}
+		return controlReplyOk
+	}
+
+	return fmt.Sprint(controlReplyError, " Unknown command ", strings.Join(command, " "), ".")
+}
+
+// SendControlCommand sends the command to the running instance of shila and returns its reply.
+func SendControlCommand(command string) (string, error) {
+
+	path := config.Config.KernelSide.ControlSocketPath
+	if path == "" {
+		return "", shila.CriticalError("No control socket configured.")
+	}
+
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return "", shila.PrependError(err, "Unable to connect to the running instance.")
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", shila.PrependError(err, "Unable to send control command.")
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", shila.PrependError(err, "Unable to receive reply to control command.")
+	}
+
+	reply := strings.TrimSpace(line)
+	if strings.HasPrefix(reply, controlReplyError) {
+		return "", shila.TolerableError(strings.TrimSpace(strings.TrimPrefix(reply, controlReplyError)))
+	}
+	return strings.TrimSpace(strings.TrimPrefix(reply, controlReplyOk)), nil
+}
@@ -0,0 +1,45 @@
+//
+package kernelSide
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"shila/config"
+	"shila/core/shila"
+	"shila/kernelSide/kernelEndpoint"
+	"strings"
+	"testing"
+)
+
+func TestControlCommands(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "shila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Config.KernelSide.ControlSocketPath = filepath.Join(dir, "control.sock")
+
+	manager := NewWithEndpoints(shila.PacketChannelPubChannels{}, shila.EndpointIssuePubChannels{},
+		kernelEndpoint.NewFake(net.ParseIP("10.7.0.2"), shila.EgressKernelEndpoint))
+	listener, err := net.Listen("unix", config.Config.KernelSide.ControlSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go manager.serveControl(listener)
+
+	// The replies of the manager are passed on, the commands fail w/o devices.
+	for command, reply := range map[string]string{
+		"add":               "without devices",
+		"remove 10.7.0.2":   "is no egress interface",
+		"remove 10.7.0.200": "No kernel endpoint",
+		"remove":            "Unknown command",
+	} {
+		if _, err := SendControlCommand(command); err == nil || !strings.Contains(err.Error(), reply) {
+			t.Errorf("Unexpected reply to %q: %v", command, err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+//
+package kernelSide
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"shila/core/shila"
+	"shila/kernelSide/kernelEndpoint"
+	"shila/log"
+)
+
+// AddEgressInterface creates a new egress kernel endpoint (w/ its routing rule and table) while shila
+// is running and announces it to the egress working side. Returns the address of the new endpoint.
+func (manager *Manager) AddEgressInterface() (net.IP, error) {
+
+	if manager.withoutDevices {
+		return nil, shila.TolerableError("Cannot add egress interface, kernel side runs without devices.")
+	}
+
+	manager.lock.Lock()
+	if manager.state.Not(shila.Running) {
+		manager.lock.Unlock()
+		return nil, shila.TolerableError(fmt.Sprint("Cannot add egress interface in state ", manager.state, "."))
+	}
+
+	device, err := manager.addEgressDevice()
+	if err == nil {
+		err = manager.setupEgressDevice(device)
+	}
+	manager.lock.Unlock()
+
+	if err != nil {
+		return nil, shila.PrependError(err, "Unable to add egress interface.")
+	}
+
+	// The working side might be busy, do not hold the lock meanwhile.
+	if err := manager.announce(device); err != nil {
+		return nil, err
+	}
+
+	log.Info.Print("Added egress interface ", device.Identifier(), ".")
+	return device.IP, nil
+}
+
+// RemoveEgressInterface drains the egress kernel endpoint with the given address: the endpoint no longer
+// participates in MPTCP and is not handed out to new connections. As soon as no connection uses it
+// anymore, the endpoint is removed together with its routing rule and table.
+func (manager *Manager) RemoveEgressInterface(ip net.IP) error {
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	endpoint, ok := manager.lookup(shila.GetIPAddressKey(ip))
+	if !ok {
+		return shila.TolerableError(fmt.Sprint("No kernel endpoint for ", ip, "."))
+	}
+	device, ok := endpoint.(*kernelEndpoint.Device)
+	if !ok || device.Role() != shila.EgressKernelEndpoint {
+		return shila.TolerableError(fmt.Sprint("Kernel endpoint for ", ip, " is no egress interface."))
+	}
+	if device.Number == numberOfFirstEgressInterface {
+		// Its table also routes the connection requests towards the ingress interface.
+		return shila.TolerableError(fmt.Sprint("Cannot remove the first egress interface ", device.Identifier(), "."))
+	}
+	if manager.draining[endpoint] {
+		return nil
+	}
+
+	// No new sub flows through this interface.
+	if err := manager.disableMultipath(device); err != nil {
+		return shila.PrependError(err, "Unable to drain egress interface.")
+	}
+	manager.draining[endpoint] = true
+	log.Info.Print("Draining egress interface ", device.Identifier(), ".")
+
+	if manager.usage[endpoint] == 0 {
+		manager.removeDrainedDevice(device)
+	}
+	return nil
+}
+
+// AcquireEndpoint returns the kernel endpoint for the address and marks it as used by a connection.
+// Draining and failed endpoints are not handed out. Every acquired endpoint has to be released again.
+func (manager *Manager) AcquireEndpoint(key shila.IPAddressKey) (kernelEndpoint.Endpoint, bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	endpoint, ok := manager.lookup(key)
+	if !ok || manager.draining[endpoint] || manager.failed[endpoint] {
+		return nil, false
+	}
+	manager.usage[endpoint]++
+	return endpoint, true
+}
+
+// UseEndpoint marks the endpoint (through which a connection received a packet) as used.
+// It has to be released again as well.
+func (manager *Manager) UseEndpoint(endpoint kernelEndpoint.Endpoint) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.usage[endpoint]++
+}
+
+// ReleaseEndpoint is called once a connection no longer uses the endpoint. A draining
+// endpoint is removed with its last user.
+func (manager *Manager) ReleaseEndpoint(endpoint kernelEndpoint.Endpoint) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.usage[endpoint]--; manager.usage[endpoint] > 0 {
+		return
+	}
+	delete(manager.usage, endpoint)
+	if device, ok := endpoint.(*kernelEndpoint.Device); ok && manager.draining[endpoint] {
+		manager.removeDrainedDevice(device)
+	}
+}
+
+// Creates a new egress device with the next free number and adds it to the mapping. The addresses follow
+// from the number, a number whose address is in use is skipped. The device is neither set up nor started.
+func (manager *Manager) addEgressDevice() (*kernelEndpoint.Device, error) {
+
+	used := manager.usedDeviceNumbers()
+	for number := numberOfFirstEgressInterface; number <= math.MaxUint8; number++ {
+		if used[uint8(number)] {
+			continue
+		}
+		ip, ipv6, err := manager.allocateAddresses(number - numberOfFirstEgressInterface)
+		if _, ok := err.(shila.TolerableError); ok && number != numberOfFirstEgressInterface {
+			// Its table also routes the connection requests towards the ingress interface, the first
+			// egress interface can not be skipped.
+			log.Error.Print("Skipping egress interface number ", number, ". ", err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key := shila.GetIPAddressKey(ip)
+		kerep := kernelEndpoint.New(uint8(number), manager.egressNamespace, ip, ipv6, shila.EgressKernelEndpoint, manager.endpointIssues)
+
+		manager.endpoints[key] = &kerep
+		if ipv6 != nil {
+			manager.aliases[shila.GetIPAddressKey(ipv6)] = key
+		}
+		return &kerep, nil
+	}
+	return nil, shila.TolerableError("No device number left.")
+}
+
+// Allocates the address (and the IPv6 address) of the egress interface w/ the given index.
+func (manager *Manager) allocateAddresses(index int) (net.IP, net.IP, error) {
+	ip, err := manager.allocator.allocate(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ipv6 net.IP
+	if manager.allocatorIPv6 != nil {
+		if ipv6, err = manager.allocatorIPv6.allocate(index); err != nil {
+			manager.allocator.release(ip)
+			return nil, nil, err
+		}
+	}
+	return ip, ipv6, nil
+}
+
+// Records, sets up and starts the device. On failure, the device is removed again.
+func (manager *Manager) setupEgressDevice(device *kernelEndpoint.Device) error {
+	if err := manager.leftovers.addDevice(device.Namespace, device.Name, kernelEndpoint.Table(device.Number)); err != nil {
+		manager.removeEgressDevice(device)
+		return err
+	}
+	// A device cleans up after itself if the setup fails.
+	if err := device.Setup(); err != nil {
+		manager.removeEgressDevice(device)
+		return err
+	}
+	if err := device.Start(); err != nil {
+		_ = device.TearDown()
+		manager.removeEgressDevice(device)
+		return err
+	}
+	if err := manager.addMPTCPEndpoints(device); err != nil {
+		_ = manager.removeMPTCPEndpoints(device)
+		_ = device.TearDown()
+		manager.removeEgressDevice(device)
+		return err
+	}
+	return nil
+}
+
+// Removes the device from the mapping and hands back its addresses.
+func (manager *Manager) removeEgressDevice(device *kernelEndpoint.Device) {
+	delete(manager.endpoints, shila.GetIPAddressKey(device.IP))
+	manager.allocator.release(device.IP)
+	if device.IPv6 != nil {
+		delete(manager.aliases, shila.GetIPAddressKey(device.IPv6))
+		manager.allocatorIPv6.release(device.IPv6)
+	}
+	delete(manager.draining, device)
+	delete(manager.failed, device)
+	delete(manager.usage, device)
+}
+
+func (manager *Manager) removeDrainedDevice(device *kernelEndpoint.Device) {
+	// A failed device is already torn down.
+	if !manager.failed[device] {
+		if err := device.TearDown(); err != nil {
+			log.Error.Print("Unable to tear down egress interface ", device.Identifier(), ". ", err.Error())
+		}
+	}
+	manager.removeEgressDevice(device)
+	if err := manager.leftovers.removeDevice(device.Namespace, device.Name); err != nil {
+		log.Error.Print(shila.PrependError(err, "Unable to update state file.").Error())
+	}
+	log.Info.Print("Removed egress interface ", device.Identifier(), ".")
+}
+
+// The number of a device determines its name and its routing table. The ingress
+// device has number 1, the egress devices get the lowest number not in use (whose addresses are free).
+func (manager *Manager) usedDeviceNumbers() map[uint8]bool {
+	used := make(map[uint8]bool)
+	for _, endpoint := range manager.endpoints {
+		if device, ok := endpoint.(*kernelEndpoint.Device); ok {
+			used[device.Number] = true
+		}
+	}
+	return used
+}
@@ -1,33 +1,126 @@
 package kernelSide
 
 import (
+	"fmt"
 	"shila/core/shila"
 	"shila/kernelSide/kernelEndpoint"
-	"shila/layer"
+	"shila/log"
 	"shila/shutdown"
+	"shila/supervisor"
 )
 
+// A failed device is taken out of service and recreated by its supervisor, the connections using it are
+// closed by the working sides. The successor has the same number and addresses as the failed device.
 func (manager *Manager) errorHandler() {
 	for issue := range manager.endpointIssues {
 		var ep interface{} = issue.Issuer
-		if device, ok := ep.(*kernelEndpoint.Device); ok {
-			if  device.Role() == shila.EgressKernelEndpoint ||
-				device.Role() == shila.IngressKernelEndpoint {
-
-				var err interface{} = issue.Error
-				if errParsed, ok := err.(*kernelEndpoint.ConnectionError); ok {
-					shutdown.Fatal(shila.PrependError(errParsed, "Kernel side error."))
-				} else if errParsed, ok := err.(*layer.ParsingError); ok {
-					shutdown.Fatal(shila.PrependError(errParsed, "Kernel side error."))
-				} else {
-					shutdown.Fatal(shila.CriticalError("Unhandled kernel endpoint error. Should not happen."))
-				}
-
-			} else {
-				shutdown.Fatal(shila.CriticalError("Kernel endpoint with unhandled role publishes issue. Should not happen."))
-			}
-		} else {
-			shutdown.Fatal(shila.CriticalError("Endpoint with unhandled type publishes issue. Should not happen."))
+		device, ok := ep.(*kernelEndpoint.Device)
+		if !ok {
+			// Fake endpoints cannot be recreated.
+			shutdown.Fatal(shila.PrependError(issue.Error, fmt.Sprint("Kernel side error in ", issue.Issuer.Identifier(), ".")))
+			continue
 		}
+		if  device.Role() != shila.EgressKernelEndpoint &&
+			device.Role() != shila.IngressKernelEndpoint {
+			shutdown.Fatal(shila.CriticalError("Kernel endpoint with unhandled role publishes issue. Should not happen."))
+			continue
+		}
+
+		log.Error.Print(device.Says(fmt.Sprint("Failed. ", issue.Error.Error())))
+		if !manager.retireDevice(device) {
+			continue
+		}
+
+		// Both working sides might have connections using the device.
+		pub := shila.EndpointIssuePub{Issuer: device, Error: shila.TolerableError(fmt.Sprint("Kernel endpoint failed. ", issue.Error.Error()))}
+		manager.workingSideIssues.Ingress <- pub
+		manager.workingSideIssues.Egress  <- pub
+
+		manager.supervisorOf(device).Restart(issue.Error, func() error {
+			return manager.restartDevice(device)
+		})
+	}
+}
+
+// Tears the failed device down, it is no longer handed out to connections. The device stays in the mapping
+// such that its number and addresses are reserved for its successor. False if there is nothing to restart.
+func (manager *Manager) retireDevice(device *kernelEndpoint.Device) bool {
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if manager.state.Not(shila.Running) || manager.failed[device] {
+		return false
+	}
+	if endpoint, ok := manager.endpoints[shila.GetIPAddressKey(device.IP)]; !ok || endpoint != device {
+		return false
+	}
+
+	manager.failed[device] = true
+	if device.Role() == shila.EgressKernelEndpoint {
+		_ = manager.removeMPTCPEndpoints(device)
+	}
+	if err := device.TearDown(); err != nil {
+		log.Error.Print(device.Says(fmt.Sprint("Unable to tear down. ", err.Error())))
+	}
+
+	// A draining device is not replaced.
+	if manager.draining[device] {
+		manager.removeDrainedDevice(device)
+		return false
+	}
+	return true
+}
+
+// Replaces the failed device by a new one and announces the latter to the working side.
+func (manager *Manager) restartDevice(failed *kernelEndpoint.Device) error {
+
+	manager.lock.Lock()
+
+	// Shila is shutting down or the device got removed meanwhile.
+	if manager.state.Not(shila.Running) || !manager.failed[failed] {
+		manager.lock.Unlock()
+		return nil
+	}
+
+	kerep := kernelEndpoint.New(failed.Number, failed.Namespace, failed.IP, failed.IPv6, failed.Role(), manager.endpointIssues)
+	device := &kerep
+	// A device cleans up after itself if the setup fails.
+	if err := device.Setup(); err != nil {
+		manager.lock.Unlock()
+		return err
+	}
+	if err := device.Start(); err != nil {
+		_ = device.TearDown()
+		manager.lock.Unlock()
+		return err
+	}
+	if device.Role() == shila.EgressKernelEndpoint {
+		if err := manager.addMPTCPEndpoints(device); err != nil {
+			_ = manager.removeMPTCPEndpoints(device)
+			_ = device.TearDown()
+			manager.lock.Unlock()
+			return err
+		}
+	}
+
+	manager.endpoints[shila.GetIPAddressKey(device.IP)] = device
+	delete(manager.failed, failed)
+	delete(manager.usage, failed)
+	manager.lock.Unlock()
+
+	// The working side might be busy, do not hold the lock meanwhile.
+	return manager.announce(device)
+}
+
+// The supervisor of a device outlives the device, it is shared by all devices with the same number.
+func (manager *Manager) supervisorOf(device *kernelEndpoint.Device) *supervisor.Supervisor {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	s, ok := manager.supervisors[device.Number]
+	if !ok {
+		s = supervisor.New(fmt.Sprint(device.Role(), " (", device.Name, ")"))
+		manager.supervisors[device.Number] = s
 	}
+	return s
 }
@@ -0,0 +1,99 @@
+//
+package kernelSide
+
+import (
+	"fmt"
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/kernelSide/kernelEndpoint"
+	"shila/kernelSide/network"
+)
+
+// The number of the ingress interface.
+const numberOfIngressInterface = 1
+
+// In the transparent mode, applications run in the default namespace and connect to the actual destination.
+//
+// The TCP traffic towards the intercepted prefixes looks up the table of the first egress interface, such
+// that the connections pick its address as source. The rules of the egress interfaces (from <address>)
+// take precedence, the sub flows MPTCP opens through the other interfaces stay on these interfaces.
+//
+// Connections received through the ingress interface are marked, their replies look up the table of the
+// ingress interface and are therefore routed back through shila.
+func (manager *Manager) setupInterception() error {
+
+	for _, prefixString := range config.Config.KernelSide.InterceptedPrefixes {
+		_, prefix, err := net.ParseCIDR(prefixString)
+		if err != nil {
+			return shila.CriticalError(fmt.Sprint("Unable to parse intercepted prefix ", prefixString, "."))
+		}
+		if (prefix.IP.To4() == nil) && manager.ingressIPv6 == nil {
+			return shila.CriticalError(fmt.Sprint("Cannot intercept ", prefix, ", IPv6 is disabled."))
+		}
+		if err := manager.leftovers.addRuleTo(network.Namespace{}, prefix, true, kernelEndpoint.Table(numberOfFirstEgressInterface)); err != nil {
+			return err
+		}
+		if err := network.AddRuleToPrefix(network.Namespace{}, prefix, true, kernelEndpoint.Table(numberOfFirstEgressInterface),
+			config.Config.KernelSide.InterceptionRulePriority); err != nil {
+			return err
+		}
+	}
+
+	mark := config.Config.KernelSide.InterceptionMark
+	for _, family := range manager.families() {
+		if err := manager.leftovers.addMarkRule(network.Namespace{}, mark, kernelEndpoint.Table(numberOfIngressInterface), family); err != nil {
+			return err
+		}
+		if err := network.AddRuleMark(network.Namespace{}, mark, kernelEndpoint.Table(numberOfIngressInterface), family); err != nil {
+			return err
+		}
+	}
+
+	ingress, ok := manager.endpoints[shila.GetIPAddressKey(manager.ingressIP)].(*kernelEndpoint.Device)
+	if !ok {
+		return shila.CriticalError("No ingress interface to intercept traffic with.")
+	}
+	if err := manager.leftovers.addConnectionMark(ingress.Name, mark, manager.families()); err != nil {
+		return err
+	}
+	if err := network.AddConnectionMark(ingress.Name, mark, manager.families()); err != nil {
+		return err
+	}
+
+	// The source addresses of the packets received through the ingress interface are routed elsewhere,
+	// a strict reverse path filter would drop them.
+	if err := network.SetSysctl(network.Namespace{}, fmt.Sprint("net/ipv4/conf/", ingress.Name, "/rp_filter"), "2"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (manager *Manager) clearInterception() error {
+
+	var err error
+	for _, prefixString := range config.Config.KernelSide.InterceptedPrefixes {
+		if _, prefix, errParse := net.ParseCIDR(prefixString); errParse == nil {
+			err = network.DeleteRuleToPrefix(network.Namespace{}, prefix, true, kernelEndpoint.Table(numberOfFirstEgressInterface))
+		}
+	}
+
+	mark := config.Config.KernelSide.InterceptionMark
+	for _, family := range manager.families() {
+		err = network.DeleteRuleMark(network.Namespace{}, mark, kernelEndpoint.Table(numberOfIngressInterface), family)
+	}
+
+	if ingress, ok := manager.endpoints[shila.GetIPAddressKey(manager.ingressIP)].(*kernelEndpoint.Device); ok {
+		err = network.DeleteConnectionMark(ingress.Name, mark, manager.families())
+	}
+
+	return err
+}
+
+func (manager *Manager) families() []network.Family {
+	if manager.ingressIPv6 != nil {
+		return []network.Family{network.IPv4, network.IPv6}
+	}
+	return []network.Family{network.IPv4}
+}
@@ -0,0 +1,14 @@
+//
+package kernelEndpoint
+
+import "shila/core/shila"
+
+var _ Endpoint = (*Device)(nil)
+var _ Endpoint = (*Fake)(nil)
+
+// Endpoint towards the kernel, either backed by a virtual interface or fake.
+type Endpoint interface {
+	shila.Endpoint
+	Setup() error
+	Start() error
+}
@@ -0,0 +1,114 @@
+//
+package kernelEndpoint
+
+import (
+	"fmt"
+	"net"
+	"shila/capture"
+	"shila/config"
+	"shila/core/shila"
+)
+
+// Fake is a kernel endpoint w/o a virtual interface. Frames are injected directly
+// and the frames the kernel would receive are recorded.
+type Fake struct {
+	IP       net.IP
+	label    shila.EndpointRole
+	channels Channels
+	frames   chan []byte
+	state    shila.EntityState
+}
+
+func NewFake(ip net.IP, label shila.EndpointRole) *Fake {
+	return &Fake{
+		IP:    ip,
+		label: label,
+		state: shila.NewEntityState(),
+	}
+}
+
+func (fake *Fake) Setup() error {
+
+	if fake.state.Not(shila.Uninitialized) {
+		return shila.CriticalError(fmt.Sprint("Entity in wrong state ", fake.state, "."))
+	}
+
+	fake.channels.ingress = make(chan *shila.Packet, config.Config.KernelEndpoint.SizeIngressBuffer)
+	fake.channels.egress  = make(chan *shila.Packet, config.Config.KernelEndpoint.SizeEgressBuffer)
+	fake.frames 		  = make(chan []byte, config.Config.KernelEndpoint.SizeEgressBuffer)
+
+	fake.state.Set(shila.Initialized)
+	return nil
+}
+
+func (fake *Fake) Start() error {
+
+	if fake.state.Not(shila.Initialized) {
+		return shila.CriticalError(fmt.Sprint("Entity in wrong state ", fake.state, "."))
+	}
+
+	go fake.serveEgress()
+
+	fake.state.Set(shila.Running)
+	return nil
+}
+
+// The connections using the endpoint have to be closed before, they are the senders of the egress channel.
+func (fake *Fake) TearDown() error {
+	fake.state.Set(shila.TornDown)
+	close(fake.channels.ingress)
+	// Stops the egress worker, which in turn closes the recorded frames.
+	close(fake.channels.egress)
+	return nil
+}
+
+// Inject hands a raw IPv4/TCP frame to Shila as if it was read from the virtual interface.
+func (fake *Fake) Inject(raw []byte) error {
+
+	if fake.state.Not(shila.Running) {
+		return shila.CriticalError(fmt.Sprint("Entity in wrong state ", fake.state, "."))
+	}
+
+	tcpFlow, err := shila.GetTCPFlow(raw)
+	if err != nil {
+		return shila.PrependError(err, "Unable to get IP net flow.")
+	}
+	p := shila.NewPacket(fake, tcpFlow, raw)
+	capture.Record(fake, capture.Ingress, p)
+	fake.channels.ingress <- p
+	return nil
+}
+
+// Frames returns the raw frames which would have been written to the virtual interface,
+// closed once the endpoint is torn down.
+func (fake *Fake) Frames() <-chan []byte {
+	return fake.frames
+}
+
+func (fake *Fake) Role() shila.EndpointRole {
+	return fake.label
+}
+
+func (fake *Fake) Identifier() string {
+	return fmt.Sprint(fake.Role(), " (fake:", fake.IP, ")")
+}
+
+func (fake *Fake) TrafficChannels() shila.PacketChannels {
+	return shila.PacketChannels{Ingress: fake.channels.ingress, Egress: fake.channels.egress}
+}
+
+func (fake *Fake) serveEgress() {
+	defer close(fake.frames)
+	for p := range fake.channels.egress {
+		capture.Record(fake, capture.Egress, p)
+		select {
+		case fake.frames <- p.Payload:
+		default:
+			// Nobody is consuming the recorded frames, drop them like a full tun device would.
+		}
+	}
+}
+
+func (fake *Fake) Says(str string) string {
+	return  fmt.Sprint(fake.Identifier(), ": ", str)
+}
@@ -0,0 +1,26 @@
+//
+package kernelEndpoint
+
+// A read from the tun device returns exactly one frame. Every frame is read into the same
+// buffer and then copied into memory of its own size, which is owned by its packet. Reading
+// into memory shared by several packets would keep all of it alive as long as any of these
+// packets is still queued somewhere.
+type frameBuffer struct {
+	buffer []byte
+}
+
+func newFrameBuffer(maxFrameSize int) *frameBuffer {
+	return &frameBuffer{ buffer: make([]byte, maxFrameSize) }
+}
+
+// next returns the storage the next frame is read into.
+func (fb *frameBuffer) next() []byte {
+	return fb.buffer
+}
+
+// take hands out a copy of the n bytes of the last read frame.
+func (fb *frameBuffer) take(n int) []byte {
+	frame := make([]byte, n)
+	copy(frame, fb.buffer[:n])
+	return frame
+}
@@ -0,0 +1,120 @@
+//
+package kernelEndpoint
+
+import (
+	"encoding/binary"
+	"io"
+	"shila/layer/tcpip"
+	"testing"
+)
+
+const benchmarkFrameSize = 1500
+
+// The frames are kept alive by their packets, the benchmarks keep them as well.
+var frameSink []byte
+
+// Returns a single IPv4 frame per read, like the tun device.
+type tunReader struct {
+	frame []byte
+}
+
+func newTunReader(size int) *tunReader {
+	frame := make([]byte, size)
+	frame[0] = 0x45
+	binary.BigEndian.PutUint16(frame[2:4], uint16(size))
+	frame[9] = 6
+	return &tunReader{ frame: frame }
+}
+
+func (r *tunReader) Read(p []byte) (int, error) {
+	return copy(p, r.frame), nil
+}
+
+func TestFrameIsNotShared(t *testing.T) {
+
+	reader := newTunReader(100)
+	frames := newFrameBuffer(2500)
+
+	n, _ := reader.Read(frames.next())
+	first := frames.take(n)
+	n, _ = reader.Read(frames.next())
+	frames.next()[0] = 0
+	second := frames.take(n)
+
+	if cap(first) != 100 || first[0] != 0x45 {
+		t.Fatal("Frame shares memory w/ the read buffer.")
+	}
+	if second[0] != 0 {
+		t.Fatal("Frame is not a copy of the last read.")
+	}
+}
+
+// The frame reader as used by the kernel endpoints.
+func BenchmarkFrameReader(b *testing.B) {
+
+	reader := newTunReader(benchmarkFrameSize)
+	frames := newFrameBuffer(2500)
+
+	b.SetBytes(benchmarkFrameSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		storage := frames.next()
+		n, _ := reader.Read(storage)
+		if length, err := tcpip.FrameLength(storage[:n]); err != nil || length != n {
+			b.Fatal("Invalid frame.")
+		}
+		frameSink = frames.take(n)
+	}
+}
+
+// The packetizer used before, the bytes read are passed one by one through a channel and
+// reassembled into frames.
+func BenchmarkPacketizer(b *testing.B) {
+
+	reader := newTunReader(benchmarkFrameSize)
+	ingressRaw := make(chan byte, 2500)
+	done := make(chan struct{})
+	go func() {
+		defer close(ingressRaw)
+		storage := make([]byte, 2500)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, _ := io.ReadAtLeast(reader, storage, 30)
+			for _, b := range storage[:n] {
+				ingressRaw <- b
+			}
+		}
+	}()
+
+	b.SetBytes(benchmarkFrameSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if frameSink = packetizeRawData(ingressRaw, 2500); len(frameSink) != benchmarkFrameSize {
+			b.Fatal("Invalid frame.")
+		}
+	}
+	b.StopTimer()
+
+	close(done)
+	for range ingressRaw {}
+}
+
+func packetizeRawData(ingressRaw chan byte, sizeReadBuffer int) []byte {
+	rawData := make([]byte, 0, sizeReadBuffer)
+	b := <-ingressRaw
+	rawData = append(rawData, b)
+	for cnt := 0; cnt < 3; cnt++ {
+		rawData = append(rawData, <-ingressRaw)
+	}
+	length := binary.BigEndian.Uint16(rawData[2:4])
+	for cnt := 0; cnt < int(length - 4); cnt++ {
+		rawData = append(rawData, <-ingressRaw)
+	}
+	return rawData
+}
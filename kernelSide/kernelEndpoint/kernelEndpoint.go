@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"shila/capture"
 	"shila/config"
 	"shila/core/shila"
 	"shila/kernelSide/kernelEndpoint/vif"
@@ -21,11 +22,14 @@ type Device struct {
 	Name			string
 	Namespace		network.Namespace
 	IP				net.IP
+	IPv6			net.IP				// Nil if the device has no IPv6 address.
 	label 			shila.EndpointRole
 	endpointIssues 	shila.EndpointIssuePubChannel
 	channels   		Channels
 	vif        		vif.Device
 	state   		shila.EntityState
+	stop			chan struct{}		// Closed on tear down, releases the ingress worker blocked on a full channel.
+	ingressDone		chan struct{}		// Closed by the ingress worker once it returned.
 }
 
 type Channels struct {
@@ -33,18 +37,30 @@ type Channels struct {
 	egress     shila.PacketChannel
 }
 
-func New(number uint8, namespace network.Namespace, ip net.IP, label shila.EndpointRole, endpointIssues shila.EndpointIssuePubChannel) Device {
+func New(number uint8, namespace network.Namespace, ip net.IP, ipv6 net.IP, label shila.EndpointRole, endpointIssues shila.EndpointIssuePubChannel) Device {
 	return Device{
 		Number:			number,
-		Name:			fmt.Sprint(nameTunDevice, number),
+		Name:			DeviceName(number),
 		Namespace:		namespace,
 		IP:				ip,
+		IPv6:			ipv6,
 		label:			label,
 		endpointIssues: endpointIssues,
 		state:			shila.NewEntityState(),
 	}
 }
 
+// DeviceName returns the name of the virtual interface w/ the given number.
+func DeviceName(number uint8) string {
+	return fmt.Sprint(nameTunDevice, number)
+}
+
+// Table returns the routing table of the virtual interface w/ the given number. The tables of the virtual
+// interfaces start at the configured offset, apart from the tables of the host.
+func Table(number uint8) int {
+	return config.Config.KernelSide.RoutingTableOffset + int(number)
+}
+
 func (device *Device) Setup() error {
 
 	if device.state.Not(shila.Uninitialized) {
@@ -52,7 +68,11 @@ func (device *Device) Setup() error {
 	}
 
 	// Allocate the vif
-	device.vif = vif.New(device.Name, device.Namespace, network.Subnet(device.IP.String()))
+	subnets := []network.Subnet{network.Subnet(device.IP.String())}
+	if device.IPv6 != nil {
+		subnets = append(subnets, network.Subnet(device.IPv6.String()))
+	}
+	device.vif = vif.New(device.Name, device.Namespace, subnets...)
 
 	// Setup the vif
 	if err := device.vif.Setup(); err != nil {
@@ -79,6 +99,7 @@ func (device *Device) Setup() error {
 
 	device.channels.ingress = make(chan *shila.Packet, config.Config.KernelEndpoint.SizeIngressBuffer)
 	device.channels.egress  = make(chan *shila.Packet, config.Config.KernelEndpoint.SizeEgressBuffer)
+	device.stop				= make(chan struct{})
 
 	device.state.Set(shila.Initialized)
 	return nil
@@ -90,6 +111,7 @@ func (device *Device) Start() error {
 		return shila.CriticalError(fmt.Sprint("Entity in wrong state ", device.state, "."))
 	}
 
+	device.ingressDone = make(chan struct{})
 	go device.serveIngress()
 	go device.serveEgress()
 
@@ -100,15 +122,20 @@ func (device *Device) Start() error {
 func (device *Device) TearDown() error {
 
 	device.state.Set(shila.TornDown)
+	close(device.stop)
 
 	// Remove the routing table associated with the kernel endpoint
 	err := device.removeRouting()
 
-	// Deallocate the corresponding instance of the interface
+	// Deallocate the corresponding instance of the interface, which ends a pending read.
 	err = device.vif.TurnDown()
 	err = device.vif.Teardown()
 
-	// Ingress channel: This kernel endpoint is the only sender, therefore can close it.
+	// Ingress channel: This kernel endpoint is the only sender, therefore can close it as
+	// soon as the ingress worker returned.
+	if device.ingressDone != nil {
+		<-device.ingressDone
+	}
 	close(device.channels.ingress)
 
 	return err
@@ -119,6 +146,9 @@ func (device *Device) Role() shila.EndpointRole {
 }
 
 func (device *Device) Identifier() string {
+	if device.IPv6 != nil {
+		return fmt.Sprint(device.Role(), " (", device.Name,":", device.IP, ",", device.IPv6, ")")
+	}
 	return fmt.Sprint(device.Role(), " (", device.Name,":", device.IP, ")")
 }
 
@@ -128,15 +158,23 @@ func (device *Device) TrafficChannels() shila.PacketChannels {
 
 func (device *Device) setupRouting() error {
 
-	// ip rule add from <dev ip> table <table id>
-	args := []string{"rule", "add", "from", device.IP.String(), "table", fmt.Sprint(device.Number)}
-	if err := network.Execute(device.Namespace, args...); err != nil {
+	// Traffic from the device address looks up the table of the device,
+	// which routes everything through the device.
+	if err := network.AddRuleFrom(device.Namespace, device.IP, device.table()); err != nil {
 		return err
 	}
+	if err := network.AddDefaultRoute(device.Namespace, device.Name, device.table(), network.IPv4); err != nil {
+		return err
+	}
+
+	if device.IPv6 == nil {
+		return nil
+	}
 
-	// ip route add table <table id> default dev <dev name> scope link
-	args = []string{"route", "add", "table", fmt.Sprint(device.Number), "default", "dev", device.Name, "scope", "link"}
-	if err := network.Execute(device.Namespace, args...); err != nil {
+	if err := network.AddRuleFrom(device.Namespace, device.IPv6, device.table()); err != nil {
+		return err
+	}
+	if err := network.AddDefaultRoute(device.Namespace, device.Name, device.table(), network.IPv6); err != nil {
 		return err
 	}
 
@@ -145,30 +183,36 @@ func (device *Device) setupRouting() error {
 
 func (device *Device) removeRouting() error {
 
-	// ip rule del table <table id>
-	args := []string{"rule", "del", "table", fmt.Sprint(device.Number)}
-	err := network.Execute(device.Namespace, args...)
+	err := network.DeleteRules(device.Namespace, device.table(), network.IPv4)
+	err = network.FlushRoutes(device.Namespace, device.table(), network.IPv4)
 
-	// ip route flush table <table id>
-	args = []string{"route", "flush", "table", fmt.Sprint(device.Number)}
-	err = network.Execute(device.Namespace, args...)
+	if device.IPv6 != nil {
+		err = network.DeleteRules(device.Namespace, device.table(), network.IPv6)
+		err = network.FlushRoutes(device.Namespace, device.table(), network.IPv6)
+	}
 
 	return err
 }
 
+func (device *Device) table() int {
+	return Table(device.Number)
+}
+
 func (device *Device) serveIngress() {
 
-	ingressRaw := make(chan byte, config.Config.KernelEndpoint.SizeRawIngressBuffer)
-	go device.packetize(ingressRaw)
+	defer close(device.ingressDone)
 
-	reader := io.Reader(&device.vif)
-	storage := make([]byte, config.Config.KernelEndpoint.SizeRawIngressStorage)
+	frames := newFrameBuffer(config.Config.KernelEndpoint.MaxFrameSize)
 	for {
-		nBytesRead, err := io.ReadAtLeast(reader, storage, config.Config.KernelEndpoint.ReadSizeRawIngress)
+		storage := frames.next()
+		nBytesRead, err := device.vif.Read(storage)
 		if err != nil {
+			// The read fails as well if the device is torn down.
+			if device.state.Not(shila.Running) {
+				return
+			}
 			time.Sleep(time.Duration(config.Config.KernelEndpoint.WaitingTimeUntilEscalation) * time.Second)
 			if device.state.Not(shila.Running) {
-				close(ingressRaw)
 				return
 			}
 			device.endpointIssues <- shila.EndpointIssuePub{
@@ -177,8 +221,30 @@ func (device *Device) serveIngress() {
 			}
 			return
 		}
-		for _, b := range storage[:nBytesRead] {
-			ingressRaw <- b
+		if nBytesRead == 0 {
+			continue
+		}
+
+		// Every read returns a single frame, if it does not match the length announced in its
+		// header the frame was truncated (or is corrupt). Drop it and carry on with the next one.
+		if length, err := tcpip.FrameLength(storage[:nBytesRead]); err != nil || length != nBytesRead {
+			log.Error.Print(device.Says(fmt.Sprint("Dropped invalid frame of ", nBytesRead, " bytes.")))
+			continue
+		}
+		rawData := frames.take(nBytesRead)
+
+		if tcpFlow, err := shila.GetTCPFlow(rawData); err != nil {
+			// We were not able to get the IP flow from the raw data. We therefore
+			// just drop the packet and hope that the next one is better..
+			log.Error.Print(device.Says(fmt.Sprint("Unable to get IP net flow. ", err.Error())))
+		} else {
+			p := shila.NewPacket(device, tcpFlow, rawData)
+			capture.Record(device, capture.Ingress, p)
+			select {
+			case device.channels.ingress <- p:
+			case <-device.stop:
+				return
+			}
 		}
 	}
 }
@@ -186,6 +252,7 @@ func (device *Device) serveIngress() {
 func (device *Device) serveEgress() {
 	writer := io.Writer(&device.vif)
 	for p := range device.channels.egress {
+		capture.Record(device, capture.Egress, p)
 		_, err := writer.Write(p.Payload)
 		if err != nil {
 			time.Sleep(time.Duration(config.Config.KernelEndpoint.WaitingTimeUntilEscalation) * time.Second)
@@ -201,30 +268,6 @@ func (device *Device) serveEgress() {
 	}
 }
 
-func (device *Device) packetize(ingressRaw chan byte) {
-	for {
-		if rawData, err := tcpip.PacketizeRawData(ingressRaw, config.Config.KernelEndpoint.SizeRawIngressStorage); rawData != nil {
-			if iPHeader, err := shila.GetTCPFlow(rawData); err != nil {
-				// We were not able to get the IP flow from the raw data, but there was no issue parsing
-				// the raw data. We therefore just drop the packet and hope that the next one is better..
-				log.Error.Print(device.Says(fmt.Sprint("Unable to get IP net flow in packetizer. ", err.Error())))
-			} else {
-				device.channels.ingress <- shila.NewPacket(device, iPHeader, rawData)
-			}
-		} else {
-			if err == nil {
-				// All good, ingress raw closed.
-				return
-			}
-			device.endpointIssues <- shila.EndpointIssuePub{
-				Issuer: device,
-				Error:  shila.PrependError(err, "Error in raw data packetizer."),
-			}
-			return
-		}
-	}
-}
-
 func (device *Device) Says(str string) string {
 	return  fmt.Sprint(device.Identifier(), ": ", str)
 }
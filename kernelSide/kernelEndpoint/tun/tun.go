@@ -49,6 +49,7 @@ import (
 	"fmt"
 	"os"
 	"shila/core/shila"
+	"syscall"
 	"unsafe"
 )
 
@@ -82,6 +83,11 @@ func (device *Device) Allocate() error {
 		return shila.CriticalError(errorString)
 	}
 
+	// A non-blocking descriptor is served by the runtime poller, closing the file unblocks a pending read.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return shila.CriticalError(err.Error())
+	}
 	device.file = os.NewFile(uintptr(fd), device.Name)
 	device.state.Set(shila.Initialized)
 	return nil
@@ -89,9 +95,8 @@ func (device *Device) Allocate() error {
 
 func (device *Device) Deallocate() error {
 	device.state.Set(shila.TornDown)
-	err := device.file.Close()
-	device.file = nil
-	return err
+	// The file is kept, a concurrent read fails on the closed file.
+	return device.file.Close()
 }
 
 func (device *Device) Read(b []byte) (int, error) {
@@ -11,16 +11,16 @@ import (
 type Device struct {
 	Name      string
 	Namespace network.Namespace
-	Subnet    network.Subnet
+	Subnets   []network.Subnet
 	device    tun.Device
 	state     shila.EntityState
 }
 
-func New(name string, namespace network.Namespace, subnet network.Subnet) Device {
+func New(name string, namespace network.Namespace, subnets ...network.Subnet) Device {
 	return Device{
 		Name: 		name,
 		Namespace: 	namespace,
-		Subnet: 	subnet,
+		Subnets: 	subnets,
 		state:		shila.NewEntityState(),
 	}
 }
@@ -72,8 +72,7 @@ func (device *Device) TurnUp() error {
 		return shila.CriticalError(fmt.Sprint("Entity in wrong state ", device.state, "."))
 	}
 
-	args := []string{"link", "set", device.Name, "up"}
-	if err := network.Execute(device.Namespace, args...); err != nil {
+	if err := network.SetLinkUp(device.Namespace, device.Name); err != nil {
 		return err
 	}
 
@@ -84,9 +83,7 @@ func (device *Device) TurnUp() error {
 // TurnDown disables the vif device.
 func (device *Device) TurnDown() error {
 
-	// ip link set <device name> down
-	args := []string{"link", "set", device.Name, "down"}
-	if err := network.Execute(device.Namespace, args...); err != nil {
+	if err := network.SetLinkDown(device.Namespace, device.Name); err != nil {
 		return err
 	}
 
@@ -115,32 +112,23 @@ func (device *Device) assignNamespace() error {
 		return nil
 	}
 
-	// ip link set <device name> netns <namespace name>
-	err := network.Execute(network.Namespace{}, "link", "set", device.Name, "netns", device.Namespace.Name)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return network.MoveLink(device.Name, device.Namespace)
 }
 
-// assignSubnet assign the subnet to the vif device. If the vif device is part of a namespace
+// assignSubnet assign the subnets to the vif device. If the vif device is part of a namespace
 // then it is assumed that the device is already part of this namespace, i.e. that there was
 // was already a successful call to assignNamespace().
 func (device *Device) assignSubnet() error {
-	// ip addr add <subnet> dev <dev name>
-	args := []string{"addr", "add", string(device.Subnet), "dev", device.Name}
-	if err := network.Execute(device.Namespace, args...); err != nil {
-		return err
+	for _, subnet := range device.Subnets {
+		if err := network.AddAddress(device.Namespace, device.Name, subnet); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (device *Device) removeInterface() error {
-	// ip link delete <interface name>
-	args := []string{"link", "delete", device.Name}
-	err := network.Execute(device.Namespace, args...)
-	return err
+	return network.DeleteLink(device.Namespace, device.Name)
 }
 
 func (device *Device) Says(str string) string {
@@ -3,42 +3,94 @@ package kernelSide
 
 import (
 	"fmt"
-	"math/rand"
 	"net"
 	"shila/config"
 	"shila/core/shila"
 	"shila/kernelSide/kernelEndpoint"
 	"shila/kernelSide/network"
 	"shila/log"
-	"time"
+	"shila/supervisor"
+	"sync"
 )
 
-// The table number depends on the number assigned to the virtual interface.
+// The virtual interfaces are numbered, their routing table follows from the number (see kernelEndpoint.Table).
 // The ingress interface has number 1, that is, the first egress interface has number 2.
-const tableNumberOfFirstEgressInterface = "2"
+const numberOfFirstEgressInterface = 2
 
 type Manager struct {
-	endpoints          EndpointMapping
+	endpoints           EndpointMapping
 	trafficChannelPubs 	shila.PacketChannelPubChannels
 	endpointIssues 	   	shila.EndpointIssuePubChannel
+	workingSideIssues	shila.EndpointIssuePubChannels		// Failures of kernel endpoints, the working sides close the affected connections.
 	state              	shila.EntityState
 	ingressNamespace	network.Namespace
 	egressNamespace		network.Namespace
 	ingressIP           net.IP
+	ingressIPv6			net.IP				// Nil if IPv6 is disabled.
+	aliases				AliasMapping		// The IPv6 addresses of the endpoints.
+	withoutDevices		bool				// Endpoints are given, no namespaces, devices or routing to set up.
+	transparent			bool				// Traffic is intercepted in the default namespace, no namespaces to set up.
+	multipathLinks		[]StateLink			// Links excluded from MPTCP by shila, they participated before.
+	sysctls				[]StateSysctl		// Kernel parameters changed, w/ their original value.
+	upstream			bool				// The kernel implements the upstream MPTCP (v1).
+	mptcpLimits			[]StateMPTCPLimits	// Limits of the upstream MPTCP changed, w/ their original value.
+	leftovers			*State				// Everything created so far, what is left behind if shila dies.
+	allocator			*addressAllocator	// Addresses of the egress endpoints.
+	allocatorIPv6		*addressAllocator	// IPv6 addresses of the egress endpoints, nil if IPv6 is disabled.
+	usage				map[kernelEndpoint.Endpoint] int	// Number of connections using an endpoint.
+	draining			map[kernelEndpoint.Endpoint] bool	// Endpoints removed as soon as no connection uses them.
+	failed				map[kernelEndpoint.Endpoint] bool	// Endpoints torn down after a failure, waiting for their successor.
+	supervisors			map[uint8] *supervisor.Supervisor	// Supervisors of the devices, by device number.
+	control				net.Listener		// Control socket to add and remove egress interfaces, nil if disabled.
+	lock				sync.Mutex
 }
 
-type EndpointMapping map[shila.IPAddressKey] *kernelEndpoint.Device
+type EndpointMapping map[shila.IPAddressKey] kernelEndpoint.Endpoint
 
-func New(trafficChannelPubs shila.PacketChannelPubChannels) *Manager {
+// Every endpoint is listed once in the endpoint mapping (by its IPv4 address), its
+// IPv6 address refers to this entry.
+type AliasMapping map[shila.IPAddressKey] shila.IPAddressKey
+
+func New(trafficChannelPubs shila.PacketChannelPubChannels, endpointIssues shila.EndpointIssuePubChannels) *Manager {
+	var ingressIPv6 net.IP
+	if config.Config.KernelSide.EnableIPv6 {
+		ingressIPv6 = net.ParseIP(config.Config.KernelSide.IngressIPv6)
+	}
+	ingressNamespace := network.NewNamespace(config.Config.KernelSide.IngressNamespace)
+	egressNamespace  := network.NewNamespace(config.Config.KernelSide.EgressNamespace)
+	if config.Config.KernelSide.TransparentInterception {
+		ingressNamespace, egressNamespace = network.Namespace{}, network.Namespace{}
+	}
 	return &Manager{
 		trafficChannelPubs: trafficChannelPubs,
 		endpoints:          make(EndpointMapping),
+		aliases:			make(AliasMapping),
 		endpointIssues: 	make(shila.EndpointIssuePubChannel),
+		workingSideIssues:	endpointIssues,
 		state:              shila.NewEntityState(),
-		ingressNamespace: 	network.NewNamespace(config.Config.KernelSide.IngressNamespace),
-		egressNamespace: 	network.NewNamespace(config.Config.KernelSide.EgressNamespace),
+		ingressNamespace: 	ingressNamespace,
+		egressNamespace: 	egressNamespace,
+		transparent:		config.Config.KernelSide.TransparentInterception,
 		ingressIP: 			net.ParseIP(config.Config.KernelSide.IngressIP),
+		ingressIPv6:		ingressIPv6,
+		leftovers:			newState(config.Config.KernelSide.StateFilePath),
+		usage:				make(map[kernelEndpoint.Endpoint] int),
+		draining:			make(map[kernelEndpoint.Endpoint] bool),
+		failed:				make(map[kernelEndpoint.Endpoint] bool),
+		supervisors:		make(map[uint8] *supervisor.Supervisor),
+	}
+}
+
+// NewWithEndpoints creates a kernel side which uses the given (e.g. fake) endpoints instead of
+// virtual interfaces. Neither namespaces nor routing are set up, no privileges are required.
+func NewWithEndpoints(trafficChannelPubs shila.PacketChannelPubChannels, endpointIssues shila.EndpointIssuePubChannels,
+	endpoints ...*kernelEndpoint.Fake) *Manager {
+	manager := New(trafficChannelPubs, endpointIssues)
+	manager.withoutDevices = true
+	for _, endpoint := range endpoints {
+		manager.endpoints[shila.GetIPAddressKey(endpoint.IP)] = endpoint
 	}
+	return manager
 }
 
 func (manager *Manager) Setup() error {
@@ -47,15 +99,37 @@ func (manager *Manager) Setup() error {
 		return shila.CriticalError(fmt.Sprint("Entity in wrong state ", manager.state, "."))
 	}
 
+	if manager.withoutDevices {
+		if err := manager.setupKernelEndpoints(); err != nil {
+			_ = manager.tearDownKernelEndpoints()
+			return shila.PrependError(err, "Unable to setup kernel endpoints.")
+		}
+		manager.state.Set(shila.Initialized)
+		return nil
+	}
+
+	// Remove what a previous run might have left behind
+	if err := ReclaimLeftovers(); err != nil {
+		return shila.PrependError(err, "Unable to reclaim leftovers of a previous run.")
+	}
+
 	// Setup the namespaces
 	if err := manager.setupNamespaces(); err != nil {
 		_ = manager.removeNamespaces()
 		return shila.PrependError(err, "Unable to setup namespace.")
 	}
 
+	// Configure MPTCP
+	if err := manager.setupMPTCP(); err != nil {
+		_ = manager.restoreMPTCP()
+		_ = manager.removeNamespaces()
+		return shila.PrependError(err, "Unable to configure MPTCP.")
+	}
+
 	// Setup additional routing
 	if err := manager.setupAdditionalRouting(); err != nil {
 		_ = manager.clearAdditionalRouting()
+		_ = manager.restoreMPTCP()
 		_ = manager.removeNamespaces()
 		return shila.PrependError(err, "Unable to setup routing.")
 	}
@@ -63,6 +137,7 @@ func (manager *Manager) Setup() error {
 	if err := manager.addKernelEndpoints(); err != nil {
 		manager.clearKernelEndpoints()
 		_ = manager.clearAdditionalRouting()
+		_ = manager.restoreMPTCP()
 		_ = manager.removeNamespaces()
 		return shila.PrependError(err, "Unable to add kernel endpoints.")
 	}
@@ -72,10 +147,35 @@ func (manager *Manager) Setup() error {
 		_ = manager.tearDownKernelEndpoints()
 		manager.clearKernelEndpoints()
 		_ = manager.clearAdditionalRouting()
+		_ = manager.restoreMPTCP()
 		_ = manager.removeNamespaces()
 		return shila.PrependError(err, "Unable to setup kernel endpoints.")
 	}
 
+	// Configure the endpoints of the upstream MPTCP
+	if err := manager.setupMPTCPEndpoints(); err != nil {
+		_ = manager.clearMPTCPEndpoints()
+		_ = manager.tearDownKernelEndpoints()
+		manager.clearKernelEndpoints()
+		_ = manager.clearAdditionalRouting()
+		_ = manager.restoreMPTCP()
+		_ = manager.removeNamespaces()
+		return shila.PrependError(err, "Unable to configure MPTCP endpoints.")
+	}
+
+	// Setup the interception of the traffic
+	if manager.transparent {
+		if err := manager.setupInterception(); err != nil {
+			_ = manager.clearInterception()
+			_ = manager.clearMPTCPEndpoints()
+			_ = manager.tearDownKernelEndpoints()
+			manager.clearKernelEndpoints()
+			_ = manager.clearAdditionalRouting()
+			_ = manager.restoreMPTCP()
+			return shila.PrependError(err, "Unable to setup interception.")
+		}
+	}
+
 	manager.state.Set(shila.Initialized)
 
 	return nil
@@ -96,19 +196,17 @@ func (manager *Manager) Start() error {
 
 	// Announce all the traffic channels to the working side
 	for _, kerep := range manager.endpoints {
-		pub := shila.PacketChannelPub{Publisher: kerep, Channel: kerep.TrafficChannels().Ingress}
-		if 		  kerep.Role() == shila.EgressKernelEndpoint {
-			manager.trafficChannelPubs.Egress <- pub
-		} else if kerep.Role() == shila.IngressKernelEndpoint {
-			manager.trafficChannelPubs.Ingress <- pub
-		} else {
-			return shila.CriticalError(fmt.Sprint("Invalid kernel endpoint label ", kerep.Role(), "."))
+		if err := manager.announce(kerep); err != nil {
+			return err
 		}
-
 	}
 
 	manager.state.Set(shila.Running)
 
+	if err := manager.startControl(); err != nil {
+		return err
+	}
+
 	log.Verbose.Println("Kernel side started.")
 
 	return nil
@@ -118,10 +216,23 @@ func (manager *Manager) CleanUp() error {
 
 	manager.state.Set(shila.TornDown)
 
-	err := manager.tearDownKernelEndpoints()
+	err := manager.stopControl()
+	if manager.transparent {
+		err = manager.clearInterception()
+	}
+	if !manager.withoutDevices {
+		err = manager.clearMPTCPEndpoints()
+	}
+	err = manager.tearDownKernelEndpoints()
 	manager.clearKernelEndpoints()
-	err = manager.clearAdditionalRouting()
-	err = manager.removeNamespaces()
+	if !manager.withoutDevices {
+		err = manager.clearAdditionalRouting()
+		err = manager.restoreMPTCP()
+		err = manager.removeNamespaces()
+		if err == nil {
+			err = manager.leftovers.remove()
+		}
+	}
 
 	close(manager.endpointIssues)
 
@@ -129,15 +240,48 @@ func (manager *Manager) CleanUp() error {
 }
 
 func (manager *Manager) GetTrafficChannels(key shila.IPAddressKey) (shila.PacketChannels, bool) {
-	if endpoint, ok := manager.endpoints[key]; !ok {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if endpoint, ok := manager.lookup(key); !ok {
 		return shila.PacketChannels{}, false
 	} else {
 		return endpoint.TrafficChannels(), true
 	}
 }
 
+// Announces the traffic channel of the endpoint to the corresponding working side.
+func (manager *Manager) announce(kerep kernelEndpoint.Endpoint) error {
+	pub := shila.PacketChannelPub{Publisher: kerep, Channel: kerep.TrafficChannels().Ingress}
+	if 		  kerep.Role() == shila.EgressKernelEndpoint {
+		manager.trafficChannelPubs.Egress <- pub
+	} else if kerep.Role() == shila.IngressKernelEndpoint {
+		manager.trafficChannelPubs.Ingress <- pub
+	} else {
+		return shila.CriticalError(fmt.Sprint("Invalid kernel endpoint label ", kerep.Role(), "."))
+	}
+	return nil
+}
+
+func (manager *Manager) lookup(key shila.IPAddressKey) (kernelEndpoint.Endpoint, bool) {
+	if alias, ok := manager.aliases[key]; ok {
+		key = alias
+	}
+	endpoint, ok := manager.endpoints[key]
+	if !ok && manager.transparent {
+		// Intercepted connections are destined to the actual address of the host, not to
+		// the address of the ingress endpoint. They are all received through the latter.
+		endpoint, ok = manager.endpoints[shila.GetIPAddressKey(manager.ingressIP)]
+	}
+	return endpoint, ok
+}
+
 func (manager *Manager) setupKernelEndpoints() error {
 	for _, kerep := range manager.endpoints {
+		if device, ok := kerep.(*kernelEndpoint.Device); ok && !manager.withoutDevices {
+			if err := manager.leftovers.addDevice(device.Namespace, device.Name, kernelEndpoint.Table(device.Number)); err != nil {
+				return err
+			}
+		}
 		if err := kerep.Setup(); err != nil {
 			return err
 		}
@@ -159,7 +303,11 @@ func (manager *Manager) startKernelEndpoints() error {
 func (manager *Manager) tearDownKernelEndpoints() error {
 	var err error = nil
 	for _, kerep := range manager.endpoints {
-			_ = kerep.TearDown()
+		// A failed endpoint is already torn down.
+		if manager.failed[kerep] {
+			continue
+		}
+		_ = kerep.TearDown()
 	}
 	return err
 }
@@ -168,12 +316,18 @@ func (manager *Manager) clearKernelEndpoints() {
 	for k := range manager.endpoints {
 		delete(manager.endpoints, k)
 	}
+	for k := range manager.aliases {
+		delete(manager.aliases, k)
+	}
 }
 
 func (manager *Manager) setupNamespaces() error {
 
 	// Create ingress namespace
 	if manager.ingressNamespace.NonEmpty {
+		if err := manager.leftovers.addNamespace(manager.ingressNamespace); err != nil {
+			return err
+		}
 		if err := network.AddNamespace(manager.ingressNamespace); err != nil {
 			return err
 		}
@@ -181,6 +335,9 @@ func (manager *Manager) setupNamespaces() error {
 
 	// Create egress namespace
 	if manager.egressNamespace.NonEmpty {
+		if err := manager.leftovers.addNamespace(manager.egressNamespace); err != nil {
+			return err
+		}
 		if err := network.AddNamespace(manager.egressNamespace); err != nil {
 			return err
 		}
@@ -209,24 +366,26 @@ func (manager *Manager) addKernelEndpoints() error {
 
 	// Add the ingress kernel endpoint.
 	key := shila.GetIPAddressKey(manager.ingressIP)
-	kerep := kernelEndpoint.New(1, manager.ingressNamespace, manager.ingressIP, shila.IngressKernelEndpoint, manager.endpointIssues)
+	kerep := kernelEndpoint.New(numberOfIngressInterface, manager.ingressNamespace, manager.ingressIP, manager.ingressIPv6, shila.IngressKernelEndpoint, manager.endpointIssues)
 	manager.endpoints[key] = &kerep
+	if manager.ingressIPv6 != nil {
+		manager.aliases[shila.GetIPAddressKey(manager.ingressIPv6)] = key
+	}
 
 	// Add the egress kernel endpoint(s).
-	numberOfEndpointsAdded := uint8(0)
-	for {
-		ip := getRandomIP()
-		key := shila.GetIPAddressKey(ip)
-		if _, ok := manager.endpoints[key]; !ok {
-
-			number := numberOfEndpointsAdded + 2
-			kerep := kernelEndpoint.New(number, manager.egressNamespace, ip, shila.EgressKernelEndpoint, manager.endpointIssues)
-
-			manager.endpoints[key] = &kerep
-			numberOfEndpointsAdded++
+	var err error
+	if manager.allocator, err = newAddressAllocator(config.Config.KernelSide.EgressSubnet, manager.ingressIP); err != nil {
+		return err
+	}
+	if manager.ingressIPv6 != nil {
+		if manager.allocatorIPv6, err = newAddressAllocator(config.Config.KernelSide.EgressSubnetIPv6, manager.ingressIPv6); err != nil {
+			return err
 		}
-		if numberOfEndpointsAdded == uint8(config.Config.KernelSide.NumberOfEgressInterfaces) {
-			break
+	}
+
+	for i := 0; i < config.Config.KernelSide.NumberOfEgressInterfaces; i++ {
+		if _, err := manager.addEgressDevice(); err != nil {
+			return err
 		}
 	}
 
@@ -239,25 +398,54 @@ func (manager *Manager) setupAdditionalRouting() error {
 
 	// If the ingress and egress interfaces are isolated in its own and fresh namespace,
 	// then there is just the local interface which could also try to participate in MPTCP.
-	// However, if this is not the case, then there could possibly multiple interfaces which
-	// also want to participate. // TODO.
+	// In the transparent mode, all interfaces of the host present so far are excluded.
 
-	// ip link set dev lo multipath off
-	args := []string{"link", "set", "dev", "lo", "multipath", "off"}
-	if err := network.Execute(manager.ingressNamespace, args...); err != nil {
-		return err
+	links := []StateLink{{Namespace: manager.ingressNamespace, Name: "lo"}, {Namespace: manager.egressNamespace, Name: "lo"}}
+	if manager.transparent {
+		names, err := network.LinkNames(network.Namespace{})
+		if err != nil {
+			return err
+		}
+		links = links[:0]
+		for _, name := range names {
+			links = append(links, StateLink{Name: name})
+		}
 	}
-	if err := network.Execute(manager.egressNamespace, args...); err != nil {
-		return err
+	for _, link := range links {
+		// A link already excluded is left alone, it stays excluded after the clean up as well.
+		enabled, err := network.Multipath(link.Namespace, link.Name)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			continue
+		}
+		if err := manager.leftovers.addMultipath(link.Namespace, link.Name); err != nil {
+			return err
+		}
+		if err := network.SetMultipath(link.Namespace, link.Name, false); err != nil {
+			return err
+		}
+		manager.multipathLinks = append(manager.multipathLinks, link)
+	}
+
+	// In the transparent mode, the connections are steered through the egress devices by the interception.
+	if manager.transparent {
+		return nil
 	}
 
 	// SYN packets coming from client side connect calls are sent from the
 	// local interface, route them through one of the egress devices..
-
-	// ip rule add to <ip> iif lo table <id>
-	args = []string{"rule", "add", "to", manager.ingressIP.String(), "table", tableNumberOfFirstEgressInterface}
-	if err := network.Execute(manager.egressNamespace, args...); err != nil {
-		return err
+	for _, ip := range []net.IP{manager.ingressIP, manager.ingressIPv6} {
+		if ip == nil {
+			continue
+		}
+		if err := manager.leftovers.addRuleTo(manager.egressNamespace, network.HostPrefix(ip), false, kernelEndpoint.Table(numberOfFirstEgressInterface)); err != nil {
+			return err
+		}
+		if err := network.AddRuleTo(manager.egressNamespace, ip, kernelEndpoint.Table(numberOfFirstEgressInterface)); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -267,28 +455,20 @@ func (manager *Manager) clearAdditionalRouting() error {
 
 	// Roll back the restriction of the use of MPTCP to the virtual devices.
 
-	// If the ingress and egress interfaces are isolated in its own and fresh namespace,
-	// then there is just the local interface which could also try to participate in MPTCP.
-	// However, if this is not the case, then there could possibly multiple interfaces which
-	// also want to participate. // TODO.
+	var err error
+	for _, link := range manager.multipathLinks {
+		err = network.SetMultipath(link.Namespace, link.Name, true)
+	}
+	manager.multipathLinks = nil
 
-	// ip link set dev lo multipath on
-	args := []string{"link", "set", "dev", "lo", "multipath", "on"}
-	err := network.Execute(manager.ingressNamespace, args...)
-	err = network.Execute(manager.egressNamespace, args...)
+	if manager.transparent {
+		return err
+	}
 
-	// ip rule add to <ip> iif lo table <id>
-	args = []string{"rule", "delete", "to", manager.ingressIP.String(), "table", tableNumberOfFirstEgressInterface}
-	err = network.Execute(manager.egressNamespace, args...)
+	err = network.DeleteRuleTo(manager.egressNamespace, manager.ingressIP, kernelEndpoint.Table(numberOfFirstEgressInterface))
+	if manager.ingressIPv6 != nil {
+		err = network.DeleteRuleTo(manager.egressNamespace, manager.ingressIPv6, kernelEndpoint.Table(numberOfFirstEgressInterface))
+	}
 
 	return err
 }
-
-func getRandomIP() net.IP {
-		rand.Seed(time.Now().Unix())
-		return net.IPv4(
-			byte(rand.Intn(256)),
-			byte(rand.Intn(256)),
-			byte(rand.Intn(256)),
-			byte(rand.Intn(256)))
-}
\ No newline at end of file
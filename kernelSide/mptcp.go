@@ -0,0 +1,252 @@
+//
+package kernelSide
+
+import (
+	"fmt"
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/kernelSide/kernelEndpoint"
+	"shila/kernelSide/network"
+	"shila/log"
+	"sort"
+	"strings"
+)
+
+// The kernel parameters of MPTCP (and TCP) are applied in the namespaces of shila during the setup. Their
+// original values are recorded and restored during the clean up: parameters which are not namespace
+// aware affect the whole host, even if they are set within a namespace of shila.
+
+// Always reported after the setup, whether configured or not.
+var reportedSysctls = []string{
+	"net.mptcp.mptcp_enabled",
+	"net.mptcp.enabled",
+	"net.mptcp.mptcp_path_manager",
+	"net.mptcp.mptcp_scheduler",
+	"net.ipv4.tcp_congestion_control",
+}
+
+type sysctl struct {
+	key   string
+	value string
+}
+
+// Returns the configured kernel parameters in the order they are applied.
+func configuredSysctls() []sysctl {
+
+	var sysctls []sysctl
+	if value := config.Config.MPTCP.PathManager; value != "" {
+		sysctls = append(sysctls, sysctl{"net.mptcp.mptcp_path_manager", value})
+	}
+	if value := config.Config.MPTCP.Scheduler; value != "" {
+		sysctls = append(sysctls, sysctl{"net.mptcp.mptcp_scheduler", value})
+	}
+	if value := config.Config.MPTCP.CongestionControl; value != "" {
+		sysctls = append(sysctls, sysctl{"net.ipv4.tcp_congestion_control", value})
+	}
+
+	keys := make([]string, 0, len(config.Config.MPTCP.Sysctls))
+	for key := range config.Config.MPTCP.Sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sysctls = append(sysctls, sysctl{key, config.Config.MPTCP.Sysctls[key]})
+	}
+
+	return sysctls
+}
+
+func (manager *Manager) setupMPTCP() error {
+
+	for _, namespace := range manager.namespaces() {
+		for _, s := range configuredSysctls() {
+			original, err := network.GetSysctl(namespace, sysctlPath(s.key))
+			if err != nil {
+				return err
+			}
+			// A parameter shared by the namespaces is already set.
+			if original == s.value {
+				continue
+			}
+			if err := manager.leftovers.addSysctl(namespace, s.key, original); err != nil {
+				return err
+			}
+			if err := network.SetSysctl(namespace, sysctlPath(s.key), s.value); err != nil {
+				return err
+			}
+			manager.sysctls = append(manager.sysctls, StateSysctl{Namespace: namespace, Key: s.key, Value: original})
+		}
+		logMPTCP(namespace)
+	}
+
+	return nil
+}
+
+func (manager *Manager) restoreMPTCP() error {
+
+	// In reverse order, such that a parameter changed twice gets back its very first value.
+	var err error
+	for i := len(manager.sysctls) - 1; i >= 0; i-- {
+		s := manager.sysctls[i]
+		if errRestore := network.SetSysctl(s.Namespace, sysctlPath(s.Key), s.Value); errRestore != nil {
+			err = errRestore
+		}
+	}
+	manager.sysctls = nil
+
+	return err
+}
+
+// Logs the effective values of the reported and the configured kernel parameters. Parameters
+// unknown to the kernel at hand are skipped.
+func logMPTCP(namespace network.Namespace) {
+
+	keys := append([]string{}, reportedSysctls...)
+	for _, s := range configuredSysctls() {
+		known := false
+		for _, key := range keys {
+			known = known || key == s.key
+		}
+		if !known {
+			keys = append(keys, s.key)
+		}
+	}
+
+	var values []string
+	for _, key := range keys {
+		if value, err := network.GetSysctl(namespace, sysctlPath(key)); err == nil {
+			values = append(values, fmt.Sprint(key, "=", value))
+		}
+	}
+
+	name := "default"
+	if namespace.NonEmpty {
+		name = namespace.Name
+	}
+	log.Info.Print("MPTCP settings in namespace ", name, ": ", strings.Join(values, ", "), ".")
+}
+
+// The upstream kernel opens sub flows from the MPTCP endpoints only, every egress interface gets one
+// per address. The number of sub flows per connection is limited, the limit is raised to the maximum.
+
+// The maximal number of sub flows the in-kernel path manager allows (MPTCP_PM_ADDR_MAX).
+const maxSubflowsUpstream = 8
+
+func (manager *Manager) setupMPTCPEndpoints() error {
+
+	manager.upstream = network.UpstreamMPTCP(manager.egressNamespace)
+	if !manager.upstream {
+		return nil
+	}
+	log.Info.Print("Upstream MPTCP (v1), configuring MPTCP endpoints for the egress interfaces.")
+
+	for _, namespace := range manager.namespaces() {
+		limits, err := network.GetMPTCPLimits(namespace)
+		if err != nil {
+			return err
+		}
+		if limits.Subflows >= maxSubflowsUpstream {
+			continue
+		}
+		if err := manager.leftovers.addMPTCPLimits(namespace, limits); err != nil {
+			return err
+		}
+		raised := limits
+		raised.Subflows = maxSubflowsUpstream
+		if err := network.SetMPTCPLimits(namespace, raised); err != nil {
+			return err
+		}
+		manager.mptcpLimits = append(manager.mptcpLimits, StateMPTCPLimits{Namespace: namespace, Limits: limits})
+	}
+
+	for _, endpoint := range manager.endpoints {
+		if device, ok := endpoint.(*kernelEndpoint.Device); ok && device.Role() == shila.EgressKernelEndpoint {
+			if err := manager.addMPTCPEndpoints(device); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (manager *Manager) clearMPTCPEndpoints() error {
+
+	var err error
+	for _, endpoint := range manager.endpoints {
+		if device, ok := endpoint.(*kernelEndpoint.Device); ok && device.Role() == shila.EgressKernelEndpoint {
+			err = manager.removeMPTCPEndpoints(device)
+		}
+	}
+	for _, limits := range manager.mptcpLimits {
+		err = network.SetMPTCPLimits(limits.Namespace, limits.Limits)
+	}
+	manager.mptcpLimits = nil
+
+	return err
+}
+
+func (manager *Manager) addMPTCPEndpoints(device *kernelEndpoint.Device) error {
+	if !manager.upstream {
+		return nil
+	}
+	for _, id := range mptcpEndpointIDs(device) {
+		if err := manager.leftovers.addMPTCPEndpoint(device.Namespace, id.id); err != nil {
+			return err
+		}
+		if err := network.AddMPTCPEndpoint(device.Namespace, id.id, id.ip, device.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (manager *Manager) removeMPTCPEndpoints(device *kernelEndpoint.Device) error {
+	if !manager.upstream {
+		return nil
+	}
+	var err error
+	for _, id := range mptcpEndpointIDs(device) {
+		if errDelete := network.DeleteMPTCPEndpoint(device.Namespace, id.id); errDelete != nil {
+			err = errDelete
+		}
+	}
+	return err
+}
+
+// disableMultipath excludes the device from MPTCP, no new sub flows are opened through it.
+func (manager *Manager) disableMultipath(device *kernelEndpoint.Device) error {
+	if manager.upstream {
+		return manager.removeMPTCPEndpoints(device)
+	}
+	return network.SetMultipath(device.Namespace, device.Name, false)
+}
+
+type mptcpEndpointID struct {
+	id uint8
+	ip net.IP
+}
+
+// The endpoints are numbered after the device, the one of the IPv6 address is offset by 128. Devices
+// with a number beyond 127 get no IPv6 endpoint.
+func mptcpEndpointIDs(device *kernelEndpoint.Device) []mptcpEndpointID {
+	ids := []mptcpEndpointID{{device.Number, device.IP}}
+	if device.IPv6 != nil && device.Number < 0x80 {
+		ids = append(ids, mptcpEndpointID{device.Number | 0x80, device.IPv6})
+	}
+	return ids
+}
+
+// The namespaces of shila, each listed once.
+func (manager *Manager) namespaces() []network.Namespace {
+	if manager.ingressNamespace == manager.egressNamespace {
+		return []network.Namespace{manager.ingressNamespace}
+	}
+	return []network.Namespace{manager.ingressNamespace, manager.egressNamespace}
+}
+
+// Kernel parameters are named with dots (net.mptcp.mptcp_enabled), but located in /proc/sys/net/mptcp/.
+func sysctlPath(key string) string {
+	return strings.Replace(key, ".", "/", -1)
+}
@@ -0,0 +1,69 @@
+//
+package network
+
+import (
+	"fmt"
+	"github.com/coreos/go-iptables/iptables"
+)
+
+// The connection marks are the only firewall rules shila needs, they are just set up in the transparent
+// interception mode. Netlink does not cover the firewall, the rules are installed through iptables.
+
+// AddConnectionMark marks all connections received through the device and restores the mark on
+// every packet sent by the host within such a connection. Just the given families are covered.
+func AddConnectionMark(device string, mark int, families []Family) error {
+	for _, family := range families {
+		ipt, err := iptables.NewWithProtocol(protocolOf(family))
+		if err != nil {
+			return Error{"open firewall", Namespace{}, err}
+		}
+		for _, rule := range connectionMarkRules(device, mark) {
+			if err := ipt.AppendUnique("mangle", rule.chain, rule.spec...); err != nil {
+				return Error{fmt.Sprint("add firewall rule to ", rule.chain), Namespace{}, err}
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteConnectionMark removes the rules added by AddConnectionMark, missing rules are not an error.
+func DeleteConnectionMark(device string, mark int, families []Family) error {
+	var errLast error
+	for _, family := range families {
+		ipt, err := iptables.NewWithProtocol(protocolOf(family))
+		if err != nil {
+			errLast = Error{"open firewall", Namespace{}, err}
+			continue
+		}
+		for _, rule := range connectionMarkRules(device, mark) {
+			if exists, err := ipt.Exists("mangle", rule.chain, rule.spec...); err != nil || !exists {
+				continue
+			}
+			if err := ipt.Delete("mangle", rule.chain, rule.spec...); err != nil {
+				errLast = Error{fmt.Sprint("delete firewall rule from ", rule.chain), Namespace{}, err}
+			}
+		}
+	}
+	return errLast
+}
+
+func protocolOf(family Family) iptables.Protocol {
+	if family == IPv6 {
+		return iptables.ProtocolIPv6
+	}
+	return iptables.ProtocolIPv4
+}
+
+type firewallRule struct {
+	chain string
+	spec  []string
+}
+
+func connectionMarkRules(device string, mark int) []firewallRule {
+	return []firewallRule{
+		// iptables -t mangle -A PREROUTING -i <device> -j CONNMARK --set-mark <mark>
+		{"PREROUTING", []string{"-i", device, "-j", "CONNMARK", "--set-mark", fmt.Sprint(mark)}},
+		// iptables -t mangle -A OUTPUT -m connmark --mark <mark> -j CONNMARK --restore-mark
+		{"OUTPUT", []string{"-m", "connmark", "--mark", fmt.Sprint(mark), "-j", "CONNMARK", "--restore-mark"}},
+	}
+}
@@ -0,0 +1,149 @@
+//
+package network
+
+import (
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+// The upstream kernel (since 5.6) does not know the multipath flag of the links, it just opens sub flows
+// from the addresses configured as MPTCP endpoints (ip mptcp endpoint). The endpoints and the limits are
+// managed through the generic netlink family of the in-kernel path manager.
+
+const (
+	mptcpPMName    = "mptcp_pm"
+	mptcpPMVersion = 1
+
+	mptcpPMCmdAddAddr   = 1
+	mptcpPMCmdDelAddr   = 2
+	mptcpPMCmdSetLimits = 5
+	mptcpPMCmdGetLimits = 6
+
+	mptcpPMAttrAddr        = 1
+	mptcpPMAttrRcvAddAddrs = 2
+	mptcpPMAttrSubflows    = 3
+
+	mptcpPMAddrAttrFamily = 1
+	mptcpPMAddrAttrID     = 2
+	mptcpPMAddrAttrAddr4  = 3
+	mptcpPMAddrAttrAddr6  = 4
+	mptcpPMAddrAttrFlags  = 6
+	mptcpPMAddrAttrIfIdx  = 7
+
+	mptcpPMAddrFlagSubflow = 0x2
+)
+
+// MPTCPLimits of the in-kernel path manager.
+type MPTCPLimits struct {
+	Subflows        uint32 // Maximal number of additional sub flows per connection.
+	AddAddrAccepted uint32 // Maximal number of addresses announced by the peer which are used.
+}
+
+// UpstreamMPTCP is true if the kernel implements the upstream MPTCP (v1).
+func UpstreamMPTCP(namespace Namespace) bool {
+	_, err := GetSysctl(namespace, "net/mptcp/enabled")
+	return err == nil
+}
+
+// AddMPTCPEndpoint adds the address of the link as MPTCP endpoint, such that sub flows are opened from it.
+// The id identifies the endpoint within the namespace, an existing endpoint is not an error.
+func AddMPTCPEndpoint(namespace Namespace, id uint8, ip net.IP, name string) error {
+	err := withLink(namespace, name, "add mptcp endpoint " + ip.String(), func(link netlink.Link) error {
+		addr := nl.NewRtAttr(mptcpPMAttrAddr|unix.NLA_F_NESTED, nil)
+		addr.AddRtAttr(mptcpPMAddrAttrFamily, nl.Uint16Attr(uint16(FamilyOf(ip))))
+		addr.AddRtAttr(mptcpPMAddrAttrID, []byte{id})
+		if ip4 := ip.To4(); ip4 != nil {
+			addr.AddRtAttr(mptcpPMAddrAttrAddr4, []byte(ip4))
+		} else {
+			addr.AddRtAttr(mptcpPMAddrAttrAddr6, []byte(ip.To16()))
+		}
+		addr.AddRtAttr(mptcpPMAddrAttrFlags, nl.Uint32Attr(mptcpPMAddrFlagSubflow))
+		addr.AddRtAttr(mptcpPMAddrAttrIfIdx, nl.Uint32Attr(uint32(link.Attrs().Index)))
+		_, err := executeMPTCP(mptcpPMCmdAddAddr, addr)
+		return err
+	})
+	if e, ok := err.(Error); ok && e.Exists() {
+		return nil
+	}
+	return err
+}
+
+// DeleteMPTCPEndpoint removes the endpoint with the given id, a missing endpoint is not an error.
+func DeleteMPTCPEndpoint(namespace Namespace, id uint8) error {
+	return inNamespace(namespace, func() error {
+		addr := nl.NewRtAttr(mptcpPMAttrAddr|unix.NLA_F_NESTED, nil)
+		addr.AddRtAttr(mptcpPMAddrAttrID, []byte{id})
+		if _, err := executeMPTCP(mptcpPMCmdDelAddr, addr); err != nil {
+			// The kernel reports a missing endpoint as invalid argument.
+			if e := (Error{"delete mptcp endpoint", namespace, err}); !e.NotFound() && err != unix.EINVAL {
+				return e
+			}
+		}
+		return nil
+	})
+}
+
+// GetMPTCPLimits returns the limits of the in-kernel path manager.
+func GetMPTCPLimits(namespace Namespace) (MPTCPLimits, error) {
+	var limits MPTCPLimits
+	err := inNamespace(namespace, func() error {
+		msgs, err := executeMPTCP(mptcpPMCmdGetLimits)
+		if err != nil {
+			return Error{"get mptcp limits", namespace, err}
+		}
+		for _, msg := range msgs {
+			if len(msg) < nl.SizeofGenlmsg {
+				continue
+			}
+			attrs, err := nl.ParseRouteAttr(msg[nl.SizeofGenlmsg:])
+			if err != nil {
+				return Error{"get mptcp limits", namespace, err}
+			}
+			for _, attr := range attrs {
+				switch attr.Attr.Type {
+				case mptcpPMAttrSubflows:
+					limits.Subflows = native.Uint32(attr.Value)
+				case mptcpPMAttrRcvAddAddrs:
+					limits.AddAddrAccepted = native.Uint32(attr.Value)
+				}
+			}
+		}
+		return nil
+	})
+	return limits, err
+}
+
+// SetMPTCPLimits sets the limits of the in-kernel path manager.
+func SetMPTCPLimits(namespace Namespace, limits MPTCPLimits) error {
+	return inNamespace(namespace, func() error {
+		_, err := executeMPTCP(mptcpPMCmdSetLimits,
+			nl.NewRtAttr(mptcpPMAttrRcvAddAddrs, nl.Uint32Attr(limits.AddAddrAccepted)),
+			nl.NewRtAttr(mptcpPMAttrSubflows, nl.Uint32Attr(limits.Subflows)))
+		if err != nil {
+			return Error{"set mptcp limits", namespace, err}
+		}
+		return nil
+	})
+}
+
+var native = nl.NativeEndian()
+
+// Sends the command to the path manager of the current namespace and returns the replies.
+func executeMPTCP(command uint8, attrs ...*nl.RtAttr) ([][]byte, error) {
+	family, err := netlink.GenlFamilyGet(mptcpPMName)
+	if err != nil {
+		return nil, err
+	}
+	flags := unix.NLM_F_ACK
+	if command == mptcpPMCmdGetLimits {
+		flags = 0
+	}
+	req := nl.NewNetlinkRequest(int(family.ID), flags)
+	req.AddData(&nl.Genlmsg{Command: command, Version: mptcpPMVersion})
+	for _, attr := range attrs {
+		req.AddData(attr)
+	}
+	return req.Execute(unix.NETLINK_GENERIC, 0)
+}
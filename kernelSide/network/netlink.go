@@ -0,0 +1,313 @@
+//
+package network
+
+import (
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+// Interface flag of the MPTCP kernel, the interface does not participate in MPTCP if set.
+const iffNoMultipath = 0x80000
+
+// MoveLink moves the link from the namespace of shila into the given namespace.
+func MoveLink(name string, namespace Namespace) error {
+
+	if !namespace.NonEmpty {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return Error{"find link " + name, Namespace{}, err}
+	}
+	target, err := netns.GetFromName(namespace.Name)
+	if err != nil {
+		return Error{"open namespace", namespace, err}
+	}
+	defer target.Close()
+
+	if err := netlink.LinkSetNsFd(link, int(target)); err != nil {
+		return Error{"move link " + name, namespace, err}
+	}
+	return nil
+}
+
+// SetLinkUp enables the link.
+func SetLinkUp(namespace Namespace, name string) error {
+	return withLink(namespace, name, "set link up", netlink.LinkSetUp)
+}
+
+// SetLinkDown disables the link.
+func SetLinkDown(namespace Namespace, name string) error {
+	return withLink(namespace, name, "set link down", netlink.LinkSetDown)
+}
+
+// DeleteLink removes the link, a missing link is not an error.
+func DeleteLink(namespace Namespace, name string) error {
+	err := withLink(namespace, name, "delete link", netlink.LinkDel)
+	if e, ok := err.(Error); ok && e.NotFound() {
+		return nil
+	}
+	return err
+}
+
+// Multipath is true if the link participates in MPTCP.
+func Multipath(namespace Namespace, name string) (bool, error) {
+	var enabled bool
+	err := withLink(namespace, name, "get multipath", func(link netlink.Link) error {
+		enabled = link.Attrs().RawFlags & iffNoMultipath == 0
+		return nil
+	})
+	return enabled, err
+}
+
+// SetMultipath enables or disables the participation of the link in MPTCP.
+func SetMultipath(namespace Namespace, name string, enabled bool) error {
+	return withLink(namespace, name, "set multipath", func(link netlink.Link) error {
+		req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_ACK)
+		msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+		msg.Index  = int32(link.Attrs().Index)
+		msg.Change = iffNoMultipath
+		if !enabled {
+			msg.Flags = iffNoMultipath
+		}
+		req.AddData(msg)
+		_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+		return err
+	})
+}
+
+// AddAddress assigns the address of the subnet to the link, an assigned address is not an error.
+// IPv6 addresses skip the duplicate address detection, such that they are usable right away.
+func AddAddress(namespace Namespace, name string, subnet Subnet) error {
+	ipNet, err := subnet.IPNet()
+	if err != nil {
+		return Error{"parse address " + string(subnet), namespace, err}
+	}
+	addr := &netlink.Addr{IPNet: ipNet}
+	if subnet.IsIPv6() {
+		addr.Flags = unix.IFA_F_NODAD
+	}
+	err = withLink(namespace, name, "add address " + string(subnet), func(link netlink.Link) error {
+		return netlink.AddrAdd(link, addr)
+	})
+	if e, ok := err.(Error); ok && e.Exists() {
+		return nil
+	}
+	return err
+}
+
+// AddRuleFrom adds a policy rule which looks up the given table for traffic from the address.
+func AddRuleFrom(namespace Namespace, ip net.IP, table int) error {
+	rule := netlink.NewRule()
+	rule.Src   = HostPrefix(ip)
+	rule.Table = table
+	return addRule(namespace, rule, "add rule from " + ip.String())
+}
+
+// AddRuleTo adds a policy rule which looks up the given table for traffic to the address.
+func AddRuleTo(namespace Namespace, ip net.IP, table int) error {
+	return AddRuleToPrefix(namespace, HostPrefix(ip), false, table, -1)
+}
+
+// DeleteRuleTo removes the policy rule added by AddRuleTo, a missing rule is not an error.
+func DeleteRuleTo(namespace Namespace, ip net.IP, table int) error {
+	return DeleteRuleToPrefix(namespace, HostPrefix(ip), false, table)
+}
+
+// AddRuleToPrefix adds a policy rule which looks up the given table for traffic to the prefix,
+// restricted to TCP if tcpOnly is set. The kernel chooses the priority if it is negative.
+func AddRuleToPrefix(namespace Namespace, prefix *net.IPNet, tcpOnly bool, table int, priority int) error {
+	rule := newRuleToPrefix(prefix, tcpOnly, table)
+	rule.Priority = priority
+	return addRule(namespace, rule, "add rule to " + prefix.String())
+}
+
+// DeleteRuleToPrefix removes the policy rule added by AddRuleToPrefix, a missing rule is not an error.
+func DeleteRuleToPrefix(namespace Namespace, prefix *net.IPNet, tcpOnly bool, table int) error {
+	return deleteRule(namespace, newRuleToPrefix(prefix, tcpOnly, table), "delete rule to " + prefix.String())
+}
+
+// AddRuleMark adds a policy rule which looks up the given table for traffic carrying the firewall mark.
+func AddRuleMark(namespace Namespace, mark int, table int, family Family) error {
+	return addRule(namespace, newRuleMark(mark, table, family), "add rule for mark")
+}
+
+// DeleteRuleMark removes the policy rule added by AddRuleMark, a missing rule is not an error.
+func DeleteRuleMark(namespace Namespace, mark int, table int, family Family) error {
+	return deleteRule(namespace, newRuleMark(mark, table, family), "delete rule for mark")
+}
+
+// LinkNames returns the names of all links in the namespace.
+func LinkNames(namespace Namespace) ([]string, error) {
+	var names []string
+	err := inNamespace(namespace, func() error {
+		links, err := netlink.LinkList()
+		if err != nil {
+			return Error{"list links", namespace, err}
+		}
+		for _, link := range links {
+			names = append(names, link.Attrs().Name)
+		}
+		return nil
+	})
+	return names, err
+}
+
+// LinkAddresses returns the addresses assigned to the link.
+func LinkAddresses(namespace Namespace, name string) ([]net.IP, error) {
+	var ips []net.IP
+	err := withLink(namespace, name, "list addresses", func(link netlink.Link) error {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		return nil
+	})
+	return ips, err
+}
+
+// RoutePrefixes returns the destination prefixes of the routes of the family in all tables of the namespace.
+// Default routes are left out.
+func RoutePrefixes(namespace Namespace, family Family) ([]*net.IPNet, error) {
+	var prefixes []*net.IPNet
+	err := inNamespace(namespace, func() error {
+		routes, err := netlink.RouteListFiltered(int(family), &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return Error{"list routes", namespace, err}
+		}
+		for _, route := range routes {
+			if route.Dst != nil {
+				prefixes = append(prefixes, route.Dst)
+			}
+		}
+		return nil
+	})
+	return prefixes, err
+}
+
+// DeleteRules removes all policy rules of the family which look up the given table.
+func DeleteRules(namespace Namespace, table int, family Family) error {
+	return inNamespace(namespace, func() error {
+		rules, err := netlink.RuleList(int(family))
+		if err != nil {
+			return Error{"list rules", namespace, err}
+		}
+		for _, rule := range rules {
+			if rule.Table != table {
+				continue
+			}
+			rule := rule
+			if err := netlink.RuleDel(&rule); err != nil {
+				if e := (Error{"delete rule", namespace, err}); !e.NotFound() {
+					return e
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// AddDefaultRoute adds a default route via the link to the given table, an existing route is replaced.
+func AddDefaultRoute(namespace Namespace, name string, table int, family Family) error {
+	return withLink(namespace, name, "add default route", func(link netlink.Link) error {
+		route := &netlink.Route{
+			LinkIndex: 	link.Attrs().Index,
+			Table:     	table,
+			Dst:       	defaultPrefix(family),
+		}
+		if family == IPv4 {
+			route.Scope = netlink.SCOPE_LINK
+		}
+		return netlink.RouteReplace(route)
+	})
+}
+
+// FlushRoutes removes all routes of the family from the given table.
+func FlushRoutes(namespace Namespace, table int, family Family) error {
+	return inNamespace(namespace, func() error {
+		routes, err := netlink.RouteListFiltered(int(family), &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return Error{"list routes", namespace, err}
+		}
+		for _, route := range routes {
+			route := route
+			if err := netlink.RouteDel(&route); err != nil {
+				if e := (Error{"delete route", namespace, err}); !e.NotFound() {
+					return e
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func newRuleToPrefix(prefix *net.IPNet, tcpOnly bool, table int) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Dst   = prefix
+	rule.Table = table
+	if tcpOnly {
+		rule.IPProto = unix.IPPROTO_TCP
+	}
+	return rule
+}
+
+func newRuleMark(mark int, table int, family Family) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Mark   = mark
+	rule.Table  = table
+	rule.Family = int(family)
+	return rule
+}
+
+func deleteRule(namespace Namespace, rule *netlink.Rule, operation string) error {
+	return inNamespace(namespace, func() error {
+		if err := netlink.RuleDel(rule); err != nil {
+			if e := (Error{operation, namespace, err}); !e.NotFound() {
+				return e
+			}
+		}
+		return nil
+	})
+}
+
+func addRule(namespace Namespace, rule *netlink.Rule, operation string) error {
+	return inNamespace(namespace, func() error {
+		if err := netlink.RuleAdd(rule); err != nil {
+			if e := (Error{operation, namespace, err}); !e.Exists() {
+				return e
+			}
+		}
+		return nil
+	})
+}
+
+// Looks up the link by its name within the namespace and applies fn to it.
+func withLink(namespace Namespace, name string, operation string, fn func(link netlink.Link) error) error {
+	return inNamespace(namespace, func() error {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok {
+				err = unix.ENODEV
+			}
+			return Error{operation + " of " + name, namespace, err}
+		}
+		if err := fn(link); err != nil {
+			return Error{operation + " of " + name, namespace, err}
+		}
+		return nil
+	})
+}
+
+func defaultPrefix(family Family) *net.IPNet {
+	if family == IPv6 {
+		return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8 * net.IPv6len)}
+	}
+	return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 8 * net.IPv4len)}
+}
@@ -2,8 +2,15 @@
 package network
 
 import (
-	"os/exec"
-	"shila/core/shila"
+	"fmt"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
 )
 
 type Namespace struct {
@@ -20,45 +27,178 @@ func NewNamespace(name string) Namespace {
 
 type Subnet string
 
-// ip netns add <namespace name>
-func AddNamespace(namespace Namespace) error {
-	if err := execIpCmd("netns", "add", namespace.Name); err != nil {
-		return err
+func (subnet Subnet) IsIPv6() bool {
+	return strings.Contains(string(subnet), ":")
+}
+
+// IPNet returns the address and prefix of the subnet, a plain address is taken as host prefix.
+func (subnet Subnet) IPNet() (*net.IPNet, error) {
+	if strings.Contains(string(subnet), "/") {
+		ip, ipNet, err := net.ParseCIDR(string(subnet))
+		if err != nil {
+			return nil, err
+		}
+		return &net.IPNet{IP: ip, Mask: ipNet.Mask}, nil
 	}
-	return nil
+	ip := net.ParseIP(string(subnet))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %s", subnet)
+	}
+	return HostPrefix(ip), nil
 }
 
-// ip netns delete <namespace name>
-func DeleteNamespace(namespace Namespace) error {
-	if err := execIpCmd("netns", "delete", namespace.Name); err != nil {
-		return err
+type Family int
+
+const (
+	IPv4 Family = unix.AF_INET
+	IPv6 Family = unix.AF_INET6
+)
+
+func FamilyOf(ip net.IP) Family {
+	if ip.To4() == nil {
+		return IPv6
 	}
-	return nil
+	return IPv4
 }
 
-func execIpCmd(args ...string) error {
+// Error is returned by every failed operation, Err holds the cause (usually the errno of the kernel).
+type Error struct {
+	Operation string
+	Namespace Namespace
+	Err       error
+}
 
-	_, err := exec.Command("ip", args...).Output()
+func (e Error) Error() string {
+	if e.Namespace.NonEmpty {
+		return fmt.Sprint("Unable to ", e.Operation, " in namespace ", e.Namespace.Name, ". ", e.Err)
+	}
+	return fmt.Sprint("Unable to ", e.Operation, ". ", e.Err)
+}
+
+// Exists is true if the operation failed because its result is already present.
+func (e Error) Exists() bool {
+	return e.Err == syscall.EEXIST || os.IsExist(e.Err)
+}
+
+// NotFound is true if the operation failed because its subject is not present (anymore).
+func (e Error) NotFound() bool {
+	return e.Err == syscall.ENOENT || e.Err == syscall.ESRCH || e.Err == syscall.ENODEV || os.IsNotExist(e.Err)
+}
+
+// AddNamespace creates a named namespace, an already existing namespace is reused.
+func AddNamespace(namespace Namespace) error {
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return Error{"get current namespace", Namespace{}, err}
+	}
+	defer origin.Close()
+
+	// Creating a namespace moves the calling thread into it, move back right away.
+	handle, err := netns.NewNamed(namespace.Name)
+	if errRestore := netns.Set(origin); errRestore != nil {
+		// The thread is stuck in the wrong namespace, never hand it back to the runtime.
+		runtime.LockOSThread()
+		return Error{"return to original namespace", namespace, errRestore}
+	}
 	if err != nil {
-		// From the official documentation: "Any returned error will *usually be of type *ExitError."
-		if exitError, ok := err.(*exec.ExitError); ok {
-			return shila.CriticalError(exitError.Stderr)
-		} else {
-			return shila.CriticalError(err.Error())
+		if e := (Error{"add namespace", namespace, err}); !e.Exists() {
+			return e
 		}
+		return nil
 	}
+	return handle.Close()
+}
 
+// DeleteNamespace removes a named namespace, a missing namespace is not an error.
+func DeleteNamespace(namespace Namespace) error {
+	if err := netns.DeleteNamed(namespace.Name); err != nil {
+		if e := (Error{"delete namespace", namespace, err}); !e.NotFound() {
+			return e
+		}
+	}
 	return nil
 }
 
-func execIpCmdInNamespace(namespace Namespace, args ...string) error {
-	return execIpCmd(append([]string{"netns", "exec", namespace.Name, "ip"}, args...)...)
+// NamespaceExists is true if the namespace is present (or if there is no namespace at all).
+func NamespaceExists(namespace Namespace) bool {
+	if !namespace.NonEmpty {
+		return true
+	}
+	handle, err := netns.GetFromName(namespace.Name)
+	if err != nil {
+		return false
+	}
+	_ = handle.Close()
+	return true
+}
+
+// SetSysctl writes the value of the kernel parameter (e.g. "net/ipv4/conf/tun1/rp_filter") within the namespace.
+func SetSysctl(namespace Namespace, key string, value string) error {
+	return inNamespace(namespace, func() error {
+		if err := ioutil.WriteFile("/proc/sys/" + key, []byte(value), 0644); err != nil {
+			return Error{"set " + key, namespace, err}
+		}
+		return nil
+	})
 }
 
-func Execute(namespace Namespace, args ...string) error {
-	if namespace.NonEmpty {
-		return execIpCmdInNamespace(namespace, args...)
-	} else {
-		return execIpCmd(args...)
+// GetSysctl reads the value of the kernel parameter within the namespace.
+func GetSysctl(namespace Namespace, key string) (string, error) {
+	var value string
+	err := inNamespace(namespace, func() error {
+		data, err := ioutil.ReadFile("/proc/sys/" + key)
+		if err != nil {
+			return Error{"get " + key, namespace, err}
+		}
+		value = strings.TrimSpace(string(data))
+		return nil
+	})
+	return value, err
+}
+
+// Runs fn with the calling thread inside the given namespace. All netlink requests issued by fn
+// go to this namespace. Without a namespace, fn is run in the namespace of shila itself.
+func inNamespace(namespace Namespace, fn func() error) error {
+
+	if !namespace.NonEmpty {
+		return fn()
 	}
-}
\ No newline at end of file
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return Error{"get current namespace", Namespace{}, err}
+	}
+	defer origin.Close()
+
+	target, err := netns.GetFromName(namespace.Name)
+	if err != nil {
+		return Error{"open namespace", namespace, err}
+	}
+	defer target.Close()
+
+	if err := netns.Set(target); err != nil {
+		return Error{"enter namespace", namespace, err}
+	}
+	errFn := fn()
+	if err := netns.Set(origin); err != nil {
+		// The thread is stuck in the wrong namespace, never hand it back to the runtime.
+		runtime.LockOSThread()
+		return Error{"return to original namespace", namespace, err}
+	}
+	return errFn
+}
+
+// HostPrefix returns the prefix covering just the address.
+func HostPrefix(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8 * net.IPv4len, 8 * net.IPv4len)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8 * net.IPv6len, 8 * net.IPv6len)}
+}
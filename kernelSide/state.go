@@ -0,0 +1,377 @@
+//
+package kernelSide
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"shila/config"
+	"shila/core/shila"
+	"shila/kernelSide/kernelEndpoint"
+	"shila/kernelSide/network"
+	"shila/log"
+)
+
+// The kernel side records everything it creates in a small state file, before creating it. If shila
+// dies without cleaning up, the next start (or a run w/ --cleanup-only) removes these leftovers.
+// After a regular clean up, the state file is removed.
+type State struct {
+	Namespaces      []network.Namespace		// Namespaces created.
+	Multipath       []StateLink				// Links for which multipath was disabled, it was enabled before.
+	RulesTo         []StateRule				// Rules routing traffic to an address (or prefix) through a table.
+	MarkRules       []StateMarkRule			// Rules routing traffic with a firewall mark through a table.
+	ConnectionMarks []StateConnectionMark	// Firewall rules marking the connections received through a device.
+	Devices         []StateDevice			// Virtual interfaces, together with their routing table.
+	Sysctls         []StateSysctl			// Kernel parameters changed, together with their original value.
+	MPTCPEndpoints  []StateMPTCPEndpoint	// Endpoints of the upstream MPTCP.
+	MPTCPLimits     []StateMPTCPLimits		// Limits of the upstream MPTCP changed, together with their original value.
+	path            string
+}
+
+type StateLink struct {
+	Namespace network.Namespace
+	Name      string
+}
+
+type StateRule struct {
+	Namespace network.Namespace
+	Prefix    string
+	TCPOnly   bool
+	Table     int
+}
+
+type StateMarkRule struct {
+	Namespace network.Namespace
+	Mark      int
+	Table     int
+	Family    network.Family
+}
+
+type StateConnectionMark struct {
+	Device   string
+	Mark     int
+	Families []network.Family	// Families whose firewall covers the device. (Both if missing.)
+}
+
+type StateDevice struct {
+	Namespace network.Namespace
+	Name      string
+	Table     int
+}
+
+type StateSysctl struct {
+	Namespace network.Namespace
+	Key       string
+	Value     string
+}
+
+type StateMPTCPEndpoint struct {
+	Namespace network.Namespace
+	ID        uint8
+}
+
+type StateMPTCPLimits struct {
+	Namespace network.Namespace
+	Limits    network.MPTCPLimits
+}
+
+func newState(path string) *State {
+	return &State{path: path}
+}
+
+func loadState(path string) (*State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := newState(path)
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, shila.PrependError(err, fmt.Sprint("Unable to parse state file ", path, "."))
+	}
+	return state, nil
+}
+
+func (state *State) addNamespace(namespace network.Namespace) error {
+	state.Namespaces = append(state.Namespaces, namespace)
+	return state.save()
+}
+
+func (state *State) addMultipath(namespace network.Namespace, name string) error {
+	state.Multipath = append(state.Multipath, StateLink{Namespace: namespace, Name: name})
+	return state.save()
+}
+
+func (state *State) addRuleTo(namespace network.Namespace, prefix *net.IPNet, tcpOnly bool, table int) error {
+	state.RulesTo = append(state.RulesTo, StateRule{Namespace: namespace, Prefix: prefix.String(), TCPOnly: tcpOnly, Table: table})
+	return state.save()
+}
+
+func (state *State) addMarkRule(namespace network.Namespace, mark int, table int, family network.Family) error {
+	state.MarkRules = append(state.MarkRules, StateMarkRule{Namespace: namespace, Mark: mark, Table: table, Family: family})
+	return state.save()
+}
+
+func (state *State) addConnectionMark(device string, mark int, families []network.Family) error {
+	state.ConnectionMarks = append(state.ConnectionMarks, StateConnectionMark{Device: device, Mark: mark, Families: families})
+	return state.save()
+}
+
+func (state *State) addDevice(namespace network.Namespace, name string, table int) error {
+	state.Devices = append(state.Devices, StateDevice{Namespace: namespace, Name: name, Table: table})
+	return state.save()
+}
+
+func (state *State) addSysctl(namespace network.Namespace, key string, original string) error {
+	state.Sysctls = append(state.Sysctls, StateSysctl{Namespace: namespace, Key: key, Value: original})
+	return state.save()
+}
+
+func (state *State) addMPTCPEndpoint(namespace network.Namespace, id uint8) error {
+	state.MPTCPEndpoints = append(state.MPTCPEndpoints, StateMPTCPEndpoint{Namespace: namespace, ID: id})
+	return state.save()
+}
+
+func (state *State) addMPTCPLimits(namespace network.Namespace, original network.MPTCPLimits) error {
+	state.MPTCPLimits = append(state.MPTCPLimits, StateMPTCPLimits{Namespace: namespace, Limits: original})
+	return state.save()
+}
+
+func (state *State) removeDevice(namespace network.Namespace, name string) error {
+	for i, device := range state.Devices {
+		if device.Namespace == namespace && device.Name == name {
+			state.Devices = append(state.Devices[:i], state.Devices[i+1:]...)
+			break
+		}
+	}
+	return state.save()
+}
+
+func (state *State) save() error {
+	if state.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(state.path), 0700); err != nil {
+		return shila.PrependError(err, "Unable to create directory of state file.")
+	}
+	// Write to a temporary file first, a crash while writing must not destroy the old state.
+	tmp := state.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return shila.PrependError(err, "Unable to write state file.")
+	}
+	if err := os.Rename(tmp, state.path); err != nil {
+		return shila.PrependError(err, "Unable to write state file.")
+	}
+	return nil
+}
+
+func (state *State) remove() error {
+	if state.path == "" {
+		return nil
+	}
+	if err := os.Remove(state.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// reclaim removes everything listed in the state. Missing entries are skipped, all entries are
+// tried even if some fail. Returns the last error encountered.
+func (state *State) reclaim() error {
+
+	var err error
+	report := func(errStep error) {
+		if e, ok := errStep.(network.Error); ok && e.NotFound() {
+			return
+		}
+		if errStep != nil {
+			log.Error.Print("Unable to reclaim leftover. ", errStep.Error())
+			err = errStep
+		}
+	}
+
+	for _, mark := range state.ConnectionMarks {
+		families := mark.Families
+		if len(families) == 0 {
+			families = []network.Family{network.IPv4, network.IPv6}
+		}
+		report(network.DeleteConnectionMark(mark.Device, mark.Mark, families))
+	}
+	for _, rule := range state.MarkRules {
+		if !network.NamespaceExists(rule.Namespace) {
+			continue
+		}
+		report(network.DeleteRuleMark(rule.Namespace, rule.Mark, rule.Table, rule.Family))
+	}
+	for _, rule := range state.RulesTo {
+		if !network.NamespaceExists(rule.Namespace) {
+			continue
+		}
+		if _, prefix, err := net.ParseCIDR(rule.Prefix); err == nil {
+			report(network.DeleteRuleToPrefix(rule.Namespace, prefix, rule.TCPOnly, rule.Table))
+		}
+	}
+	for _, endpoint := range state.MPTCPEndpoints {
+		if !network.NamespaceExists(endpoint.Namespace) {
+			continue
+		}
+		report(network.DeleteMPTCPEndpoint(endpoint.Namespace, endpoint.ID))
+	}
+	for _, limits := range state.MPTCPLimits {
+		if !network.NamespaceExists(limits.Namespace) {
+			continue
+		}
+		report(network.SetMPTCPLimits(limits.Namespace, limits.Limits))
+	}
+	for _, device := range state.Devices {
+		if !network.NamespaceExists(device.Namespace) {
+			continue
+		}
+		report(network.DeleteRules(device.Namespace, device.Table, network.IPv4))
+		report(network.DeleteRules(device.Namespace, device.Table, network.IPv6))
+		report(network.FlushRoutes(device.Namespace, device.Table, network.IPv4))
+		report(network.FlushRoutes(device.Namespace, device.Table, network.IPv6))
+		report(network.DeleteLink(device.Namespace, device.Name))
+	}
+	for _, link := range state.Multipath {
+		if !network.NamespaceExists(link.Namespace) {
+			continue
+		}
+		report(network.SetMultipath(link.Namespace, link.Name, true))
+	}
+	for i := len(state.Sysctls) - 1; i >= 0; i-- {
+		s := state.Sysctls[i]
+		if !network.NamespaceExists(s.Namespace) {
+			continue
+		}
+		report(network.SetSysctl(s.Namespace, sysctlPath(s.Key), s.Value))
+	}
+	for _, namespace := range state.Namespaces {
+		report(network.DeleteNamespace(namespace))
+	}
+
+	return err
+}
+
+// ReclaimLeftovers removes what a previous run of shila left behind, as recorded in its state file.
+// Without a state file, just the leftovers which are certainly shila's are removed: the namespaces of
+// shila, the virtual interfaces carrying its addresses and the rules looking up the tables of shila.
+// (The original values of kernel parameters are recorded in the state file only.)
+func ReclaimLeftovers() error {
+
+	path := config.Config.KernelSide.StateFilePath
+
+	var state *State
+	var err error
+	if path != "" {
+		state, err = loadState(path)
+	}
+	if path == "" || os.IsNotExist(err) {
+		log.Verbose.Print("No state file, reclaiming the leftovers derived from the configuration.")
+		derived, err := derivedState()
+		if err != nil {
+			return shila.PrependError(err, "Unable to derive the leftovers.")
+		}
+		if err := derived.reclaim(); err != nil {
+			return shila.PrependError(err, "Unable to reclaim all leftovers.")
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Info.Print("Reclaiming leftovers of a previous run recorded in ", path, ".")
+	if err := state.reclaim(); err != nil {
+		return shila.PrependError(err, "Unable to reclaim all leftovers.")
+	}
+	return state.remove()
+}
+
+// Returns the state a run w/ the current configuration leaves behind at most. Namespaces
+// which do not exist are left out, their removal would create them in the first place.
+func derivedState() (*State, error) {
+
+	state := newState("")
+	if !config.Config.KernelSide.TransparentInterception {
+		for _, name := range []string{config.Config.KernelSide.IngressNamespace, config.Config.KernelSide.EgressNamespace} {
+			namespace := network.NewNamespace(name)
+			if namespace.NonEmpty && network.NamespaceExists(namespace) {
+				state.Namespaces = append(state.Namespaces, namespace)
+			}
+		}
+		// The devices, their tables and rules are all within the namespaces.
+		return state, nil
+	}
+
+	// The rules are removed just if they look up the tables of shila.
+	mark := config.Config.KernelSide.InterceptionMark
+	for _, prefixString := range config.Config.KernelSide.InterceptedPrefixes {
+		state.RulesTo = append(state.RulesTo, StateRule{Prefix: prefixString, TCPOnly: true, Table: kernelEndpoint.Table(numberOfFirstEgressInterface)})
+	}
+	families := []network.Family{network.IPv4}
+	if config.Config.KernelSide.EnableIPv6 {
+		families = append(families, network.IPv6)
+	}
+	for _, family := range families {
+		state.MarkRules = append(state.MarkRules, StateMarkRule{Mark: mark, Table: kernelEndpoint.Table(numberOfIngressInterface), Family: family})
+	}
+	ingress := kernelEndpoint.DeviceName(numberOfIngressInterface)
+	state.ConnectionMarks = append(state.ConnectionMarks, StateConnectionMark{Device: ingress, Mark: mark, Families: families})
+
+	// The devices (and their tables) are removed just if they carry an address of shila, a device w/
+	// the same name might belong to someone else.
+	names, err := network.LinkNames(network.Namespace{})
+	if err != nil {
+		return nil, err
+	}
+	for number := numberOfIngressInterface; number <= math.MaxUint8; number++ {
+		name := kernelEndpoint.DeviceName(uint8(number))
+		if !containsName(names, name) {
+			continue
+		}
+		ips, err := network.LinkAddresses(network.Namespace{}, name)
+		if err != nil {
+			return nil, err
+		}
+		if carriesAddressOfShila(uint8(number), ips) {
+			state.Devices = append(state.Devices, StateDevice{Name: name, Table: kernelEndpoint.Table(uint8(number))})
+		}
+	}
+	return state, nil
+}
+
+// Is true if one of the addresses is the one shila assigns to the device w/ the given number (or,
+// for an egress device, is taken from the subnet of the egress addresses).
+func carriesAddressOfShila(number uint8, ips []net.IP) bool {
+	for _, ip := range ips {
+		if number == numberOfIngressInterface {
+			if ip.Equal(net.ParseIP(config.Config.KernelSide.IngressIP)) ||
+				(config.Config.KernelSide.EnableIPv6 && ip.Equal(net.ParseIP(config.Config.KernelSide.IngressIPv6))) {
+				return true
+			}
+			continue
+		}
+		for _, subnet := range []string{config.Config.KernelSide.EgressSubnet, config.Config.KernelSide.EgressSubnetIPv6} {
+			if _, ipNet, err := net.ParseCIDR(subnet); err == nil && ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
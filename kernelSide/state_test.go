@@ -0,0 +1,32 @@
+//
+package kernelSide
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDeviceOfShilaIsRecognizedByAddress(t *testing.T) {
+
+	cases := []struct {
+		number uint8
+		ip     string
+		want   bool
+	}{
+		{numberOfIngressInterface, "10.7.0.9", true},
+		{numberOfIngressInterface, "10.7.1.1", false},
+		{numberOfFirstEgressInterface, "10.7.1.1", true},
+		{numberOfFirstEgressInterface + 3, "10.7.1.42", true},
+		{numberOfFirstEgressInterface, "10.8.0.1", false},	// E.g. the tunnel of a VPN.
+		{numberOfFirstEgressInterface, "10.7.0.9", false},
+	}
+
+	for _, c := range cases {
+		if got := carriesAddressOfShila(c.number, []net.IP{net.ParseIP(c.ip)}); got != c.want {
+			t.Error("Device ", c.number, " w/ ", c.ip, ": got ", got, ", want ", c.want, ".")
+		}
+	}
+	if carriesAddressOfShila(numberOfIngressInterface, nil) {
+		t.Error("Device w/o address recognized.")
+	}
+}
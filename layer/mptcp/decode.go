@@ -0,0 +1,358 @@
+//
+package mptcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"net"
+	"shila/layer"
+)
+
+// DecodeOptions returns the MPTCP options of the TCP segment, in the order they appear.
+func DecodeOptions(tcp layers.TCP) ([]Option, error) {
+	return decodeMPTCPOptions(tcp)
+}
+
+// DecodeOption decodes a single TCP option of kind MPTCP. Unknown subtypes are returned as RawOption.
+func DecodeOption(option layers.TCPOption) (Option, error) {
+
+	if option.OptionType != layers.TCPOptionKind(TCPOptionKindMPTCP) {
+		return nil, layer.ParsingError(fmt.Sprint("Option of kind ", option.OptionType, " is no MPTCP option."))
+	}
+
+	length := option.OptionLength
+	data := option.OptionData
+	if len(data) == 0 || int(length) != len(data) + 2 {
+		return nil, layer.ParsingError(fmt.Sprint("Invalid length ", length, " of MPTCP option."))
+	}
+
+	subtype := OptionSubtype(data[0] >> 4)
+	optBase := OptionBase{OptionLength: length, OptionSubtype: subtype}
+
+	switch subtype {
+	case MultipathCapable:		return decodeCapable(optBase, data)
+	case JoinConnection:		return decodeJoin(optBase, data)
+	case DataSequenceSignal:	return decodeDataSequenceSignal(optBase, data)
+	case AddAddress:			return decodeAddAddress(optBase, data)
+	case RemoveAddress:			return decodeRemoveAddress(optBase, data)
+	case ChangeSubflowPriority:	return decodeChangeSubflowPriority(optBase, data)
+	case Fallback:				return decodeFallback(optBase, data)
+	case FastClose:				return decodeFastClose(optBase, data)
+	case Reset:					return decodeReset(optBase, data)
+	}
+
+	return RawOption{OptionBase: optBase, OptionData: data}, nil
+}
+
+func decodeMPTCPOptions(tcp layers.TCP) (options []Option, err error) {
+
+	options = []Option{}
+
+	// Loop over all options, find the MPTCP options and decode them further.
+	for _, option := range tcp.Options {
+		if option.OptionType == layers.TCPOptionKind(TCPOptionKindMPTCP) {
+			var opt Option
+			if opt, err = DecodeOption(option); err != nil {
+				return
+			}
+			options = append(options, opt)
+		}
+	}
+
+	return
+}
+
+// Like decodeMPTCPOptions, but just the options of the given subtype are decoded.
+func decodeMPTCPOptionsOf(tcp layers.TCP, subtype OptionSubtype) (options []Option, err error) {
+
+	options = []Option{}
+
+	for _, option := range tcp.Options {
+		if option.OptionType != layers.TCPOptionKind(TCPOptionKindMPTCP) ||
+			len(option.OptionData) == 0 || OptionSubtype(option.OptionData[0] >> 4) != subtype {
+			continue
+		}
+		var opt Option
+		if opt, err = DecodeOption(option); err != nil {
+			return
+		}
+		options = append(options, opt)
+	}
+
+	return
+}
+
+func decodeCapable(optBase OptionBase, data []byte) (Option, error) {
+
+	length := optBase.OptionLength
+	version := Version(data[0] & 0xf)
+
+	if !validCapableLength(version, length) {
+		return nil, invalidLength(optBase, fmt.Sprint(" (v", version, ")"))
+	}
+
+	A := data[1]&0x80 != 0
+	B := data[1]&0x40 != 0
+	C := data[1]&0x20 != 0
+	D := data[1]&0x10 != 0
+	E := data[1]&0x8 != 0
+	F := data[1]&0x4 != 0
+	G := data[1]&0x2 != 0
+	H := data[1]&0x1 != 0
+
+	capable := CapableOption{
+		OptionBase: optBase,
+		Version: 	version,
+		A: A, B: B, C: C, D: D,
+		E: E, F: F, G: G, H: H,
+	}
+
+	if length == 4 {
+		return capable, nil
+	}
+
+	sender := CapableOptionSender{
+		CapableOption: 	capable,
+		SenderKey: 		binary.BigEndian.Uint64(data[2:10]),
+	}
+	if length == 12 {
+		return sender, nil
+	}
+
+	senderReceiver := CapableOptionSenderReceiver{
+		CapableOptionSender: sender,
+		ReceiverKey:		 binary.BigEndian.Uint64(data[10:18]),
+	}
+	if length == 20 {
+		return senderReceiver, nil
+	}
+
+	withData := CapableOptionSenderReceiverData{
+		CapableOptionSenderReceiver: 	senderReceiver,
+		DataLevelLength: 				binary.BigEndian.Uint16(data[18:20]),
+	}
+	if length == 24 {
+		withData.Checksum = binary.BigEndian.Uint16(data[20:22])
+	}
+	return withData, nil
+}
+
+// MP_CAPABLE carries no key in the SYN of v1, v1 appends the data level length (and the checksum) if sent with data.
+func validCapableLength(version Version, length uint8) bool {
+	switch version {
+	case Version0:
+		return length == 12 || length == 20
+	case Version1:
+		return length == 4 || length == 12 || length == 20 || length == 22 || length == 24
+	}
+	return false
+}
+
+func decodeJoin(optBase OptionBase, data []byte) (Option, error) {
+
+	switch optBase.OptionLength {
+
+	case 12:
+		return JoinOptionSYN{
+			OptionBase: 		optBase,
+			B: 					data[0]&0x1 != 0,
+			AddressID: 			data[1],
+			ReceiverToken: 		binary.BigEndian.Uint32(data[2:6]),
+			SenderRandomNumber: binary.BigEndian.Uint32(data[6:10]),
+		}, nil
+
+	case 16:
+		return JoinOptionSYNACK{
+			OptionBase: 		optBase,
+			B: 					data[0]&0x1 != 0,
+			AddressID: 			data[1],
+			SenderTruncHMAC: 	binary.BigEndian.Uint64(data[2:10]),
+			SenderRandomNumber: binary.BigEndian.Uint32(data[10:14]),
+		}, nil
+
+	case 24:
+		return JoinOptionThirdACK{OptionBase: optBase, SenderHMAC: data[2:22]}, nil
+	}
+
+	return nil, invalidLength(optBase, "")
+}
+
+func decodeDataSequenceSignal(optBase OptionBase, data []byte) (Option, error) {
+
+	if optBase.OptionLength < 4 {
+		return nil, invalidLength(optBase, "")
+	}
+
+	opt := DataSequenceSignalOption{
+		OptionBase: 		optBase,
+		DataFIN: 			data[1]&0x10 != 0,
+		DataSequenceLong: 	data[1]&0x08 != 0,
+		MappingPresent: 	data[1]&0x04 != 0,
+		DataACKLong: 		data[1]&0x02 != 0,
+		DataACKPresent: 	data[1]&0x01 != 0,
+	}
+
+	// The checksum is present if there is room left for it.
+	length := dataSequenceSignalLength(opt)
+	if int(optBase.OptionLength) == length + 2 && opt.MappingPresent {
+		opt.ChecksumPresent = true
+	} else if int(optBase.OptionLength) != length {
+		return nil, invalidLength(optBase, "")
+	}
+
+	offset := 2
+	if opt.DataACKPresent {
+		if opt.DataACKLong {
+			opt.DataACK = binary.BigEndian.Uint64(data[offset:offset + 8]); offset += 8
+		} else {
+			opt.DataACK = uint64(binary.BigEndian.Uint32(data[offset:offset + 4])); offset += 4
+		}
+	}
+	if opt.MappingPresent {
+		if opt.DataSequenceLong {
+			opt.DataSequenceNumber = binary.BigEndian.Uint64(data[offset:offset + 8]); offset += 8
+		} else {
+			opt.DataSequenceNumber = uint64(binary.BigEndian.Uint32(data[offset:offset + 4])); offset += 4
+		}
+		opt.SubflowSequenceNumber = binary.BigEndian.Uint32(data[offset:offset + 4]); offset += 4
+		opt.DataLevelLength = binary.BigEndian.Uint16(data[offset:offset + 2]); offset += 2
+		if opt.ChecksumPresent {
+			opt.Checksum = binary.BigEndian.Uint16(data[offset:offset + 2])
+		}
+	}
+
+	return opt, nil
+}
+
+// Length of the DSS option w/o checksum, as indicated by its flags.
+func dataSequenceSignalLength(opt DataSequenceSignalOption) int {
+	length := 4
+	if opt.DataACKPresent {
+		if opt.DataACKLong {
+			length += 8
+		} else {
+			length += 4
+		}
+	}
+	if opt.MappingPresent {
+		if opt.DataSequenceLong {
+			length += 8
+		} else {
+			length += 4
+		}
+		length += 4 + 2
+	}
+	return length
+}
+
+func decodeAddAddress(optBase OptionBase, data []byte) (Option, error) {
+
+	if optBase.OptionLength < 8 {
+		return nil, invalidLength(optBase, "")
+	}
+
+	opt := AddAddressOption{OptionBase: optBase, AddressID: data[1]}
+
+	// In v0, the lower nibble holds the ip version. In v1, it holds the echo flag, the other bits are zero.
+	var addrLen int
+	var hasHMAC bool
+	switch nibble := data[0] & 0xf; nibble {
+	case 4, 6:
+		opt.Version = Version0
+		addrLen = net.IPv4len
+		if nibble == 6 {
+			addrLen = net.IPv6len
+		}
+	case 0, 1:
+		opt.Version = Version1
+		opt.Echo = nibble == 1
+		hasHMAC = !opt.Echo
+		switch optBase.OptionLength {
+		case 8, 10, 16, 18: addrLen = net.IPv4len
+		case 20, 22, 28, 30: addrLen = net.IPv6len
+		default:
+			return nil, invalidLength(optBase, "")
+		}
+	default:
+		return nil, layer.ParsingError(fmt.Sprint("Invalid flags ", nibble, " for ", AddAddress, "."))
+	}
+
+	length := 4 + addrLen
+	if hasHMAC {
+		length += 8
+	}
+	hasPort := int(optBase.OptionLength) == length + 2
+	if hasPort {
+		length += 2
+	}
+	if int(optBase.OptionLength) != length {
+		return nil, invalidLength(optBase, "")
+	}
+
+	offset := 2
+	opt.Address = make(net.IP, addrLen)
+	copy(opt.Address, data[offset:offset + addrLen]); offset += addrLen
+	if hasPort {
+		opt.Port = binary.BigEndian.Uint16(data[offset:offset + 2]); offset += 2
+	}
+	if hasHMAC {
+		opt.HMAC = binary.BigEndian.Uint64(data[offset:offset + 8])
+	}
+
+	return opt, nil
+}
+
+func decodeRemoveAddress(optBase OptionBase, data []byte) (Option, error) {
+	if optBase.OptionLength < 4 {
+		return nil, invalidLength(optBase, "")
+	}
+	ids := make([]uint8, len(data) - 1)
+	copy(ids, data[1:])
+	return RemoveAddressOption{OptionBase: optBase, AddressIDs: ids}, nil
+}
+
+func decodeChangeSubflowPriority(optBase OptionBase, data []byte) (Option, error) {
+	opt := ChangeSubflowPriorityOption{OptionBase: optBase, Backup: data[0]&0x1 != 0}
+	switch optBase.OptionLength {
+	case 3:
+	case 4:
+		opt.AddressIDPresent = true
+		opt.AddressID = data[1]
+	default:
+		return nil, invalidLength(optBase, "")
+	}
+	return opt, nil
+}
+
+func decodeFallback(optBase OptionBase, data []byte) (Option, error) {
+	if optBase.OptionLength != 12 {
+		return nil, invalidLength(optBase, "")
+	}
+	return FallbackOption{OptionBase: optBase, DataSequenceNumber: binary.BigEndian.Uint64(data[2:10])}, nil
+}
+
+func decodeFastClose(optBase OptionBase, data []byte) (Option, error) {
+	if optBase.OptionLength != 12 {
+		return nil, invalidLength(optBase, "")
+	}
+	return FastCloseOption{OptionBase: optBase, ReceiverKey: binary.BigEndian.Uint64(data[2:10])}, nil
+}
+
+func decodeReset(optBase OptionBase, data []byte) (Option, error) {
+	if optBase.OptionLength != 4 {
+		return nil, invalidLength(optBase, "")
+	}
+	return ResetOption{
+		OptionBase: optBase,
+		U:          data[0]&0x8 != 0,
+		V:          data[0]&0x4 != 0,
+		W:          data[0]&0x2 != 0,
+		T:          data[0]&0x1 != 0,
+		Reason:     data[1],
+	}, nil
+}
+
+func invalidLength(optBase OptionBase, detail string) error {
+	return layer.ParsingError(fmt.Sprint("Invalid length ", optBase.OptionLength, " for ", optBase.OptionSubtype, detail, "."))
+}
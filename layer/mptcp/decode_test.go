@@ -0,0 +1,207 @@
+//
+package mptcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/google/gopacket/layers"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// The options w/o remark were captured on loopback between two sockets of the upstream kernel (Linux 6.18,
+// MPTCP v1, w/ and w/o net.mptcp.checksum_enabled). The kernel sends neither v0 options nor MP_FAIL (just
+// on a checksum failure) nor short data sequence numbers, these options are built after the RFCs.
+var roundTrips = []struct {
+	name string
+	raw  string
+	want Option
+}{
+	// MP_CAPABLE
+	{"capable v1 SYN", "1e040101",
+		CapableOption{OptionBase: base(4, MultipathCapable), Version: Version1, H: true}},
+	{"capable v1 SYN/ACK", "1e0c01018bba9c52065ffa81",
+		capableSender(12, Version1, false, 0x8bba9c52065ffa81)},
+	{"capable v1 ACK", "1e140101c84509a25196606e8bba9c52065ffa81",
+		CapableOptionSenderReceiver{
+			CapableOptionSender: capableSender(20, Version1, false, 0xc84509a25196606e),
+			ReceiverKey:         0x8bba9c52065ffa81,
+		}},
+	{"capable v1 data", "1e16010146e93ea0a7dc1812b3761c0eee4fbaea000a",
+		CapableOptionSenderReceiverData{
+			CapableOptionSenderReceiver: CapableOptionSenderReceiver{
+				CapableOptionSender: capableSender(22, Version1, false, 0x46e93ea0a7dc1812),
+				ReceiverKey:         0xb3761c0eee4fbaea,
+			},
+			DataLevelLength: 10,
+		}},
+	{"capable v1 data w/ checksum", "1e1801816834623bf97123afc7930076526e5b0e000ad227",
+		CapableOptionSenderReceiverData{
+			CapableOptionSenderReceiver: CapableOptionSenderReceiver{
+				CapableOptionSender: capableSender(24, Version1, true, 0x6834623bf97123af),
+				ReceiverKey:         0xc7930076526e5b0e,
+			},
+			DataLevelLength: 10,
+			Checksum:        0xd227,
+		}},
+	// RFC 6824, section 3.1
+	{"capable v0 SYN", "1e0c00810123456789abcdef",
+		capableSender(12, Version0, true, 0x0123456789abcdef)},
+	{"capable v0 ACK", "1e1400810123456789abcdeffedcba9876543210",
+		CapableOptionSenderReceiver{
+			CapableOptionSender: capableSender(20, Version0, true, 0x0123456789abcdef),
+			ReceiverKey:         0xfedcba9876543210,
+		}},
+
+	// MP_JOIN
+	{"join SYN", "1e0c10061e79a6eef152f626",
+		JoinOptionSYN{OptionBase: base(12, JoinConnection), AddressID: 6, ReceiverToken: 0x1e79a6ee, SenderRandomNumber: 0xf152f626}},
+	{"join SYN/ACK", "1e101003b04ad3dd9a6a5522efdeb587",
+		JoinOptionSYNACK{OptionBase: base(16, JoinConnection), AddressID: 3, SenderTruncHMAC: 0xb04ad3dd9a6a5522, SenderRandomNumber: 0xefdeb587}},
+	{"join third ACK", "1e1810001b8045ceac9165f09ade314ead255c3d05526e3f",
+		JoinOptionThirdACK{OptionBase: base(24, JoinConnection), SenderHMAC: decodeHex("1b8045ceac9165f09ade314ead255c3d05526e3f")}},
+
+	// DSS
+	{"dss short data ACK", "1e0820012080335d",
+		DataSequenceSignalOption{OptionBase: base(8, DataSequenceSignal), DataACKPresent: true, DataACK: 0x2080335d}},
+	{"dss long data ACK", "1e0c20032812b8db20803b2d",
+		DataSequenceSignalOption{OptionBase: base(12, DataSequenceSignal), DataACKPresent: true, DataACKLong: true, DataACK: 0x2812b8db20803b2d}},
+	{"dss long mapping", "1e16200d816ae8cb2812b8db2080335d0000000107d0",
+		DataSequenceSignalOption{OptionBase: base(22, DataSequenceSignal), DataACKPresent: true, DataACK: 0x816ae8cb,
+			MappingPresent: true, DataSequenceLong: true, DataSequenceNumber: 0x2812b8db2080335d, SubflowSequenceNumber: 1, DataLevelLength: 2000}},
+	{"dss long mapping w/ checksum", "1e18200d7778a8e9fa3fb5d3697009610000000107d03eb3",
+		DataSequenceSignalOption{OptionBase: base(24, DataSequenceSignal), DataACKPresent: true, DataACK: 0x7778a8e9,
+			MappingPresent: true, DataSequenceLong: true, DataSequenceNumber: 0xfa3fb5d369700961, SubflowSequenceNumber: 1, DataLevelLength: 2000,
+			ChecksumPresent: true, Checksum: 0x3eb3}},
+	{"dss data FIN", "1e1a201ff3312616481f5f54d2eae1b299f4cf8b000000000001",
+		DataSequenceSignalOption{OptionBase: base(26, DataSequenceSignal), DataFIN: true, DataACKPresent: true, DataACKLong: true, DataACK: 0xf3312616481f5f54,
+			MappingPresent: true, DataSequenceLong: true, DataSequenceNumber: 0xd2eae1b299f4cf8b, DataLevelLength: 1}},
+	{"dss data FIN w/ checksum", "1e1c201f0e13da61212c72a794de2fe10f60f971000000000001326d",
+		DataSequenceSignalOption{OptionBase: base(28, DataSequenceSignal), DataFIN: true, DataACKPresent: true, DataACKLong: true, DataACK: 0x0e13da61212c72a7,
+			MappingPresent: true, DataSequenceLong: true, DataSequenceNumber: 0x94de2fe10f60f971, DataLevelLength: 1,
+			ChecksumPresent: true, Checksum: 0x326d}},
+	// RFC 8684, section 3.3
+	{"dss short mapping", "1e12200500000064000003e8000000010010",
+		DataSequenceSignalOption{OptionBase: base(18, DataSequenceSignal), DataACKPresent: true, DataACK: 100,
+			MappingPresent: true, DataSequenceNumber: 1000, SubflowSequenceNumber: 1, DataLevelLength: 16}},
+
+	// ADD_ADDR
+	{"add address v1 echo", "1e0831020a000002",
+		addAddress(8, Version1, true, 2, "10.0.0.2", 0, 0)},
+	{"add address v1 echo w/ port", "1e0a31030a0000031389",
+		addAddress(10, Version1, true, 3, "10.0.0.3", 5001, 0)},
+	{"add address v1 w/ HMAC", "1e1030020a000002fc5e6e911ecf93a4",
+		addAddress(16, Version1, false, 2, "10.0.0.2", 0, 0xfc5e6e911ecf93a4)},
+	{"add address v1 w/ port and HMAC", "1e1230030a000003138917f50036b532c074",
+		addAddress(18, Version1, false, 3, "10.0.0.3", 5001, 0x17f50036b532c074)},
+	{"add address v1 IPv6 echo", "1e143105fd000000000000000000000000000002",
+		addAddress(20, Version1, true, 5, "fd00::2", 0, 0)},
+	{"add address v1 IPv6 w/ HMAC", "1e1c3005fd0000000000000000000000000000029e668fc8ffecf449",
+		addAddress(28, Version1, false, 5, "fd00::2", 0, 0x9e668fc8ffecf449)},
+	// RFC 6824, section 3.4.1
+	{"add address v0", "1e0834010a000002",
+		addAddress(8, Version0, false, 1, "10.0.0.2", 0, 0)},
+	{"add address v0 w/ port", "1e0a34010a0000021389",
+		addAddress(10, Version0, false, 1, "10.0.0.2", 5001, 0)},
+	{"add address v0 IPv6", "1e143601fd000000000000000000000000000002",
+		addAddress(20, Version0, false, 1, "fd00::2", 0, 0)},
+	{"add address v0 IPv6 w/ port", "1e163601fd0000000000000000000000000000021389",
+		addAddress(22, Version0, false, 1, "fd00::2", 5001, 0)},
+
+	// REMOVE_ADDR
+	{"remove address", "1e044002",
+		RemoveAddressOption{OptionBase: base(4, RemoveAddress), AddressIDs: []uint8{2}}},
+
+	// MP_PRIO
+	{"priority v1", "1e0351",
+		ChangeSubflowPriorityOption{OptionBase: base(3, ChangeSubflowPriority), Backup: true}},
+	// RFC 6824, section 3.3.8
+	{"priority v0", "1e045103",
+		ChangeSubflowPriorityOption{OptionBase: base(4, ChangeSubflowPriority), Backup: true, AddressIDPresent: true, AddressID: 3}},
+
+	// MP_FAIL, RFC 8684, section 3.7
+	{"fail", "1e0c60000000000000001000",
+		FallbackOption{OptionBase: base(12, Fallback), DataSequenceNumber: 0x1000}},
+
+	// MP_FASTCLOSE
+	{"fast close", "1e0c70008bba9c52065ffa81",
+		FastCloseOption{OptionBase: base(12, FastClose), ReceiverKey: 0x8bba9c52065ffa81}},
+
+	// MP_TCPRST
+	{"reset", "1e048000",
+		ResetOption{OptionBase: base(4, Reset)}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range roundTrips {
+		raw := decodeHex(c.raw)
+		option := tcpOption(raw)
+
+		decoded, err := DecodeOption(option)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, c.want) {
+			t.Errorf("%s: decoded %+v, want %+v", c.name, decoded, c.want)
+			continue
+		}
+
+		serialized, err := SerializeOption(decoded)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if serialized.OptionLength != option.OptionLength || !bytes.Equal(serialized.OptionData, option.OptionData) {
+			t.Errorf("%s: serialized %x%x, want %s", c.name, []byte{byte(serialized.OptionType), serialized.OptionLength},
+				serialized.OptionData, c.raw)
+		}
+	}
+}
+
+func TestInvalidLength(t *testing.T) {
+	for _, raw := range []string{
+		"1e040081",                   // MP_CAPABLE v0 w/o key
+		"1e0d10061e79a6eef152f62600", // MP_JOIN of 13 bytes
+		"1e0920012080335d00",         // DSS w/ a byte left
+		"1e0931020a00000200",         // ADD_ADDR v1 w/ a byte left
+		"1e0b600000000000000010",     // MP_FAIL of 11 bytes
+	} {
+		if option, err := DecodeOption(tcpOption(decodeHex(raw))); err == nil {
+			t.Errorf("%s: decoded invalid option %+v", raw, option)
+		}
+	}
+}
+
+func base(length uint8, subtype OptionSubtype) OptionBase {
+	return OptionBase{OptionLength: length, OptionSubtype: subtype}
+}
+
+func capableSender(length uint8, version Version, checksum bool, key uint64) CapableOptionSender {
+	return CapableOptionSender{
+		CapableOption: CapableOption{OptionBase: base(length, MultipathCapable), Version: version, A: checksum, H: true},
+		SenderKey:     key,
+	}
+}
+
+func addAddress(length uint8, version Version, echo bool, id uint8, address string, port uint16, hmac uint64) AddAddressOption {
+	ip := net.ParseIP(address)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return AddAddressOption{OptionBase: base(length, AddAddress), Version: version, Echo: echo, AddressID: id, Address: ip, Port: port, HMAC: hmac}
+}
+
+// Returns the TCP option as decoded by gopacket, the raw bytes include kind and length.
+func tcpOption(raw []byte) layers.TCPOption {
+	return layers.TCPOption{OptionType: layers.TCPOptionKind(raw[0]), OptionLength: raw[1], OptionData: raw[2:]}
+}
+
+func decodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
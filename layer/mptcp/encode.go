@@ -0,0 +1,238 @@
+//
+package mptcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"net"
+	"shila/layer"
+)
+
+// SerializeOption encodes the MPTCP option as TCP option, the counterpart of DecodeOption. The length
+// is derived from the content of the option, the one of its option base is ignored.
+func SerializeOption(option Option) (layers.TCPOption, error) {
+
+	data, err := serializeOptionData(option)
+	if err != nil {
+		return layers.TCPOption{}, err
+	}
+	if len(data) + 2 > 40 {
+		return layers.TCPOption{}, layer.ParsingError(fmt.Sprint("MPTCP option of ", len(data) + 2, " bytes exceeds the option space."))
+	}
+
+	return layers.TCPOption{
+		OptionType:   layers.TCPOptionKind(TCPOptionKindMPTCP),
+		OptionLength: uint8(len(data) + 2),
+		OptionData:   data,
+	}, nil
+}
+
+// Returns the option w/o kind and length.
+func serializeOptionData(option Option) ([]byte, error) {
+	switch opt := option.(type) {
+
+	case RawOption:
+		return opt.OptionData, nil
+
+	case CapableOption:
+		return serializeCapable(opt, 2), nil
+
+	case CapableOptionSender:
+		data := serializeCapable(opt.CapableOption, 10)
+		binary.BigEndian.PutUint64(data[2:10], opt.SenderKey)
+		return data, nil
+
+	case CapableOptionSenderReceiver:
+		data := serializeCapable(opt.CapableOption, 18)
+		binary.BigEndian.PutUint64(data[2:10], opt.SenderKey)
+		binary.BigEndian.PutUint64(data[10:18], opt.ReceiverKey)
+		return data, nil
+
+	case CapableOptionSenderReceiverData:
+		// The checksum is present if checksums are required (A).
+		length := 20
+		if opt.A {
+			length = 22
+		}
+		data := serializeCapable(opt.CapableOption, length)
+		binary.BigEndian.PutUint64(data[2:10], opt.SenderKey)
+		binary.BigEndian.PutUint64(data[10:18], opt.ReceiverKey)
+		binary.BigEndian.PutUint16(data[18:20], opt.DataLevelLength)
+		if opt.A {
+			binary.BigEndian.PutUint16(data[20:22], opt.Checksum)
+		}
+		return data, nil
+
+	case JoinOptionSYN:
+		data := make([]byte, 10)
+		data[0] = byte(JoinConnection) << 4 | flag(opt.B, 0x1)
+		data[1] = opt.AddressID
+		binary.BigEndian.PutUint32(data[2:6], opt.ReceiverToken)
+		binary.BigEndian.PutUint32(data[6:10], opt.SenderRandomNumber)
+		return data, nil
+
+	case JoinOptionSYNACK:
+		data := make([]byte, 14)
+		data[0] = byte(JoinConnection) << 4 | flag(opt.B, 0x1)
+		data[1] = opt.AddressID
+		binary.BigEndian.PutUint64(data[2:10], opt.SenderTruncHMAC)
+		binary.BigEndian.PutUint32(data[10:14], opt.SenderRandomNumber)
+		return data, nil
+
+	case JoinOptionThirdACK:
+		if len(opt.SenderHMAC) != 20 {
+			return nil, layer.ParsingError(fmt.Sprint("Invalid HMAC length ", len(opt.SenderHMAC), " for ", JoinConnection, "."))
+		}
+		data := make([]byte, 22)
+		data[0] = byte(JoinConnection) << 4
+		copy(data[2:22], opt.SenderHMAC)
+		return data, nil
+
+	case DataSequenceSignalOption:
+		return serializeDataSequenceSignal(opt), nil
+
+	case AddAddressOption:
+		return serializeAddAddress(opt)
+
+	case RemoveAddressOption:
+		if len(opt.AddressIDs) == 0 {
+			return nil, layer.ParsingError(fmt.Sprint("No address id for ", RemoveAddress, "."))
+		}
+		data := make([]byte, 1 + len(opt.AddressIDs))
+		data[0] = byte(RemoveAddress) << 4
+		copy(data[1:], opt.AddressIDs)
+		return data, nil
+
+	case ChangeSubflowPriorityOption:
+		data := []byte{byte(ChangeSubflowPriority) << 4 | flag(opt.Backup, 0x1)}
+		if opt.AddressIDPresent {
+			data = append(data, opt.AddressID)
+		}
+		return data, nil
+
+	case FallbackOption:
+		data := make([]byte, 10)
+		data[0] = byte(Fallback) << 4
+		binary.BigEndian.PutUint64(data[2:10], opt.DataSequenceNumber)
+		return data, nil
+
+	case FastCloseOption:
+		data := make([]byte, 10)
+		data[0] = byte(FastClose) << 4
+		binary.BigEndian.PutUint64(data[2:10], opt.ReceiverKey)
+		return data, nil
+
+	case ResetOption:
+		return []byte{
+			byte(Reset) << 4 | flag(opt.U, 0x8) | flag(opt.V, 0x4) | flag(opt.W, 0x2) | flag(opt.T, 0x1),
+			opt.Reason,
+		}, nil
+	}
+
+	return nil, layer.ParsingError(fmt.Sprint("Unable to serialize MPTCP option of type ", fmt.Sprintf("%T", option), "."))
+}
+
+func serializeCapable(opt CapableOption, length int) []byte {
+	data := make([]byte, length)
+	data[0] = byte(MultipathCapable) << 4 | byte(opt.Version) & 0xf
+	data[1] = flag(opt.A, 0x80) | flag(opt.B, 0x40) | flag(opt.C, 0x20) | flag(opt.D, 0x10) |
+			  flag(opt.E, 0x8)  | flag(opt.F, 0x4)  | flag(opt.G, 0x2)  | flag(opt.H, 0x1)
+	return data
+}
+
+func serializeDataSequenceSignal(opt DataSequenceSignalOption) []byte {
+
+	length := dataSequenceSignalLength(opt)
+	if opt.MappingPresent && opt.ChecksumPresent {
+		length += 2
+	}
+
+	data := make([]byte, 2, length - 2)
+	data[0] = byte(DataSequenceSignal) << 4
+	data[1] = flag(opt.DataFIN, 0x10) | flag(opt.DataSequenceLong, 0x08) | flag(opt.MappingPresent, 0x04) |
+			  flag(opt.DataACKLong, 0x02) | flag(opt.DataACKPresent, 0x01)
+
+	if opt.DataACKPresent {
+		if opt.DataACKLong {
+			data = appendUint64(data, opt.DataACK)
+		} else {
+			data = appendUint32(data, uint32(opt.DataACK))
+		}
+	}
+	if opt.MappingPresent {
+		if opt.DataSequenceLong {
+			data = appendUint64(data, opt.DataSequenceNumber)
+		} else {
+			data = appendUint32(data, uint32(opt.DataSequenceNumber))
+		}
+		data = appendUint32(data, opt.SubflowSequenceNumber)
+		data = appendUint16(data, opt.DataLevelLength)
+		if opt.ChecksumPresent {
+			data = appendUint16(data, opt.Checksum)
+		}
+	}
+
+	return data
+}
+
+func serializeAddAddress(opt AddAddressOption) ([]byte, error) {
+
+	address := opt.Address.To4()
+	if address == nil {
+		address = opt.Address.To16()
+	}
+	if address == nil {
+		return nil, layer.ParsingError(fmt.Sprint("Invalid address ", opt.Address, " for ", AddAddress, "."))
+	}
+
+	data := make([]byte, 2, 2 + len(address) + 2 + 8)
+	switch opt.Version {
+	case Version0:
+		ipVersion := byte(4)
+		if len(address) == net.IPv6len {
+			ipVersion = 6
+		}
+		data[0] = byte(AddAddress) << 4 | ipVersion
+	case Version1:
+		data[0] = byte(AddAddress) << 4 | flag(opt.Echo, 0x1)
+	default:
+		return nil, layer.ParsingError(fmt.Sprint("Unsupported MPTCP version ", opt.Version, " for ", AddAddress, "."))
+	}
+	data[1] = opt.AddressID
+
+	data = append(data, address...)
+	if opt.Port != 0 {
+		data = appendUint16(data, opt.Port)
+	}
+	if opt.Version == Version1 && !opt.Echo {
+		data = appendUint64(data, opt.HMAC)
+	}
+
+	return data, nil
+}
+
+func flag(set bool, mask byte) byte {
+	if set {
+		return mask
+	}
+	return 0
+}
+
+func appendUint16(data []byte, value uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], value)
+	return append(data, buf[:]...)
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], value)
+	return append(data, buf[:]...)
+}
+
+func appendUint64(data []byte, value uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], value)
+	return append(data, buf[:]...)
+}
@@ -4,9 +4,10 @@ package mptcp
 import (
 	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
-	"github.com/google/gopacket/layers"
+	"net"
 	"shila/layer"
 	"shila/layer/tcpip"
 )
@@ -25,11 +26,16 @@ type RawOption struct {
 	OptionData []byte
 }
 
-type CapableOptionSender struct {
+// MP_CAPABLE w/o key, sent with the SYN in MPTCP v1.
+type CapableOption struct {
 	OptionBase
-	Version                uint8
+	Version                Version
 	A, B, C, D, E, F, G, H bool
-	SenderKey              uint64
+}
+
+type CapableOptionSender struct {
+	CapableOption
+	SenderKey uint64
 }
 
 type CapableOptionSenderReceiver struct {
@@ -37,6 +43,13 @@ type CapableOptionSenderReceiver struct {
 	ReceiverKey uint64
 }
 
+// MP_CAPABLE sent with the first data in MPTCP v1, the checksum is just present if negotiated.
+type CapableOptionSenderReceiverData struct {
+	CapableOptionSenderReceiver
+	DataLevelLength uint16
+	Checksum        uint16
+}
+
 type JoinOptionSYN struct {
 	OptionBase
 	B                  bool
@@ -58,20 +71,90 @@ type JoinOptionThirdACK struct {
 	SenderHMAC []byte
 }
 
+// DSS, the data ACK and the mapping are optional. The long (8 octets) variants
+// of the data ACK and the data sequence number are indicated by a and m.
+type DataSequenceSignalOption struct {
+	OptionBase
+	DataFIN               bool   // F
+	DataACKPresent        bool   // A
+	DataACKLong           bool   // a
+	DataACK               uint64
+	MappingPresent        bool   // M
+	DataSequenceLong      bool   // m
+	DataSequenceNumber    uint64
+	SubflowSequenceNumber uint32
+	DataLevelLength       uint16
+	ChecksumPresent       bool
+	Checksum              uint16
+}
+
+// ADD_ADDR, v0 announces the ip version, v1 the echo flag. The port is just present if non-zero,
+// the truncated HMAC is present in v1 unless echoed.
+type AddAddressOption struct {
+	OptionBase
+	Version   Version
+	Echo      bool
+	AddressID uint8
+	Address   net.IP
+	Port      uint16
+	HMAC      uint64
+}
+
+type RemoveAddressOption struct {
+	OptionBase
+	AddressIDs []uint8
+}
+
+// MP_PRIO, the address id is just present in v0.
+type ChangeSubflowPriorityOption struct {
+	OptionBase
+	Backup           bool
+	AddressIDPresent bool
+	AddressID        uint8
+}
+
+// MP_FAIL
+type FallbackOption struct {
+	OptionBase
+	DataSequenceNumber uint64
+}
+
+// MP_FASTCLOSE
+type FastCloseOption struct {
+	OptionBase
+	ReceiverKey uint64
+}
+
+// MP_TCPRST, just in v1.
+type ResetOption struct {
+	OptionBase
+	U, V, W, T bool
+	Reason     uint8
+}
+
 type OptionSubtype uint8
 
 type EndpointToken uint32
 type EndpointKey uint64
 
+// Version of MPTCP, as announced in MP_CAPABLE.
+type Version uint8
+
+const (
+	Version0 Version = 0 // RFC 6824, the out-of-tree kernel.
+	Version1 Version = 1 // RFC 8684, the upstream kernel since 5.6.
+)
+
 const (
-	MultipathCapable      OptionSubtype = 0 // len = 12 or 20
+	MultipathCapable      OptionSubtype = 0 // len = 12 or 20 (v0) / 4, 12, 20, 22 or 24 (v1)
 	JoinConnection        OptionSubtype = 1 // len = 12 (SYN) / 16 (SYN/ACK) / 24 (3rd ACK)
-	DataSequenceSignal    OptionSubtype = 2
-	AddAddress            OptionSubtype = 3
-	RemoveAddress         OptionSubtype = 4
-	ChangeSubflowPriority OptionSubtype = 5
+	DataSequenceSignal    OptionSubtype = 2 // len = 4 + data ACK (4, 8) + mapping (10, 14) + checksum (2)
+	AddAddress            OptionSubtype = 3 // len = 8 / 10 (v4) / 20 / 22 (v6) + HMAC (8, v1)
+	RemoveAddress         OptionSubtype = 4 // len = 3 + n
+	ChangeSubflowPriority OptionSubtype = 5 // len = 3 / 4 (v0 w/ address id)
 	Fallback              OptionSubtype = 6 // len = 12
-	FastClose             OptionSubtype = 7
+	FastClose             OptionSubtype = 7 // len = 12
+	Reset                 OptionSubtype = 8 // len = 4
 )
 
 func (os OptionSubtype) String() string {
@@ -84,17 +167,20 @@ func (os OptionSubtype) String() string {
 	case ChangeSubflowPriority 	: return "ChangeSubflowPriority"
 	case Fallback               : return "Fallback"
 	case FastClose              : return "FastClose"
+	case Reset                  : return "Reset"
 	}
 	return "Unknown"
 }
 
+// GetReceiverToken returns the token of the receiver announced in the MP_JOIN of a SYN. Malformed MPTCP
+// options other than MP_JOIN are ignored.
 func GetReceiverToken(raw []byte) (EndpointToken, error) {
-	if _, tcp, err := tcpip.DecodeIPv4andTCPLayer(raw); err != nil {
-		// Error in decoding the ipv4/tcp options
+	if tcp, err := tcpip.DecodeTCPLayer(raw); err != nil {
+		// Error in decoding the ip/tcp options
 		return EndpointToken(0), err
 	} else {
-		if mptcpOptions, err := decodeMPTCPOptions(tcp); err != nil {
-			// MPTCP options does not contain the receiver token
+		if mptcpOptions, err := decodeMPTCPOptionsOf(tcp, JoinConnection); err != nil {
+			// Error in decoding the mptcp options
 			return EndpointToken(0), err
 		} else {
 			for _, mptcpOption := range mptcpOptions {
@@ -102,154 +188,54 @@ func GetReceiverToken(raw []byte) (EndpointToken, error) {
 					return EndpointToken(mptcpJoinOptionSYN.ReceiverToken), nil
 				}
 			}
-			// Error in decoding the mptcp options
-			return EndpointToken(0), err
+			// MPTCP options does not contain the receiver token
+			return EndpointToken(0), nil
 		}
 	}
 }
 
-func GetSenderKey(raw []byte) (EndpointKey, bool, error) {
-	if _, tcp, err := tcpip.DecodeIPv4andTCPLayer(raw); err != nil {
-		// Error in decoding the ipv4/tcp options
-		return EndpointKey(0), false, err
+// GetSenderKey returns the key of the sender announced in MP_CAPABLE (SYN and SYN/ACK in v0, just
+// SYN/ACK in v1) together with the version of MPTCP the connection uses. Malformed MPTCP options
+// other than MP_CAPABLE are ignored.
+func GetSenderKey(raw []byte) (EndpointKey, Version, bool, error) {
+	if tcp, err := tcpip.DecodeTCPLayer(raw); err != nil {
+		// Error in decoding the ip/tcp options
+		return EndpointKey(0), Version0, false, err
 	} else {
-		if mptcpOptions, err := decodeMPTCPOptions(tcp); err != nil {
+		if mptcpOptions, err := decodeMPTCPOptionsOf(tcp, MultipathCapable); err != nil {
 			// Error in decoding the mptcp options
-			return EndpointKey(0), false, err
+			return EndpointKey(0), Version0, false, err
 		} else {
 			for _, mptcpOption := range mptcpOptions {
 				if mptcpCapableOptionSender, ok := mptcpOption.(CapableOptionSender); ok {
-					return EndpointKey(mptcpCapableOptionSender.SenderKey), true, nil
+					return EndpointKey(mptcpCapableOptionSender.SenderKey), mptcpCapableOptionSender.Version, true, nil
 				}
 			}
 			// MPTCP options does not contain the senders key
-			return EndpointKey(0), false, nil
+			return EndpointKey(0), Version0, false, nil
 		}
 	}
 }
 
-func EndpointKeyToToken(key EndpointKey) (EndpointToken, error) {
-	// The token is used to identify the MPTCP connection and is a cryptographic hash of the receiver's Identifier, as
-	// exchanged in the initial MP_CAPABLE handshake (Section 3.1).  In this specification, the tokens presented in
-	// this option are generated by the SHA-1 algorithm, truncated to the most significant 32 bits.
-	// https://tools.ietf.org/html/rfc6824#section-3.1
+func EndpointKeyToToken(key EndpointKey, version Version) (EndpointToken, error) {
 	buf := new(bytes.Buffer)
 	if err := binary.Write(buf, binary.BigEndian, key); err != nil {
 		return EndpointToken(0), err
 	}
-	check := sha1.Sum(buf.Bytes())
-	return EndpointToken(binary.BigEndian.Uint32(check[0:5])), nil
-}
-
-func decodeMPTCPOptions(tcp layers.TCP) (options []Option, err error) {
-
-	options = []Option{}
-
-	// Loop over all options, find the MPTCP options and decode them further.
-	for _, option := range tcp.Options {
-		if option.OptionType == layers.TCPOptionKind(TCPOptionKindMPTCP) {
-
-			var opt Option
-
-			length := option.OptionLength
-			subtype := OptionSubtype(option.OptionData[0] >> 4)
-
-			optBase := OptionBase{OptionLength: length, OptionSubtype: subtype}
-			data := option.OptionData
-
-			switch subtype {
-
-			case MultipathCapable:
-
-				if length != 12 && length != 20 {
-					err = layer.ParsingError(fmt.Sprint("Invalid length ", length, " for ", MultipathCapable, "."))
-					return
-				}
-
-				version := data[0] & 0xf
-
-				A := data[1]&0x80 != 0
-				B := data[1]&0x40 != 0
-				C := data[1]&0x20 != 0
-				D := data[1]&0x10 != 0
-				E := data[1]&0x8 != 0
-				F := data[1]&0x4 != 0
-				G := data[1]&0x2 != 0
-				H := data[1]&0x1 != 0
-
-				senderKey := binary.BigEndian.Uint64(data[2:10])
-
-				opt = CapableOptionSender{
-					OptionBase: optBase,
-					Version: 	version,
-					A: A, B: B, C: C, D: D,
-					E: E, F: F, G: G, H: H,
-					SenderKey: 	senderKey,
-				}
-
-				if length == 20 {
-					opt = CapableOptionSenderReceiver{
-						CapableOptionSender: opt.(CapableOptionSender),
-						ReceiverKey:		 binary.BigEndian.Uint64(data[10:17]),
-					}
-				}
-
-			case JoinConnection:
-
-				switch length {
-
-				case 12:
-
-					B := data[0]&0x1 != 0
-					addressID := data[1]
-
-					receiverToken := binary.BigEndian.Uint32(data[2:6])
-					senderRandomNumber := binary.BigEndian.Uint32(data[6:10])
-
-					opt = JoinOptionSYN{
-						OptionBase: 		optBase,
-						B: 					B,
-						AddressID: 			addressID,
-						ReceiverToken: 		receiverToken,
-						SenderRandomNumber: senderRandomNumber,
-					}
-
-				case 16:
-
-					B := data[0]&0x1 != 0
-					addressID := data[1]
-
-					senderTruncHMAC := binary.BigEndian.Uint64(data[2:10])
-					senderRandomNumber := binary.BigEndian.Uint32(data[10:14])
-
-					opt = JoinOptionSYNACK{
-						OptionBase: 		optBase,
-						B: 					B,
-						AddressID: 			addressID,
-						SenderTruncHMAC: 	senderTruncHMAC,
-						SenderRandomNumber: senderRandomNumber,
-					}
-
-				case 24:
-
-					opt = JoinOptionThirdACK{OptionBase: optBase, SenderHMAC: data[2:22]}
-
-				default:
-					err = layer.ParsingError(fmt.Sprint("Invalid length ", length, " for ", JoinConnection, "."))
-					return
-				}
-
-			case DataSequenceSignal, AddAddress, RemoveAddress,
-				ChangeSubflowPriority, Fallback, FastClose:
-				opt = RawOption{OptionBase: optBase, OptionData: data}
-
-			default:
-				continue
-			}
-
-			options = append(options, opt)
-		}
+	switch version {
+	case Version0:
+		// The token is used to identify the MPTCP connection and is a cryptographic hash of the receiver's Identifier, as
+		// exchanged in the initial MP_CAPABLE handshake (Section 3.1).  In this specification, the tokens presented in
+		// this option are generated by the SHA-1 algorithm, truncated to the most significant 32 bits.
+		// https://tools.ietf.org/html/rfc6824#section-3.1
+		check := sha1.Sum(buf.Bytes())
+		return EndpointToken(binary.BigEndian.Uint32(check[0:4])), nil
+	case Version1:
+		// The token MUST be a hash of the key of the receiver, [...] the most significant 32 bits of the
+		// SHA-256 hash of the key.
+		// https://tools.ietf.org/html/rfc8684#section-3.2
+		check := sha256.Sum256(buf.Bytes())
+		return EndpointToken(binary.BigEndian.Uint32(check[0:4])), nil
 	}
-
-	return
+	return EndpointToken(0), layer.ParsingError(fmt.Sprint("Unsupported MPTCP version ", version, "."))
 }
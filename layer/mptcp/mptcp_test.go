@@ -0,0 +1,69 @@
+//
+package mptcp
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"testing"
+)
+
+// SYN/ACK w/ MP_CAPABLE (v1) and SYN w/ MP_JOIN, captured as the options in decode_test.go.
+const (
+	capableSYNACK = "4500004800004000400626af0a0000010a000001138889b473cec7adcbd529acd012ffcb143c00000204ffd70402080a12d4e70cb269d9160103030a1e0c010166ad75a092632ad4"
+	joinSYN       = "4500004845ef40004006e0bb0a0000030a0000039ec5138935017cb000000000d002ffd7144000000204ffd70402080a9d9b004e000000000103030a1e0c1006ef17fa68210d1e55"
+)
+
+// A DSS whose length does not match its flags.
+var malformedDSS = layers.TCPOption{OptionType: TCPOptionKindMPTCP, OptionLength: 5, OptionData: []byte{0x20, 0x01, 0x00}}
+
+func TestGetSenderKey(t *testing.T) {
+	for name, raw := range map[string][]byte{
+		"captured":      decodeHex(capableSYNACK),
+		"malformed DSS": withOption(t, decodeHex(capableSYNACK), malformedDSS),
+	} {
+		key, version, ok, err := GetSenderKey(raw)
+		if err != nil || !ok || key != 0x66ad75a092632ad4 || version != Version1 {
+			t.Errorf("%s: got key %x (v%d, %t), %v", name, key, version, ok, err)
+		}
+	}
+}
+
+func TestGetReceiverToken(t *testing.T) {
+	for name, raw := range map[string][]byte{
+		"captured":      decodeHex(joinSYN),
+		"malformed DSS": withOption(t, decodeHex(joinSYN), malformedDSS),
+	} {
+		if token, err := GetReceiverToken(raw); err != nil || token != 0xef17fa68 {
+			t.Errorf("%s: got token %x, %v", name, token, err)
+		}
+	}
+}
+
+// A malformed option of the subtype looked for is still an error.
+func TestMalformedCapable(t *testing.T) {
+	malformed := layers.TCPOption{OptionType: TCPOptionKindMPTCP, OptionLength: 5, OptionData: []byte{0x01, 0x01, 0x00}}
+	if _, _, _, err := GetSenderKey(withOption(t, decodeHex(joinSYN), malformed)); err == nil {
+		t.Error("Malformed MP_CAPABLE accepted.")
+	}
+}
+
+// Appends the option to the ones of the IPv4/TCP frame.
+func withOption(t *testing.T, raw []byte, option layers.TCPOption) []byte {
+
+	packet := gopacket.NewPacket(raw, layers.LayerTypeIPv4, gopacket.Default)
+	ip, _ := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	tcp, _ := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if ip == nil || tcp == nil {
+		t.Fatal("Unable to decode frame.")
+	}
+	tcp.Options = append(tcp.Options, option)
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ip, tcp); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,105 @@
+//
+package tcpip
+
+import (
+	"fmt"
+	"shila/layer"
+)
+
+const (
+	ipv4Version       = 4
+	ipv6Version       = 6
+	ipv6HeaderLen     = 40
+	ipProtocolTCP     = 6
+	tcpFlagSYN        = 0x02
+	tcpOptionEnd      = 0
+	tcpOptionNop      = 1
+	tcpOptionMSS      = 2
+	tcpOptionMSSLen   = 4
+	minIPv4HeaderLen  = 20
+	minTCPHeaderLen   = 20
+)
+
+// ClampMSS lowers the MSS option of a SYN or SYN/ACK segment within an IPv4 or IPv6 frame to
+// at most mss and fixes the TCP checksum. The frame is modified in place. Returns true if the
+// MSS was changed, segments without SYN flag or MSS option are left untouched.
+func ClampMSS(raw []byte, mss uint16) (bool, error) {
+
+	var ipHeaderLen int
+	switch {
+	case len(raw) >= minIPv4HeaderLen && raw[0] >> 4 == ipv4Version:
+		if raw[9] != ipProtocolTCP {
+			return false, nil
+		}
+		ipHeaderLen = int(raw[0] & 0x0f) * 4
+		if ipHeaderLen < minIPv4HeaderLen {
+			return false, layer.ParsingError("Cannot clamp MSS, invalid IPv4 header length.")
+		}
+	case len(raw) >= ipv6HeaderLen && raw[0] >> 4 == ipv6Version:
+		// Segments behind extension headers are left untouched.
+		if raw[6] != ipProtocolTCP {
+			return false, nil
+		}
+		ipHeaderLen = ipv6HeaderLen
+	default:
+		return false, layer.ParsingError("Cannot clamp MSS, neither IPv4 nor IPv6 frame.")
+	}
+	if len(raw) < ipHeaderLen + minTCPHeaderLen {
+		return false, layer.ParsingError("Cannot clamp MSS, frame too short.")
+	}
+
+	tcp := raw[ipHeaderLen:]
+	tcpHeaderLen := int(tcp[12] >> 4) * 4
+	if tcpHeaderLen < minTCPHeaderLen || len(tcp) < tcpHeaderLen {
+		return false, layer.ParsingError("Cannot clamp MSS, invalid TCP data offset.")
+	}
+	if tcp[13] & tcpFlagSYN == 0 {
+		return false, nil
+	}
+
+	options := tcp[minTCPHeaderLen:tcpHeaderLen]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case tcpOptionEnd:
+			return false, nil
+		case tcpOptionNop:
+			i++
+			continue
+		}
+		if i + 1 >= len(options) {
+			return false, layer.ParsingError("Cannot clamp MSS, truncated TCP option.")
+		}
+		length := int(options[i+1])
+		if length < 2 || i + length > len(options) {
+			return false, layer.ParsingError(fmt.Sprint("Cannot clamp MSS, invalid length ", length, " of TCP option."))
+		}
+		if options[i] == tcpOptionMSS && length == tcpOptionMSSLen {
+			current := hostByteOrder.Uint16(options[i+2:i+4])
+			if current <= mss {
+				return false, nil
+			}
+			hostByteOrder.PutUint16(options[i+2:i+4], mss)
+			// The checksum is calculated over 16 bit words, a value at an odd offset contributes byte swapped.
+			if (minTCPHeaderLen + i + 2) % 2 == 1 {
+				current, mss = swapBytes(current), swapBytes(mss)
+			}
+			updateChecksum(tcp[16:18], current, mss)
+			return true, nil
+		}
+		i += length
+	}
+
+	return false, nil
+}
+
+// Incremental update of the internet checksum (RFC 1624, eqn. 3).
+func updateChecksum(checksum []byte, old uint16, new uint16) {
+	sum := uint32(^hostByteOrder.Uint16(checksum)) + uint32(^old) + uint32(new)
+	sum  = (sum & 0xffff) + (sum >> 16)
+	sum  = (sum & 0xffff) + (sum >> 16)
+	hostByteOrder.PutUint16(checksum, ^uint16(sum))
+}
+
+func swapBytes(value uint16) uint16 {
+	return value << 8 | value >> 8
+}
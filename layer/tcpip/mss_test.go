@@ -0,0 +1,88 @@
+//
+package tcpip
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+// The clamped frame has to equal the frame serialized w/ the target MSS right away, including the
+// checksum. The leading NOPs move the MSS to an odd offset and back.
+func TestClampMSS(t *testing.T) {
+
+	for _, ipv6 := range []bool{false, true} {
+		for nops := 0; nops <= 3; nops++ {
+			name := fmt.Sprint("IPv6: ", ipv6, ", NOPs: ", nops)
+
+			raw := synFrame(t, ipv6, nops, 1460, true)
+			clamped, err := ClampMSS(raw, 1200)
+			if err != nil || !clamped {
+				t.Errorf("%s: not clamped. %v", name, err)
+				continue
+			}
+			if want := synFrame(t, ipv6, nops, 1200, true); !bytes.Equal(raw, want) {
+				t.Errorf("%s: clamped to %x, want %x", name, raw, want)
+			}
+
+			// A smaller MSS is kept.
+			raw = synFrame(t, ipv6, nops, 1000, true)
+			if clamped, err := ClampMSS(raw, 1200); err != nil || clamped {
+				t.Errorf("%s: smaller MSS clamped. %v", name, err)
+			}
+			if want := synFrame(t, ipv6, nops, 1000, true); !bytes.Equal(raw, want) {
+				t.Errorf("%s: smaller MSS modified.", name)
+			}
+
+			// Segments w/o SYN flag are left untouched.
+			raw = synFrame(t, ipv6, nops, 1460, false)
+			if clamped, err := ClampMSS(raw, 1200); err != nil || clamped {
+				t.Errorf("%s: segment w/o SYN clamped. %v", name, err)
+			}
+		}
+	}
+}
+
+func TestClampMSSInvalidOption(t *testing.T) {
+	raw := synFrame(t, false, 0, 1460, true)
+	raw[minIPv4HeaderLen + minTCPHeaderLen + 1] = 1		// Length of the MSS option.
+	if _, err := ClampMSS(raw, 1200); err == nil {
+		t.Error("Clamped MSS of an invalid option.")
+	}
+}
+
+// Serializes a TCP segment w/ the MSS option behind the given number of NOPs and a window scale option.
+func synFrame(t *testing.T, ipv6 bool, nops int, mss uint16, syn bool) []byte {
+
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: 0x12345678, SYN: syn, ACK: !syn, Window: 64240}
+	for i := 0; i < nops; i++ {
+		tcp.Options = append(tcp.Options, layers.TCPOption{OptionType: layers.TCPOptionKindNop, OptionLength: 1})
+	}
+	tcp.Options = append(tcp.Options,
+		layers.TCPOption{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{byte(mss >> 8), byte(mss)}},
+		layers.TCPOption{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+	)
+
+	var network gopacket.SerializableLayer
+	if ipv6 {
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP,
+			SrcIP: net.ParseIP("fd00:7::9"), DstIP: net.ParseIP("fd00:7:1::1")}
+		network = ip
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+	} else {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+			SrcIP: net.IPv4(10, 7, 0, 9).To4(), DstIP: net.IPv4(10, 7, 1, 1).To4()}
+		network = ip
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, options, network, tcp); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
@@ -21,6 +21,15 @@ func DecodeIPv4POptions(ip layers.IPv4) (options []IPv4Option, err error) {
 }
 
 func DecodeSrcAndDstTCPAddr(raw []byte) (net.TCPAddr, net.TCPAddr, error) {
+	if len(raw) > 0 && raw[0] >> 4 == ipv6Version {
+		if ipv6, tcp, err := DecodeIPv6andTCPLayer(raw); err != nil {
+			return net.TCPAddr{}, net.TCPAddr{}, err
+		} else {
+			return net.TCPAddr{IP: ipv6.SrcIP, Port: int(tcp.SrcPort)},
+				   net.TCPAddr{IP: ipv6.DstIP, Port: int(tcp.DstPort)},
+				   nil
+		}
+	}
 	if ip4v, tcp, err := DecodeIPv4andTCPLayer(raw); err != nil {
 		return net.TCPAddr{}, net.TCPAddr{}, err
 	} else {
@@ -30,6 +39,16 @@ func DecodeSrcAndDstTCPAddr(raw []byte) (net.TCPAddr, net.TCPAddr, error) {
 	}
 }
 
+// DecodeTCPLayer decodes the tcp layer of an IPv4 or IPv6 frame.
+func DecodeTCPLayer(raw []byte) (layers.TCP, error) {
+	if len(raw) > 0 && raw[0] >> 4 == ipv6Version {
+		_, tcp, err := DecodeIPv6andTCPLayer(raw)
+		return tcp, err
+	}
+	_, tcp, err := DecodeIPv4andTCPLayer(raw)
+	return tcp, err
+}
+
 func DecodeIPv4andTCPLayer(raw []byte) (layers.IPv4, layers.TCP, error) {
 
 	ipv4 := layers.IPv4{}
@@ -45,43 +64,60 @@ func DecodeIPv4andTCPLayer(raw []byte) (layers.IPv4, layers.TCP, error) {
 	return ipv4, tcp, nil
 }
 
-// Returns the next IPv4 frame, or an error if unable to parse.
-func PacketizeRawData(ingressRaw chan byte, sizeReadBuffer int) ([]byte, error) {
-	for {
-		rawData := make([]byte, 0, sizeReadBuffer)
-		b, open := <-ingressRaw
-		if b >> 4 == 4 {
-			rawData = append(rawData, b)
-			// Read 3 more bytes
-			for cnt := 0; cnt < 3; cnt++ {
-				b, ok := <-ingressRaw; open = open && ok
-				rawData = append(rawData, b)
-			}
-			length := binary.ByteOrder(hostByteOrder).Uint16(rawData[2:4])
-			// Load the remaining bytes of the package
-			for cnt := 0; cnt < int(length-4); cnt++ {
-				b, ok := <-ingressRaw; open = open && ok
-				rawData = append(rawData, b)
-			}
-			return rawData, nil
-		} else if b >> 4 == 6 {
-			rawData = append(rawData, b)
-			// Read 7 more bytes
-			for cnt := 0; cnt < 7; cnt++ {
-				b, ok := <-ingressRaw; open = open && ok
-				rawData = append(rawData, b)
-			}
-			length := binary.ByteOrder(hostByteOrder).Uint16(rawData[4:6])
-			// Discard the remaining 32 byte of the header and the payload
-			for cnt := 0; cnt < int(32+length); cnt++ {
-				_, ok := <-ingressRaw; open = open && ok
-			}
-		} else if !open {
-			// Ingress was closed in the meantime, return nil.
-			return nil, nil
-		} else {
-			return nil, layer.ParsingError(fmt.Sprint("Unknown IP version ", b >> 4, "."))
+// The hop-by-hop options header is decoded by the IPv6 layer itself, further
+// extension headers in front of the tcp layer are skipped.
+func DecodeIPv6andTCPLayer(raw []byte) (layers.IPv6, layers.TCP, error) {
+
+	ipv6       := layers.IPv6{}
+	extensions := layers.IPv6ExtensionSkipper{}
+	tcp        := layers.TCP{}
+
+	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeIPv6, &ipv6, &extensions, &tcp)
+	var decoded []gopacket.LayerType
+	if err := parser.DecodeLayers(raw, &decoded); err != nil {
+		if _, ok := err.(gopacket.UnsupportedLayerType); !ok {
+			return ipv6, tcp, err
+		}
+	}
+	return ipv6, tcp, nil
+}
+
+// FrameLength returns the total length of the IPv4 or IPv6 frame as announced in its header.
+func FrameLength(raw []byte) (int, error) {
+	if len(raw) == 0 {
+		return 0, layer.ParsingError("Empty frame.")
+	}
+	switch raw[0] >> 4 {
+	case ipv4Version:
+		if len(raw) < minIPv4HeaderLen {
+			return 0, layer.ParsingError("IPv4 frame shorter than its header.")
 		}
+		return int(hostByteOrder.Uint16(raw[2:4])), nil
+	case ipv6Version:
+		if len(raw) < ipv6HeaderLen {
+			return 0, layer.ParsingError("IPv6 frame shorter than its header.")
+		}
+		// The payload length does not include the fixed header.
+		return ipv6HeaderLen + int(hostByteOrder.Uint16(raw[4:6])), nil
+	default:
+		return 0, layer.ParsingError(fmt.Sprint("Unknown IP version ", raw[0] >> 4, "."))
+	}
+}
+
+// DSCP returns the differentiated services code point of the IPv4 or IPv6 frame.
+func DSCP(raw []byte) (uint8, error) {
+	if len(raw) < 2 {
+		return 0, layer.ParsingError("Frame shorter than its header.")
+	}
+	switch raw[0] >> 4 {
+	case ipv4Version:
+		// Upper six bits of the type of service.
+		return raw[1] >> 2, nil
+	case ipv6Version:
+		// Upper six bits of the traffic class, which spans the first two bytes.
+		return (raw[0] & 0x0f) << 2 | raw[1] >> 6, nil
+	default:
+		return 0, layer.ParsingError(fmt.Sprint("Unknown IP version ", raw[0] >> 4, "."))
 	}
 }
 
@@ -2,8 +2,10 @@ package main
 
 import (
 	"os"
-	_ "shila/config"
+	"shila/capture"
+	"shila/config"
 	"shila/core/connection"
+	"shila/core/qos"
 	"shila/core/router"
 	"shila/core/shila"
 	"shila/kernelSide"
@@ -32,8 +34,37 @@ func realMain() int {
 	var err error
 
 	log.Init()					// Initialize logging functionality
+
+	// Just remove what a previous (crashed) run left behind.
+	if config.CleanupOnly {
+		if err = kernelSide.ReclaimLeftovers(); err != nil {
+			log.Error.Print(shila.PrependError(err, "Unable to clean up.").Error())
+			return ErrorCode
+		}
+		log.Info.Println("Clean up done.")
+		return SuccessCode
+	}
+
+	// Just add or remove an egress interface of the running instance.
+	if config.ControlCommand != "" {
+		reply, err := kernelSide.SendControlCommand(config.ControlCommand)
+		if err != nil {
+			log.Error.Print(shila.PrependError(err, "Unable to execute control command.").Error())
+			return ErrorCode
+		}
+		log.Info.Println("Control command done.", reply)
+		return SuccessCode
+	}
+
 	shutdown.Init()				// Initialize termination functionality
 
+	// Start the packet capture (if enabled)
+	if err = capture.Init(); err != nil {
+		log.Error.Print(shila.PrependError(err, "Unable to start packet capture.").Error())
+		return ErrorCode
+	}
+	defer capture.Close()
+
 	log.Verbose.Println("Setup started...")
 
 	// This channel is used by the kernel and the network side to announce new packet channels, either to
@@ -48,7 +79,7 @@ func realMain() int {
 	}
 
 	// Create and setup the kernelSide side
-	kernelSide := kernelSide.New(trafficChannelPubs)
+	kernelSide := kernelSide.New(trafficChannelPubs, endpointIssues)
 	if err = kernelSide.Setup(); err != nil {
 		log.Error.Print(shila.PrependError(err, "Unable to setup kernel side.").Error())
 		return ErrorCode
@@ -65,9 +96,16 @@ func realMain() int {
 	log.Verbose.Println("Network side setup successfully.")
 	defer networkSide.CleanUp()
 
+	// Both working sides share the scheduling of the packets towards the network endpoints (if QoS is enabled).
+	qos, err := qos.New()
+	if err != nil {
+		log.Error.Print(shila.PrependError(err, "Unable to setup QoS.").Error())
+		return ErrorCode
+	}
+
 	// Setup the ingress working side
 	// The ingress working side handles all traffic which was initiated by the network side.
-	workingSideIngress := workingSide.New(connection.NewMapping(kernelSide, networkSide, router.New()),
+	workingSideIngress := workingSide.New(connection.NewMapping(kernelSide, networkSide, router.New(), qos),
 		trafficChannelPubs.Ingress, endpointIssues.Ingress, workingSide.Ingress)
 	if err := workingSideIngress.Setup(); err != nil {
 		log.Error.Print(shila.PrependError(err, "Unable to setup ingress working side.").Error())
@@ -77,7 +115,7 @@ func realMain() int {
 
 	// Setup the egress working side
 	// The egress working side handles all the traffic which was initiated by the client side.
-	workingSideEgress := workingSide.New(connection.NewMapping(kernelSide, networkSide, router.New()),
+	workingSideEgress := workingSide.New(connection.NewMapping(kernelSide, networkSide, router.New(), qos),
 		trafficChannelPubs.Egress, endpointIssues.Egress, workingSide.Egress)
 	if err := workingSideEgress.Setup(); err != nil {
 		log.Error.Print(shila.PrependError(err, "Unable to setup egress working side.").Error())
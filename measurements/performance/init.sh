@@ -39,10 +39,7 @@ go build -o _shila ../../
 
 sleep 1
 
-# Configure MPTCP and the congestion control algorithm
-sudo sysctl net.mptcp.mptcp_scheduler=default
-sudo sysctl net.mptcp.mptcp_path_manager=fullmesh
-
+# Load the congestion control algorithms of MPTCP, the path manager and the scheduler are configured by shila
 sudo /sbin/modprobe mptcp_coupled
 sudo /sbin/modprobe mptcp_balia
 sudo /sbin/modprobe mptcp_olia
@@ -0,0 +1,49 @@
+//
+package networkSide
+
+import (
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/networkSide/network"
+)
+
+// Protocols which can be used for the backbone connections between Shila instances.
+const (
+	udpBackbone      = "udp"
+	quicBackbone     = "quic"
+	ipBackbone       = "ip"
+	loopbackBackbone = "loopback"
+)
+
+func newSpecificManager() shila.SpecificNetworkSideManager {
+	switch config.Config.NetworkSide.Backbone {
+	case udpBackbone:
+		return NewSpecificManager()
+	case quicBackbone:
+		return NewQUICSpecificManager()
+	case ipBackbone:
+		return NewIPSpecificManager()
+	case loopbackBackbone:
+		return newLoopbackSpecificManagerFromConfig()
+	default:
+		log.Error.Println("Unknown backbone protocol; using", udpBackbone, ".")
+		return NewSpecificManager()
+	}
+}
+
+// NewAddressGenerator returns the generator for the network addresses of the configured backbone protocol.
+func NewAddressGenerator() shila.NetworkAddressGenerator {
+	if backbone := config.Config.NetworkSide.Backbone; backbone == ipBackbone || backbone == loopbackBackbone {
+		return network.IPAddressGenerator{}
+	}
+	return network.AddressGenerator{}
+}
+
+// NewPathGenerator returns the generator for the network paths of the configured backbone protocol.
+func NewPathGenerator() shila.NetworkPathGenerator {
+	if backbone := config.Config.NetworkSide.Backbone; backbone == ipBackbone || backbone == loopbackBackbone {
+		return network.IPPathGenerator{}
+	}
+	return network.PathGenerator{}
+}
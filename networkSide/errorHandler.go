@@ -1,20 +1,38 @@
 package networkSide
 
 import (
+	"fmt"
 	"shila/core/shila"
-	"shila/networkSide/networkEndpoint"
+	"shila/log"
 	"shila/shutdown"
+	"shila/supervisor"
 )
 
 func (manager *Manager) errorHandler() {
+
+	contactServerSupervisor := supervisor.New("contact server network endpoint")
+
 	for issue := range manager.serverEndpointIssues {
 		var ep interface{} = issue.Issuer
-		if server, ok := ep.(*networkEndpoint.Server); ok {
+		if server, ok := ep.(shila.NetworkServerEndpoint); ok {
 			if server.Role() == shila.ContactNetworkEndpoint {
-				// An issue in the server contact network endpoint. For the moment we just shot down the whole machinery..
-				shutdown.Fatal(shila.CriticalError(shila.PrependError(issue.Error, "Error in server contact network endpoint.").Error()))
+				// An issue concerning a single tcp flow is just published for the corresponding connection.
+				if issue.Key != "" {
+					manager.endpointIssues.Ingress <- issue
+					continue
+				}
+				// The contact server failed as a whole, it is restarted. The connections contacted through
+				// it so far already have their own traffic endpoints.
+				if manager.retireContactServer(server) {
+					contactServerSupervisor.Restart(issue.Error, manager.restartContactServer)
+				}
 			} else if server.Role() == shila.TrafficNetworkEndpoint {
 				if endpointWrapper, ok := manager.serverTrafficEndpoints[server.Key()]; ok {
+					// An issue concerning a single tcp flow is just published for the corresponding connection.
+					if issue.Key != "" {
+						manager.endpointIssues.Ingress <- issue
+						continue
+					}
 					// Publish an issue for every registered connection.
 					for tcpFlow, _ := range endpointWrapper.TCPFlowRegister {
 						manager.endpointIssues.Ingress <- shila.EndpointIssuePub{ Issuer: server, Key: tcpFlow, Error: issue.Error}
@@ -29,4 +47,46 @@ func (manager *Manager) errorHandler() {
 			shutdown.Fatal(shila.CriticalError("Endpoint with unhandled type publishes issue. Should not happen."))
 		}
 	}
-}
\ No newline at end of file
+}
+
+// Tears the failed contact server down. False if there is nothing to restart.
+func (manager *Manager) retireContactServer(server shila.NetworkServerEndpoint) bool {
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if manager.state.Not(shila.Running) || manager.contactServer != server {
+		return false
+	}
+	if err := server.TearDown(); err != nil {
+		log.Error.Print(server.Says(fmt.Sprint("Unable to tear down. ", err.Error())))
+	}
+	manager.contactServer = nil
+	return true
+}
+
+// Replaces the failed contact server by a new one listening on the same address.
+func (manager *Manager) restartContactServer() error {
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	// Shila is shutting down.
+	if manager.state.Not(shila.Running) {
+		return nil
+	}
+
+	contactLocalAddr := manager.specificManager.ContactLocalAddr()
+	server := manager.specificManager.NewServer(contactLocalAddr, shila.ContactNetworkEndpoint, manager.serverEndpointIssues)
+	if err := server.SetupAndRun(); err != nil {
+		return shila.PrependError(err, "Unable to establish contacting server.")
+	}
+	manager.contactServer = server
+
+	// Announce the traffic channels to the ingress working side
+	manager.trafficChannelPubs.Ingress <- shila.PacketChannelPub{
+										Publisher: server,
+										Channel:   server.TrafficChannels().Ingress,
+									}
+	return nil
+}
@@ -0,0 +1,160 @@
+//
+package loopback
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+var _ net.PacketConn = (*PacketConn)(nil)
+var _ net.Conn = (*Conn)(nil)
+
+// Number of datagrams buffered per socket, further datagrams are dropped.
+const sizeReceiveBuffer = 1024
+
+type PacketConn struct {
+	fabric       *Fabric
+	lAddr        *net.UDPAddr
+	inbox        chan datagram
+	readDeadline time.Time
+	lock         sync.Mutex
+	closed       chan struct{}
+	closeOnce    sync.Once
+}
+
+func newPacketConn(fabric *Fabric, lAddr *net.UDPAddr) *PacketConn {
+	return &PacketConn{
+		fabric: fabric,
+		lAddr:  lAddr,
+		inbox:  make(chan datagram, sizeReceiveBuffer),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *PacketConn) receive(d datagram) {
+	select {
+	case <-c.closed:
+	case c.inbox <- d:
+	default:
+	}
+}
+
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	d, err := c.next()
+	if err != nil {
+		return 0, nil, err
+	}
+	return copy(p, d.payload), d.src, nil
+}
+
+func (c *PacketConn) next() (datagram, error) {
+
+	c.lock.Lock()
+	deadline := c.readDeadline
+	c.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return datagram{}, c.opError("read", timeoutError{})
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case d := <-c.inbox:
+		return d, nil
+	case <-c.closed:
+		return datagram{}, c.opError("read", errClosed)
+	case <-timeout:
+		return datagram{}, c.opError("read", timeoutError{})
+	}
+}
+
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.opError("write", errClosed)
+	default:
+	}
+	rAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, c.opError("write", Error("Invalid address type."))
+	}
+	payload := make([]byte, len(p))
+	copy(payload, p)
+	c.fabric.send(c.lAddr, rAddr, payload)
+	return len(p), nil
+}
+
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.fabric.unbind(c.lAddr)
+	})
+	return nil
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.lAddr
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+// Writes never block.
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	_ = t
+	return nil
+}
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.lAddr, Err: err}
+}
+
+// Conn is a socket connected to a single remote address,
+// datagrams from other addresses are discarded.
+type Conn struct {
+	*PacketConn
+	remote *net.UDPAddr
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		d, err := c.next()
+		if err != nil {
+			return 0, err
+		}
+		if d.src.String() == c.remote.String() {
+			return copy(p, d.payload), nil
+		}
+	}
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.WriteTo(p, c.remote)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+type timeoutError struct{}
+
+func (e timeoutError) Error() string   { return "i/o timeout" }
+func (e timeoutError) Timeout() bool   { return true }
+func (e timeoutError) Temporary() bool { return true }
+
+const errClosed = Error("Use of closed connection.")
@@ -0,0 +1,261 @@
+//
+package loopback
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Fabric connects all loopback sockets within the process. Datagrams are delivered through
+// Go channels, the properties of the path they take define how much they are delayed, how
+// many of them are lost and how many of them are reordered. All random decisions are drawn
+// from a seeded source, the behaviour of the fabric is therefore reproducible.
+//
+// As w/ the paths of the plain IP backbone, a host reachable over several paths has an address
+// per path, the path of a datagram is given by the addresses it is sent from and to. Every path
+// delivers its datagrams on its own, those taking different paths overtake each other according
+// to the delays of their paths.
+type Fabric struct {
+	sockets    map[string] *PacketConn
+	paths      map[pathKey] *path
+	properties map[pathKey] PathProperties
+	defaults   PathProperties
+	random     *rand.Rand
+	nextPort   int
+	lock       sync.Mutex
+	done       chan struct{}
+}
+
+type PathProperties struct {
+	Delay        time.Duration 	// Delay of every datagram.
+	Loss         float64		// Probability that a datagram is lost.
+	Reorder      float64		// Probability that a datagram is additionally delayed (and therefore reordered).
+	ReorderDelay time.Duration	// Additional delay of a reordered datagram.
+}
+
+// Paths are directed, from the source to the destination address.
+type pathKey struct {
+	src string
+	dst string
+}
+
+const firstEphemeralPort = 49152
+
+// NewFabric creates a fabric whose paths have the given properties, unless set otherwise.
+func NewFabric(seed int64, defaults PathProperties) *Fabric {
+	return &Fabric{
+		sockets:    make(map[string] *PacketConn),
+		paths:      make(map[pathKey] *path),
+		properties: make(map[pathKey] PathProperties),
+		defaults:   defaults,
+		random:     rand.New(rand.NewSource(seed)),
+		nextPort:   firstEphemeralPort,
+		done:       make(chan struct{}),
+	}
+}
+
+// SetPathProperties sets the properties of the path between the two addresses, in both directions.
+// The datagrams already on their way keep the properties they were sent with.
+func (f *Fabric) SetPathProperties(a net.IP, b net.IP, properties PathProperties) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, key := range []pathKey{{src: a.String(), dst: b.String()}, {src: b.String(), dst: a.String()}} {
+		f.properties[key] = properties
+		if pa, ok := f.paths[key]; ok {
+			pa.properties = properties
+		}
+	}
+}
+
+func (f *Fabric) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+}
+
+// Listen binds a new socket to the given address, a zero port binds an ephemeral one.
+func (f *Fabric) Listen(lAddr *net.UDPAddr) (*PacketConn, error) {
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	addr := *lAddr
+	if addr.Port == 0 {
+		for {
+			addr.Port = f.nextPort
+			f.nextPort++
+			if _, ok := f.sockets[addr.String()]; !ok {
+				break
+			}
+		}
+	}
+	if _, ok := f.sockets[addr.String()]; ok {
+		return nil, &net.OpError{Op: "listen", Net: "udp", Addr: &addr, Err: Error("Address already in use.")}
+	}
+
+	conn := newPacketConn(f, &addr)
+	f.sockets[addr.String()] = conn
+	return conn, nil
+}
+
+// Dial binds a new socket to an ephemeral port of the local address and connects it to the remote address.
+func (f *Fabric) Dial(lAddr *net.UDPAddr, rAddr *net.UDPAddr) (*Conn, error) {
+	conn, err := f.Listen(&net.UDPAddr{IP: lAddr.IP})
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{PacketConn: conn, remote: rAddr}, nil
+}
+
+func (f *Fabric) unbind(addr *net.UDPAddr) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.sockets, addr.String())
+}
+
+func (f *Fabric) send(src *net.UDPAddr, dst *net.UDPAddr, payload []byte) {
+
+	f.lock.Lock()
+	key := pathKey{src: src.IP.String(), dst: dst.IP.String()}
+	pa, ok := f.paths[key]
+	if !ok {
+		properties, ok := f.properties[key]
+		if !ok {
+			properties = f.defaults
+		}
+		pa = newPath(f, properties)
+		f.paths[key] = pa
+		go pa.serve()
+	}
+	properties := pa.properties
+	lost := f.random.Float64() < properties.Loss
+	reordered := f.random.Float64() < properties.Reorder
+	f.lock.Unlock()
+
+	if lost {
+		return
+	}
+
+	due := time.Now().Add(properties.Delay)
+	if reordered {
+		due = due.Add(properties.ReorderDelay)
+	}
+	pa.push(datagram{src: src, dst: dst, payload: payload, due: due})
+}
+
+func (f *Fabric) deliver(d datagram) {
+	f.lock.Lock()
+	conn, ok := f.sockets[d.dst.String()]
+	f.lock.Unlock()
+	if ok {
+		conn.receive(d)
+	}
+	// Like UDP, datagrams to unbound addresses are silently dropped.
+}
+
+type datagram struct {
+	src     *net.UDPAddr
+	dst     *net.UDPAddr
+	payload []byte
+	due     time.Time
+	seq     uint64
+}
+
+// A path delivers its datagrams in the order of their due time.
+type path struct {
+	fabric     *Fabric
+	properties PathProperties	// Guarded by the lock of the fabric.
+	pending    datagrams
+	seq        uint64
+	notify     chan struct{}
+	lock       sync.Mutex
+}
+
+func newPath(fabric *Fabric, properties PathProperties) *path {
+	return &path{
+		fabric:     fabric,
+		properties: properties,
+		pending:    make(datagrams, 0),
+		notify:     make(chan struct{}, 1),
+	}
+}
+
+func (pa *path) push(d datagram) {
+	pa.lock.Lock()
+	d.seq = pa.seq
+	pa.seq++
+	heap.Push(&pa.pending, d)
+	pa.lock.Unlock()
+
+	select {
+	case pa.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (pa *path) serve() {
+	for {
+		pa.lock.Lock()
+		if pa.pending.Len() == 0 {
+			pa.lock.Unlock()
+			select {
+			case <-pa.notify:
+				continue
+			case <-pa.fabric.done:
+				return
+			}
+		}
+		wait := time.Until(pa.pending[0].due)
+		if wait <= 0 {
+			d := heap.Pop(&pa.pending).(datagram)
+			pa.lock.Unlock()
+			pa.fabric.deliver(d)
+			continue
+		}
+		pa.lock.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-pa.notify:
+		case <-pa.fabric.done:
+			return
+		}
+	}
+}
+
+// Min-heap w.r.t. the due time, datagrams due at the same time keep their order.
+type datagrams []datagram
+
+func (d datagrams) Len() int { return len(d) }
+func (d datagrams) Less(i, j int) bool {
+	if d[i].due.Equal(d[j].due) {
+		return d[i].seq < d[j].seq
+	}
+	return d[i].due.Before(d[j].due)
+}
+func (d datagrams) Swap(i, j int)       { d[i], d[j] = d[j], d[i] }
+func (d *datagrams) Push(x interface{}) { *d = append(*d, x.(datagram)) }
+func (d *datagrams) Pop() interface{} {
+	old := *d
+	n := len(old)
+	x := old[n-1]
+	*d = old[:n-1]
+	return x
+}
+
+type Error string
+func (e Error) Error() string {
+	return string(e)
+}
+
+func (f *Fabric) String() string {
+	return fmt.Sprint("Loopback fabric (", len(f.sockets), " sockets)")
+}
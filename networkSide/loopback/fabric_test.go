@@ -0,0 +1,211 @@
+//
+package loopback
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	hostA      = net.ParseIP("10.0.0.1")
+	hostAPath2 = net.ParseIP("10.0.0.2") // Second address of host A, i.e. its second path.
+	hostB      = net.ParseIP("10.0.1.1")
+)
+
+func listen(t *testing.T, fabric *Fabric, ip net.IP, port int) *PacketConn {
+	conn, err := fabric.Listen(&net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func dial(t *testing.T, fabric *Fabric, local net.IP, remote *PacketConn) *Conn {
+	conn, err := fabric.Dial(&net.UDPAddr{IP: local}, remote.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func send(t *testing.T, conn *Conn, seq uint32) {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], seq)
+	if _, err := conn.Write(payload[:]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the sequence numbers received until nothing arrives for the given time.
+func receive(conn *PacketConn, quiet time.Duration) (seqs []uint32, srcs []string) {
+	buffer := make([]byte, 16)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(quiet))
+		n, src, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if n == 4 {
+			seqs = append(seqs, binary.BigEndian.Uint32(buffer[:4]))
+			srcs = append(srcs, src.(*net.UDPAddr).IP.String())
+		}
+	}
+}
+
+func TestPathDelay(t *testing.T) {
+
+	fabric := NewFabric(1, PathProperties{})
+	defer fabric.Close()
+	fabric.SetPathProperties(hostA, hostB, PathProperties{Delay: 100 * time.Millisecond})
+
+	server := listen(t, fabric, hostB, 9876)
+	slow := dial(t, fabric, hostA, server)
+	fast := dial(t, fabric, hostAPath2, server)
+
+	start := time.Now()
+	send(t, slow, 1)
+	send(t, fast, 2)
+
+	seqs, srcs := receive(server, 300 * time.Millisecond)
+	if len(seqs) != 2 || seqs[0] != 2 || seqs[1] != 1 {
+		t.Fatalf("Expected the datagram of the fast path first, received %v.", seqs)
+	}
+	if srcs[0] != hostAPath2.String() || srcs[1] != hostA.String() {
+		t.Fatalf("Unexpected sources %v.", srcs)
+	}
+	if elapsed := time.Since(start); elapsed < 100 * time.Millisecond {
+		t.Fatalf("Datagram of the slow path arrived after %v.", elapsed)
+	}
+}
+
+func TestPathDelayAppliesToBothDirections(t *testing.T) {
+
+	fabric := NewFabric(1, PathProperties{})
+	defer fabric.Close()
+	fabric.SetPathProperties(hostA, hostB, PathProperties{Delay: 100 * time.Millisecond})
+
+	server := listen(t, fabric, hostA, 9876)
+	client := dial(t, fabric, hostB, server)
+
+	start := time.Now()
+	send(t, client, 1)
+	if seqs, _ := receive(server, 300 * time.Millisecond); len(seqs) != 1 {
+		t.Fatalf("Expected a single datagram, received %v.", seqs)
+	}
+	if elapsed := time.Since(start); elapsed < 100 * time.Millisecond {
+		t.Fatalf("Datagram arrived after %v.", elapsed)
+	}
+}
+
+func TestPathLoss(t *testing.T) {
+
+	fabric := NewFabric(1, PathProperties{})
+	defer fabric.Close()
+	fabric.SetPathProperties(hostA, hostB, PathProperties{Loss: 1})
+
+	server := listen(t, fabric, hostB, 9876)
+	lossy := dial(t, fabric, hostA, server)
+	lossless := dial(t, fabric, hostAPath2, server)
+
+	for seq := uint32(0); seq < 100; seq++ {
+		send(t, lossy, seq)
+		send(t, lossless, seq)
+	}
+
+	seqs, srcs := receive(server, 100 * time.Millisecond)
+	if len(seqs) != 100 {
+		t.Fatalf("Expected 100 datagrams, received %d.", len(seqs))
+	}
+	for i, src := range srcs {
+		if src != hostAPath2.String() || seqs[i] != uint32(i) {
+			t.Fatalf("Unexpected datagram %d from %s.", seqs[i], src)
+		}
+	}
+}
+
+func TestPathPropertiesChangeWhileInUse(t *testing.T) {
+
+	fabric := NewFabric(1, PathProperties{})
+	defer fabric.Close()
+
+	server := listen(t, fabric, hostB, 9876)
+	client := dial(t, fabric, hostA, server)
+
+	send(t, client, 0)
+	if seqs, _ := receive(server, 50 * time.Millisecond); len(seqs) != 1 {
+		t.Fatalf("Expected 1 datagram, received %d.", len(seqs))
+	}
+
+	fabric.SetPathProperties(hostA, hostB, PathProperties{Loss: 1})
+	send(t, client, 1)
+	if seqs, _ := receive(server, 50 * time.Millisecond); len(seqs) != 0 {
+		t.Fatalf("Expected no datagram, received %d.", len(seqs))
+	}
+}
+
+func TestPathReordering(t *testing.T) {
+
+	fabric := NewFabric(1, PathProperties{})
+	defer fabric.Close()
+	fabric.SetPathProperties(hostA, hostB, PathProperties{Reorder: 0.3, ReorderDelay: 50 * time.Millisecond})
+
+	server := listen(t, fabric, hostB, 9876)
+	reordering := dial(t, fabric, hostA, server)
+	ordered := dial(t, fabric, hostAPath2, server)
+
+	for seq := uint32(0); seq < 100; seq++ {
+		send(t, reordering, seq)
+		send(t, ordered, 1000 + seq)
+	}
+
+	seqs, _ := receive(server, 200 * time.Millisecond)
+	if len(seqs) != 200 {
+		t.Fatalf("Reordering must not lose datagrams, received %d.", len(seqs))
+	}
+
+	var last uint32
+	reordered, next := 0, uint32(1000)
+	for _, seq := range seqs {
+		if seq >= 1000 {
+			if seq != next {
+				t.Fatalf("Datagram %d of the ordered path arrived out of order.", seq)
+			}
+			next++
+			continue
+		}
+		if seq < last {
+			reordered++
+		}
+		last = seq
+	}
+	if reordered == 0 {
+		t.Fatal("No datagram of the reordering path got reordered.")
+	}
+}
+
+func TestReproducible(t *testing.T) {
+
+	run := func() []uint32 {
+		fabric := NewFabric(42, PathProperties{Loss: 0.5})
+		defer fabric.Close()
+		server := listen(t, fabric, hostB, 9876)
+		client := dial(t, fabric, hostA, server)
+		for seq := uint32(0); seq < 100; seq++ {
+			send(t, client, seq)
+		}
+		seqs, _ := receive(server, 100 * time.Millisecond)
+		return seqs
+	}
+
+	first, second := run(), run()
+	if len(first) == 0 || len(first) == 100 || len(first) != len(second) {
+		t.Fatalf("Expected the same partial loss in both runs, received %d and %d datagrams.", len(first), len(second))
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Runs differ at datagram %d.", i)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+//
+package network
+
+import (
+	"fmt"
+	"net"
+	"shila/core/shila"
+	"strconv"
+)
+
+// Addresses used by the plain IP backbone.
+var _ shila.NetworkAddressGenerator = (*IPAddressGenerator)(nil)
+var _ shila.NetworkAddress 			= (*net.UDPAddr)(nil)
+
+type IPAddressGenerator struct {}
+
+func (g IPAddressGenerator) New(address string) (shila.NetworkAddress, error) {
+	if addr, err := net.ResolveUDPAddr("udp", address); err != nil {
+		return &net.UDPAddr{}, err
+	} else {
+		return addr, nil
+	}
+}
+
+func (g IPAddressGenerator) NewLocal(portStr string) (shila.NetworkAddress, error) {
+	if port, err := strconv.Atoi(portStr); err != nil {
+		return &net.UDPAddr{}, shila.PrependError(err, fmt.Sprint("Cannot parse port ", portStr, "."))
+	} else {
+		return &net.UDPAddr{Port: port}, nil
+	}
+}
+
+func (g IPAddressGenerator) NewEmpty() shila.NetworkAddress {
+	return &net.UDPAddr{}
+}
@@ -0,0 +1,41 @@
+//
+package network
+
+import (
+	"fmt"
+	"net"
+	"shila/core/shila"
+)
+
+// Paths used by the plain IP backbone. The host routes the datagrams by their destination, thus
+// the only choice left is the local address they are sent from, which selects the interface (and
+// possibly the next hop, given source based routing). The empty path leaves the choice to the host.
+var _ shila.NetworkPathGenerator = (*IPPathGenerator)(nil)
+var _ shila.NetworkPath 		 = (*IPPath)(nil)
+
+type IPPathGenerator struct {}
+
+func (g IPPathGenerator) New(path string) (shila.NetworkPath, error) {
+	if path == "" {
+		return IPPath{}, nil
+	}
+	if local := net.ParseIP(path); local != nil {
+		return IPPath{Local: local}, nil
+	}
+	return IPPath{}, shila.TolerableError(fmt.Sprint("Cannot parse IP path ", path, "."))
+}
+
+func (g IPPathGenerator) NewEmpty() shila.NetworkPath {
+	return IPPath{}
+}
+
+type IPPath struct {
+	Local net.IP		// Address the datagrams are sent from. (Nil if up to the host.)
+}
+
+func (p IPPath) String() string {
+	if p.Local == nil {
+		return ""
+	}
+	return fmt.Sprint("via ", p.Local)
+}
@@ -5,6 +5,8 @@ import "shila/core/shila"
 
 // Generator functionalities are thought to be used outside of the
 // backbone protocol specific implementations (suffix "Specific")
+// SCION paths are queried by the router rather than generated from strings,
+// for the paths of plain IP see ipPathSpecific.go.
 var _ shila.NetworkPathGenerator = (*PathGenerator)(nil)
 var _ shila.NetworkPath 		 = (*Path)(nil)
 
@@ -0,0 +1,98 @@
+//
+package networkEndpoint
+
+import (
+	"github.com/scionproto/scion/go/lib/snet"
+	"shila/config"
+	"shila/core/shila"
+	"sync"
+	"time"
+)
+
+const batchPayloadOverhead = 8		// Encoding of a single payload within the batch.
+
+// Coalesces payloads bound for the same backbone connection into a single payload message,
+// which is sent as soon as it is full or the flush delay since its first payload elapsed.
+type batch struct {
+	payloads [][]byte
+	size     int
+	maxSize  int
+	timer    *time.Timer
+	send     func(payloads [][]byte) error
+	onError  func(err error)					// Called if sending fails after the flush delay.
+	lock     sync.Mutex
+}
+
+func newBatch(path shila.NetworkPath, send func(payloads [][]byte) error, onError func(err error)) *batch {
+	return &batch{
+		payloads: make([][]byte, 0),
+		maxSize:  batchLimit(path),
+		send:     send,
+		onError:  onError,
+	}
+}
+
+func batchingEnabled() bool {
+	return config.Config.NetworkEndpoint.Batching
+}
+
+func (b *batch) add(payload []byte) error {
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Start a new batch if the payload does not fit anymore.
+	if len(b.payloads) > 0 && b.size + len(payload) + batchPayloadOverhead > b.maxSize {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+
+	b.payloads = append(b.payloads, payload)
+	b.size    += len(payload) + batchPayloadOverhead
+
+	if b.size >= b.maxSize {
+		return b.flush()
+	}
+	if len(b.payloads) == 1 {
+		delay := time.Duration(config.Config.NetworkEndpoint.BatchingFlushDelay) * time.Microsecond
+		b.timer = time.AfterFunc(delay, b.flushAfterDelay)
+	}
+	return nil
+}
+
+func (b *batch) flushAfterDelay() {
+	b.lock.Lock()
+	err := b.flush()
+	b.lock.Unlock()
+	if err != nil {
+		b.onError(err)
+	}
+}
+
+// Expects the lock to be held.
+func (b *batch) flush() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.payloads) == 0 {
+		return nil
+	}
+	payloads := b.payloads
+	b.payloads = make([][]byte, 0, len(payloads))
+	b.size = 0
+	return b.send(payloads)
+}
+
+// Maximal size (payload bytes) of a batch such that its datagram does not exceed the MTU of the path.
+// Without knowledge of the path, the batch is bounded by the configured datagram size.
+func batchLimit(path shila.NetworkPath) int {
+	limit := config.Config.NetworkEndpoint.BatchingMaxDatagramSize - backboneFramingOverhead()
+	if scionPath, ok := path.(snet.Path); ok && scionPath != nil && scionPath.MTU() > 0 {
+		if mtuLimit := int(scionPath.MTU()) - BackboneOverhead(scionPath); mtuLimit < limit {
+			limit = mtuLimit
+		}
+	}
+	return limit
+}
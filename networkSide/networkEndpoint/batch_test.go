@@ -0,0 +1,89 @@
+//
+package networkEndpoint
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"shila/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Sends small payloads over a local UDP socket, each within its own payload message or batched.
+// Reports the payloads and the datagrams per second.
+func BenchmarkSendUnbatched64(b *testing.B)  { benchmarkSend(b, false, 64) }
+func BenchmarkSendBatched64(b *testing.B)    { benchmarkSend(b, true, 64) }
+func BenchmarkSendUnbatched512(b *testing.B) { benchmarkSend(b, false, 512) }
+func BenchmarkSendBatched512(b *testing.B)   { benchmarkSend(b, true, 512) }
+
+func benchmarkSend(b *testing.B, batching bool, payloadSize int) {
+
+	receiver, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer receiver.Close()
+	sender, err := net.DialUDP("udp", nil, receiver.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer sender.Close()
+
+	// The receiver just drains the socket.
+	go func() {
+		buffer := make([]byte, 65536)
+		for {
+			if _, err := receiver.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	var datagrams int64
+	write := func(pyldMsg payloadMessage) error {
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(pyldMsg); err != nil {
+			return err
+		}
+		atomic.AddInt64(&datagrams, 1)
+		_, err := sender.Write(buffer.Bytes())
+		return err
+	}
+
+	config.Config.NetworkEndpoint.BatchingMaxDatagramSize = 1280
+	config.Config.NetworkEndpoint.BatchingFlushDelay = 200
+	egressBatch := newBatch(nil, func(payloads [][]byte) error {
+		return write(payloadMessage{ Payloads: payloads })
+	}, func(err error) {
+		b.Error(err)
+	})
+
+	payload := make([]byte, payloadSize)
+	b.SetBytes(int64(payloadSize))
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if batching {
+			err = egressBatch.add(payload)
+		} else {
+			err = write(payloadMessage{ Payload: payload })
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	// The last batch is not waited for.
+	egressBatch.lock.Lock()
+	err = egressBatch.flush()
+	egressBatch.lock.Unlock()
+	if err != nil {
+		b.Fatal(err)
+	}
+	elapsed := time.Since(start).Seconds()
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N) / elapsed, "payloads/s")
+	b.ReportMetric(float64(atomic.LoadInt64(&datagrams)) / elapsed, "datagrams/s")
+}
@@ -2,16 +2,18 @@
 package networkEndpoint
 
 import (
+	"bytes"
 	"encoding/gob"
 	"fmt"
-	"github.com/netsec-ethz/scion-apps/pkg/appnet"
 	"github.com/scionproto/scion/go/lib/snet"
-	"io"
 	"net"
+	"shila/capture"
 	"shila/config"
 	"shila/core/shila"
 	"shila/log"
 	"shila/measurements"
+	"shila/networkSide/security"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,14 +21,17 @@ var _ shila.NetworkClientEndpoint = (*Client)(nil)
 
 type Client struct {
 	Base
+	network         Network
 	key             shila.TCPFlowKey
-	rConn           *snet.Conn
+	rConn           net.Conn				// Either the plain connection or, if enabled, the secured one
 	tcpFlow         shila.TCPFlow
 	netFlow         shila.NetFlow
 	lAddrContactEnd shila.NetworkAddress 	// Just set for traffic client network endpoint
+	lastHeard       int64					// Time (unix ns) the peer was heard of the last time, accessed atomically
+	earlyPayloads   [][]byte				// Payloads received before the acknowledgment of the control message
 }
 
-func NewContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+func NewContactClient(network Network, rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
 	return &Client{
 		Base: 						Base{
 										Role:    shila.ContactNetworkEndpoint,
@@ -35,15 +40,17 @@ func NewContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlo
 										State:   shila.NewEntityState(),
 										Issues:  issues,
 									},
+		network: network,
+		key:     tcpFlow.Key(),
 		tcpFlow: tcpFlow,
 		netFlow: shila.NetFlow{Dst: rAddr, Path: path},
 	}
 }
 
-func NewTrafficClient(lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow,
+func NewTrafficClient(network Network, lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow,
 	issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
 
-	client := NewContactClient(rAddr, path, tcpFlow, issues)
+	client := NewContactClient(network, rAddr, path, tcpFlow, issues)
 
 	client.(*Client).Base.Role 		 = shila.TrafficNetworkEndpoint
 	client.(*Client).lAddrContactEnd = lAddrContactEnd
@@ -63,14 +70,17 @@ func (client *Client) SetupAndRun() (netFlow shila.NetFlow, err error) {
 		return
 	}
 
-	// Send the control message.
-	if err = client.sendControlMessage(); err != nil {
+	// Send the control message and wait until the server acknowledges it.
+	if err = client.handshake(); err != nil {
+		_ = client.rConn.Close()
 		return
 	}
 
 	// Start the ingress and egress machinery.
+	atomic.StoreInt64(&client.lastHeard, time.Now().UnixNano())
 	go client.serveIngress()
 	go client.serveEgress()
+	go client.serveHeartbeats()
 
 	client.State.Set(shila.Running)
 
@@ -79,18 +89,23 @@ func (client *Client) SetupAndRun() (netFlow shila.NetFlow, err error) {
 
 func (client *Client) establishConnection() (err error) {
 
-	scionAddr := client.netFlow.Dst.(*snet.UDPAddr) // FIXME: cast!
-
-	if client.netFlow.Path != nil {
-		scionPath := client.netFlow.Path.(snet.Path)	// FIXME: cast!
-		appnet.SetPath(scionAddr, scionPath)
-	}
-
-	client.rConn, err = appnet.DialAddr(scionAddr)
+	conn, err := client.network.Dial(client.netFlow.Dst, client.netFlow.Path)
 	if err != nil {
 		err = shila.PrependError(ConnectionError(err.Error()), "Cannot establish connection.")
 		return
 	}
+	client.rConn = conn
+
+	// If enabled, all datagrams are sealed. Without a key for the peer there is no connection.
+	if security.Enabled() {
+		session, errSession := security.NewInitiatorSession(client.netFlow.Dst)
+		if errSession != nil {
+			_ = conn.Close()
+			err = shila.PrependError(ConnectionError(errSession.Error()), "Cannot secure connection.")
+			return
+		}
+		client.rConn = security.NewConn(conn, session)
+	}
 
 	client.netFlow.Src = client.rConn.LocalAddr().(*net.UDPAddr)	// FIXME: cast!
 	log.Verbose.Print(client.Says("Established connection."))
@@ -130,6 +145,10 @@ func (client *Client) TrafficChannels() shila.PacketChannels {
 }
 
 func (client *Client) serveIngress() {
+	for _, payload := range client.earlyPayloads {
+		client.deliverIngress(payload)
+	}
+	client.earlyPayloads = nil
 	for {
 		var pyldMsg payloadMessage
 		if err := gob.NewDecoder(client.rConn).Decode(&pyldMsg); err != nil {
@@ -137,18 +156,50 @@ func (client *Client) serveIngress() {
 			// After an issue, we no longer serve ingress. Connection will shut down the client later.
 			return
 		}
-		if len(pyldMsg.Payload) == 0 {
-			// From time to to we get a zero payload packet...?
-			//log.Error.Println(client.Says("Received zero payload packet."))
+		atomic.StoreInt64(&client.lastHeard, time.Now().UnixNano())
+		if pyldMsg.Heartbeat != 0 {
+			// The echo of one of our heartbeats.
+			pathRTTs.add(client.netFlow.Dst, client.netFlow.Path, time.Since(time.Unix(0, pyldMsg.Heartbeat)))
+			continue
+		}
+		if pyldMsg.Attempt != 0 {
+			// A duplicate acknowledgment, the server acknowledges every retransmission of the control message.
+			continue
+		}
+		for _, payload := range pyldMsg.payloads() {
+			client.deliverIngress(payload)
 		}
-		client.Ingress <-  shila.NewPacket(client, client.tcpFlow, pyldMsg.Payload)
+	}
+}
 
+func (client *Client) deliverIngress(payload []byte) {
+	if len(payload) == 0 {
+		return
 	}
+	p := shila.NewPacket(client, client.tcpFlow, payload)
+	capture.Record(client, capture.Ingress, p)
+	client.Ingress <- p
 }
 
 func (client *Client) serveEgress() {
+
+	// If enabled, small payloads are coalesced into a single datagram.
+	var egressBatch *batch
+	if batchingEnabled() {
+		egressBatch = newBatch(client.netFlow.Path, client.sendBatchMessage, func(err error) {
+			go client.handleConnectionIssue(err)
+		})
+	}
+
 	for p := range client.Egress {
-		err := client.sendPayloadMessage(p.Payload)
+		capture.Record(client, capture.Egress, p)
+		var err error
+		if egressBatch != nil {
+			logEgressTimestamp(p.Payload)
+			err = egressBatch.add(p.Payload)
+		} else {
+			err = client.sendPayloadMessage(p.Payload)
+		}
 		if err != nil {
 			go client.handleConnectionIssue(err)
 			// After an issue, we no longer server egress. Connection will shut down the client later.
@@ -164,38 +215,142 @@ func (client *Client) sendPayloadMessage(payload []byte) error {
 		Payload: payload,
 	}
 
+	// ...probably create a timestamp for it..
+	logEgressTimestamp(payload)
+
+	//  ..and encode and send it.
+	return client.writePayloadMessage(pyldMsg)
+}
+
+func (client *Client) sendBatchMessage(payloads [][]byte) error {
+	return client.writePayloadMessage(payloadMessage{ Payloads: payloads })
+}
+
+func logEgressTimestamp(payload []byte) {
 	go func() {
-		// ...probably create a timestamp for it..
 		if config.Config.Logging.DoEgressTimestamping {
 			measurements.LogEgressTimestamp(payload)
 		}
 	}()
+}
 
-	//  ..and encode and send it.
-	if err := gob.NewEncoder(io.Writer(client.rConn)).Encode(pyldMsg); err != nil {
+// The heartbeats are sent concurrently to the payload, every message is therefore
+// encoded first and then sent within a single datagram.
+func (client *Client) writePayloadMessage(pyldMsg payloadMessage) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(pyldMsg); err != nil {
 		return shila.PrependError(err, "Cannot encode payload message.")
 	}
-
+	if _, err := client.rConn.Write(buffer.Bytes()); err != nil {
+		return err
+	}
 	return nil
 }
 
+// Sends a heartbeat every interval. If the server does not echo any of the heartbeats
+// (and does not send anything else) for the configured number of intervals, the peer
+// is considered dead and an issue is published.
+func (client *Client) serveHeartbeats() {
+
+	interval := time.Duration(config.Config.NetworkEndpoint.HeartbeatInterval) * time.Millisecond
+	if interval <= 0 {
+		return
+	}
+	timeout := interval * time.Duration(config.Config.NetworkEndpoint.HeartbeatMissThreshold)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if client.State.Not(shila.Running) {
+			return
+		}
+		if silence := time.Since(time.Unix(0, atomic.LoadInt64(&client.lastHeard))); silence > timeout {
+			go client.handleConnectionIssue(ConnectionError(fmt.Sprint("Peer not responding since ", silence, ".")))
+			return
+		}
+		if err := client.writePayloadMessage(payloadMessage{ Heartbeat: time.Now().UnixNano() }); err != nil {
+			go client.handleConnectionIssue(err)
+			return
+		}
+	}
+}
+
+
 
+func (client *Client) handshake() error {
 
-func (client *Client) sendControlMessage() error {
+	// The control message is sent over an unreliable connection, we therefore retransmit it
+	// (w/ exponential backoff) until the server acknowledges it or the attempt budget is exhausted.
+	timeout := time.Duration(config.Config.NetworkEndpoint.HandshakeTimeout) * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= config.Config.NetworkEndpoint.HandshakeAttempts; attempt++ {
+
+		if err = client.sendControlMessage(attempt); err != nil {
+			return err
+		}
+
+		if err = client.receiveControlAckMessage(timeout); err == nil {
+			log.Verbose.Print(client.Says(fmt.Sprint("Handshake done after ", attempt, " attempt(s).")))
+			return nil
+		}
+
+		log.Verbose.Print(client.Says(fmt.Sprint("No acknowledgment for control message (attempt ", attempt, "). ", err.Error())))
+		timeout *= 2
+	}
+
+	return shila.PrependError(ConnectionError(err.Error()), "Control message not acknowledged.")
+}
 
-	// Craft the control message, encode and send it.
+func (client *Client) sendControlMessage(attempt int) error {
+
+	// Craft the control message,..
 	var ctrlMsg controlMessage
 	if client.Role() == shila.ContactNetworkEndpoint {
-		ctrlMsg = controlMessage{TcpFlow: client.tcpFlow}
+		ctrlMsg = controlMessage{TcpFlow: client.tcpFlow, Attempt: attempt}
 	}
 	if client.Role() == shila.TrafficNetworkEndpoint {
-		ctrlMsg = controlMessage{TcpFlow: client.tcpFlow, LAddrContactEnd: *client.lAddrContactEnd.(*net.UDPAddr)}
+		ctrlMsg = controlMessage{TcpFlow: client.tcpFlow, LAddrContactEnd: *client.lAddrContactEnd.(*net.UDPAddr), Attempt: attempt}
 	}
 
-	if err := gob.NewEncoder(io.Writer(client.rConn)).Encode(ctrlMsg); err != nil {
+	// ..encode it and send it within a single datagram. If the datagram is lost, we lose
+	// the whole message and not just a part of it.
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(ctrlMsg); err != nil {
 		return shila.PrependError(err, "Cannot encode control message.")
 	}
-	
+	if _, err := client.rConn.Write(buffer.Bytes()); err != nil {
+		return shila.PrependError(ConnectionError(err.Error()), "Cannot send control message.")
+	}
+
+	return nil
+}
+
+func (client *Client) receiveControlAckMessage(timeout time.Duration) error {
+
+	if err := client.rConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer client.rConn.SetReadDeadline(time.Time{})
+
+	var reply handshakeReplyMessage
+	if err := gob.NewDecoder(client.rConn).Decode(&reply); err != nil {
+		return err
+	}
+
+	// The server sends payload just after it got the control message, the payload
+	// therefore acknowledges the control message as well.
+	if reply.Attempt == 0 {
+		payloads := payloadMessage{Payload: reply.Payload, Payloads: reply.Payloads}.payloads()
+		if len(payloads) == 1 && len(payloads[0]) == 0 {
+			return ParsingError("Received invalid acknowledgment.")
+		}
+		client.earlyPayloads = append(client.earlyPayloads, payloads...)
+		return nil
+	}
+	if reply.TcpFlow.Key() != client.tcpFlow.Key() {
+		return ParsingError("Received invalid acknowledgment.")
+	}
+
 	return nil
 }
 
@@ -205,10 +360,11 @@ func (client *Client) handleConnectionIssue(err error) {
 	time.Sleep(time.Second * time.Duration(config.Config.NetworkEndpoint.WaitingTimeAfterConnectionIssue))
 	if client.State.Is(shila.Running) {
 
+		// A single issue per failure, the op error carries the SCMP header.
 		if opErr, ok := err.(*snet.OpError); ok {
 			client.Issues <- shila.EndpointIssuePub{ Issuer: client, Key: client.Key(), Error: opErr }
+		} else {
+			client.Issues <- shila.EndpointIssuePub{ Issuer: client, Key: client.Key(), Error: ConnectionError(err.Error()) }
 		}
-
-		client.Issues <- shila.EndpointIssuePub{ Issuer: client, Key: client.Key(), Error: ConnectionError(err.Error()) }
 	}
 }
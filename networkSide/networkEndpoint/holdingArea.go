@@ -0,0 +1,145 @@
+//
+package networkEndpoint
+
+import (
+	"fmt"
+	"shila/config"
+	"shila/core/shila"
+	"sync"
+	"time"
+)
+
+// Packets for which a server network endpoint has no backbone connection yet are held
+// until the connection is established (it may take some time until the client on the other
+// side is ready) or until they expire. Every destination has its own, bounded queue.
+type holdingArea struct {
+	queues   map[string] *heldPackets
+	counters HoldingAreaCounters
+	lock     sync.Mutex
+}
+
+type HoldingAreaCounters struct {
+	Held     uint64		// Packets put into the holding area.
+	Released uint64		// Packets sent out after the backbone connection was established.
+	Expired  uint64		// Packets dropped because they were held for too long.
+	Dropped  uint64		// Packets dropped because the queue of the destination was full.
+}
+
+func (c HoldingAreaCounters) String() string {
+	return fmt.Sprint("held ", c.Held, ", released ", c.Released, ", expired ", c.Expired, ", dropped ", c.Dropped)
+}
+
+type heldPackets struct {
+	packets []heldPacket
+	bytes   int
+}
+
+type heldPacket struct {
+	packet *shila.Packet
+	since  time.Time
+}
+
+func newHoldingArea() *holdingArea {
+	return &holdingArea{ queues: make(map[string] *heldPackets) }
+}
+
+// Returns false if the packet was dropped since the queue of the destination is full.
+func (h *holdingArea) hold(key string, packet *shila.Packet) bool {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	queue, ok := h.queues[key]
+	if !ok {
+		queue = &heldPackets{ packets: make([]heldPacket, 0) }
+		h.queues[key] = queue
+	}
+
+	if len(queue.packets) >= config.Config.NetworkEndpoint.HoldingAreaMaxPackets ||
+		queue.bytes + len(packet.Payload) > config.Config.NetworkEndpoint.HoldingAreaMaxBytes {
+		h.counters.Dropped++
+		return false
+	}
+
+	queue.packets = append(queue.packets, heldPacket{ packet: packet, since: time.Now() })
+	queue.bytes  += len(packet.Payload)
+	h.counters.Held++
+	return true
+}
+
+// Removes and returns the packets held for the destination, in the order they were held.
+func (h *holdingArea) release(key string) []*shila.Packet {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	queue, ok := h.queues[key]
+	if !ok {
+		return nil
+	}
+	delete(h.queues, key)
+
+	packets := make([]*shila.Packet, 0, len(queue.packets))
+	for _, held := range queue.packets {
+		packets = append(packets, held.packet)
+	}
+	h.counters.Released += uint64(len(packets))
+	return packets
+}
+
+// Removes and returns the packets which are held longer than the holding time.
+func (h *holdingArea) expire() []*shila.Packet {
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	deadline := time.Now().Add(-holdingTime())
+	expired  := make([]*shila.Packet, 0)
+	for key, queue := range h.queues {
+		// The packets are ordered by the time they were held.
+		n := 0
+		for n < len(queue.packets) && queue.packets[n].since.Before(deadline) {
+			expired = append(expired, queue.packets[n].packet)
+			queue.bytes -= len(queue.packets[n].packet.Payload)
+			n++
+		}
+		queue.packets = queue.packets[n:]
+		if len(queue.packets) == 0 {
+			delete(h.queues, key)
+		}
+	}
+	h.counters.Expired += uint64(len(expired))
+	return expired
+}
+
+func (h *holdingArea) Counters() HoldingAreaCounters {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.counters
+}
+
+// The counters are logged every stats interval, if they changed since.
+func holdingAreaLogInterval() time.Duration {
+	return time.Duration(config.Config.Logging.StatsInterval) * time.Second
+}
+
+func holdingTime() time.Duration {
+	return time.Duration(config.Config.NetworkEndpoint.HoldingTime) * time.Millisecond
+}
+
+// The holding area is checked for expired packets a few times per holding time.
+func holdingAreaCheckInterval() time.Duration {
+	if interval := holdingTime() / 4; interval > time.Millisecond {
+		return interval
+	}
+	return time.Millisecond
+}
+
+// Every tcp flow is reported just once, even if several of its packets expired.
+func expiredTCPFlows(packets []*shila.Packet) map[shila.TCPFlowKey] bool {
+	keys := make(map[shila.TCPFlowKey] bool)
+	for _, p := range packets {
+		keys[p.Flow.TCPFlow.Key()] = true
+	}
+	return keys
+}
@@ -9,8 +9,34 @@ type controlMessage struct {
 	TcpFlow         shila.TCPFlow
 	LAddrContactEnd net.UDPAddr
 	Payload         []byte
+	Attempt         int 			// Transmission attempt of the control message, starts at one.
+}
+
+// Sent by the server network endpoint to acknowledge a received control message.
+type controlAckMessage struct {
+	TcpFlow         shila.TCPFlow
+	Attempt         int
+}
+
+// What a client receives while waiting for the acknowledgment of its control message. If the
+// datagrams got reordered, this is already a payload message of the server.
+type handshakeReplyMessage struct {
+	TcpFlow         shila.TCPFlow
+	Attempt         int
+	Payload         []byte
+	Payloads        [][]byte
 }
 
 type payloadMessage struct {
-	Payload []byte
-}
\ No newline at end of file
+	Payload   []byte
+	Attempt   int 					// Just set if the message is actually a retransmitted control message.
+	Heartbeat int64					// Just set for heartbeats, the time (unix ns) the client sent it. Echoed by the server.
+	Payloads  [][]byte				// Just set for a batch of payloads, replaces the single payload.
+}
+
+func (pyldMsg payloadMessage) payloads() [][]byte {
+	if len(pyldMsg.Payloads) > 0 {
+		return pyldMsg.Payloads
+	}
+	return [][]byte{ pyldMsg.Payload }
+}
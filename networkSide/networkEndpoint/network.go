@@ -0,0 +1,135 @@
+//
+package networkEndpoint
+
+import (
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/scionproto/scion/go/lib/snet"
+	"net"
+	"shila/core/shila"
+	"shila/networkSide/loopback"
+	"shila/networkSide/network"
+)
+
+// The UDP backbone connections run either over SCION or over plain IP. Network hides
+// the differences between the two from the client and the server network endpoints.
+type Network interface {
+	Dial(rAddr shila.NetworkAddress, path shila.NetworkPath) (net.Conn, error)
+	Listen(lAddr shila.NetworkAddress) (net.PacketConn, error)
+	Path(addr shila.NetworkAddress) shila.NetworkPath						// Path along which the address is reached.
+	WithPort(addr shila.NetworkAddress, port int) shila.NetworkAddress		// Copy of the address w/ the given port.
+	WithHost(addr shila.NetworkAddress, host net.UDPAddr) shila.NetworkAddress	// Copy of the address w/ the given host.
+}
+
+var _ Network = (*SCIONNetwork)(nil)
+var _ Network = (*IPNetwork)(nil)
+var _ Network = (*LoopbackNetwork)(nil)
+
+type SCIONNetwork struct{}
+
+func (n SCIONNetwork) Dial(rAddr shila.NetworkAddress, path shila.NetworkPath) (net.Conn, error) {
+
+	scionAddr := rAddr.(*snet.UDPAddr) 				// FIXME: cast!
+	if path != nil {
+		appnet.SetPath(scionAddr, path.(snet.Path))	// FIXME: cast!
+	}
+
+	conn, err := appnet.DialAddr(scionAddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n SCIONNetwork) Listen(lAddr shila.NetworkAddress) (net.PacketConn, error) {
+	conn, err := appnet.Listen(lAddr.(*snet.UDPAddr).Host)	// FIXME: cast!
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n SCIONNetwork) Path(addr shila.NetworkAddress) shila.NetworkPath {
+	return addr.(*snet.UDPAddr).Path.Copy()
+}
+
+func (n SCIONNetwork) WithPort(addr shila.NetworkAddress, port int) shila.NetworkAddress {
+	addrCopy := addr.(*snet.UDPAddr).Copy()
+	addrCopy.Host.Port = port
+	return addrCopy
+}
+
+func (n SCIONNetwork) WithHost(addr shila.NetworkAddress, host net.UDPAddr) shila.NetworkAddress {
+	addrCopy := addr.(*snet.UDPAddr).Copy()
+	addrCopy.Host = &host
+	return addrCopy
+}
+
+// The path of plain IP is the local address the datagrams are sent from.
+type IPNetwork struct{}
+
+func (n IPNetwork) Dial(rAddr shila.NetworkAddress, path shila.NetworkPath) (net.Conn, error) {
+	var lAddr *net.UDPAddr
+	if ipPath, ok := path.(network.IPPath); ok && ipPath.Local != nil {
+		lAddr = &net.UDPAddr{IP: ipPath.Local}
+	}
+	conn, err := net.DialUDP("udp", lAddr, rAddr.(*net.UDPAddr))	// FIXME: cast!
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n IPNetwork) Listen(lAddr shila.NetworkAddress) (net.PacketConn, error) {
+	conn, err := net.ListenUDP("udp", lAddr.(*net.UDPAddr))		// FIXME: cast!
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n IPNetwork) Path(addr shila.NetworkAddress) shila.NetworkPath {
+	_ = addr
+	return nil
+}
+
+func (n IPNetwork) WithPort(addr shila.NetworkAddress, port int) shila.NetworkAddress {
+	addrCopy := *addr.(*net.UDPAddr)
+	addrCopy.Port = port
+	return &addrCopy
+}
+
+func (n IPNetwork) WithHost(addr shila.NetworkAddress, host net.UDPAddr) shila.NetworkAddress {
+	_ = addr
+	return &host
+}
+
+// In-process network, the datagrams never leave the process.
+type LoopbackNetwork struct {
+	IPNetwork
+	Fabric *loopback.Fabric
+	IP     net.IP					// Address of the host, used if no (or an unspecified) local address is given.
+}
+
+func (n LoopbackNetwork) Dial(rAddr shila.NetworkAddress, path shila.NetworkPath) (net.Conn, error) {
+	lAddr := &net.UDPAddr{IP: n.IP}
+	if ipPath, ok := path.(network.IPPath); ok && ipPath.Local != nil {
+		lAddr.IP = ipPath.Local
+	}
+	conn, err := n.Fabric.Dial(lAddr, rAddr.(*net.UDPAddr))	// FIXME: cast!
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (n LoopbackNetwork) Listen(lAddr shila.NetworkAddress) (net.PacketConn, error) {
+	lAddress := *lAddr.(*net.UDPAddr)										// FIXME: cast!
+	if lAddress.IP == nil || lAddress.IP.IsUnspecified() {
+		lAddress.IP = n.IP
+	}
+	conn, err := n.Fabric.Listen(&lAddress)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,29 @@
+//
+package networkEndpoint
+
+import (
+	"github.com/scionproto/scion/go/lib/snet"
+	"shila/config"
+)
+
+// Headers wrapped around a datagram on the backbone, besides the path header.
+const (
+	scionCommonHeaderLen  = 12
+	scionAddressHeaderLen = 2 * 8 + 2 * 16		// ISD-AS of both ends and host addresses (up to IPv6).
+	udpHeaderLen          = 8
+)
+
+// BackboneOverhead returns the bytes added to a payload sent along the path, i.e. the SCION headers
+// (incl. the path header), the UDP header and the framing of the backbone connection.
+func BackboneOverhead(path snet.Path) int {
+	pathHeaderLen := 0
+	if spath := path.Path(); spath != nil {
+		pathHeaderLen = len(spath.Raw)
+	}
+	return scionCommonHeaderLen + scionAddressHeaderLen + pathHeaderLen + udpHeaderLen + backboneFramingOverhead()
+}
+
+// Bytes added by the encoding of the messages and, if enabled, their security header.
+func backboneFramingOverhead() int {
+	return config.Config.Router.BackboneFramingOverhead
+}
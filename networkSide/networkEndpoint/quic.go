@@ -0,0 +1,217 @@
+//
+package networkEndpoint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/scionproto/scion/go/lib/snet"
+	"math/big"
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/networkSide/network"
+	"strings"
+	"sync"
+	"time"
+)
+
+const quicProtocol = "shila"
+
+// All QUIC client network endpoints towards the same peer along the same path share one session,
+// every client network endpoint (i.e. every TCP flow) uses its own stream within this session.
+// A session is dialed w/o holding the lock, the client network endpoints towards the same peer and
+// path wait for the pending dial, the ones towards other peers are not held up.
+type quicSessions struct {
+	sessions map[string] *quicClientSession
+	dialing  map[string] *quicDial
+	lock     sync.Mutex
+}
+
+type quicClientSession struct {
+	key     string
+	session quic.Session
+	conn    *snet.Conn
+	users   int
+}
+
+// A pending dial, done is closed once the session is in the pool or the dial failed.
+type quicDial struct {
+	done chan struct{}
+	err  error
+}
+
+var clientSessions = quicSessions{
+	sessions: make(map[string] *quicClientSession),
+	dialing:  make(map[string] *quicDial),
+}
+
+func (s *quicSessions) acquire(rAddr *snet.UDPAddr, path shila.NetworkPath) (*quicClientSession, error) {
+
+	key := fmt.Sprint(rAddr, "|", getPathKey(path))
+
+	s.lock.Lock()
+	for {
+		if clientSession, ok := s.sessions[key]; ok {
+			clientSession.users++
+			s.lock.Unlock()
+			return clientSession, nil
+		}
+		pending, ok := s.dialing[key]
+		if !ok {
+			break
+		}
+		s.lock.Unlock()
+		<-pending.done
+		if pending.err != nil {
+			return nil, pending.err
+		}
+		s.lock.Lock()
+	}
+	pending := &quicDial{done: make(chan struct{})}
+	s.dialing[key] = pending
+	s.lock.Unlock()
+
+	clientSession, err := dialSession(key, rAddr, path)
+
+	s.lock.Lock()
+	delete(s.dialing, key)
+	if err != nil {
+		pending.err = err
+	} else {
+		s.sessions[key] = clientSession
+	}
+	s.lock.Unlock()
+	close(pending.done)
+
+	return clientSession, err
+}
+
+func dialSession(key string, rAddr *snet.UDPAddr, path shila.NetworkPath) (*quicClientSession, error) {
+
+	rAddr = rAddr.Copy()
+	if path != nil {
+		appnet.SetPath(rAddr, path.(snet.Path)) 	// FIXME: cast!
+	}
+
+	conn, err := appnet.Listen(&net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	session, err := quic.Dial(conn, rAddr, quicProtocol, clientTLSConfig(), quicConfig())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	log.Verbose.Print("Established QUIC session ", key, ".")
+	return &quicClientSession{key: key, session: session, conn: conn, users: 1}, nil
+}
+
+func (s *quicSessions) release(clientSession *quicClientSession) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	clientSession.users--
+	if clientSession.users > 0 {
+		return
+	}
+
+	// Session might be already replaced by a new one if it was discarded.
+	if current, ok := s.sessions[clientSession.key]; ok && current == clientSession {
+		delete(s.sessions, clientSession.key)
+	}
+	_ = clientSession.session.Close()
+	_ = clientSession.conn.Close()
+	log.Verbose.Print("Closed QUIC session ", clientSession.key, ".")
+}
+
+// Removes a broken session from the pool, such that the next client network endpoint dials a new one.
+// The session itself is closed as soon as the last user releases it.
+func (s *quicSessions) discard(clientSession *quicClientSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if current, ok := s.sessions[clientSession.key]; ok && current == clientSession {
+		delete(s.sessions, clientSession.key)
+	}
+}
+
+func getPathKey(path shila.NetworkPath) string {
+	if ipPath, ok := path.(network.IPPath); ok {
+		return ipPath.String()
+	}
+	scionPath, ok := path.(snet.Path)
+	if !ok || scionPath == nil {
+		return ""
+	}
+	hops := make([]string, 0, len(scionPath.Interfaces()))
+	for _, intf := range scionPath.Interfaces() {
+		hops = append(hops, fmt.Sprint(intf.IA(), "#", intf.ID()))
+	}
+	return strings.Join(hops, ">")
+}
+
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		KeepAlive: true,
+	}
+}
+
+// The peers are not authenticated through TLS, the QUIC connection just provides the transport.
+// Therefore the network side refuses the QUIC backbone if security is enabled.
+func clientTLSConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quicProtocol},
+	}
+}
+
+func serverTLSConfig() (*tls.Config, error) {
+
+	var certificate tls.Certificate
+	var err error
+	if config.Config.NetworkEndpoint.QUICCertificatePath != "" {
+		certificate, err = tls.LoadX509KeyPair(config.Config.NetworkEndpoint.QUICCertificatePath,
+			config.Config.NetworkEndpoint.QUICKeyPath)
+	} else {
+		certificate, err = generateCertificate()
+	}
+	if err != nil {
+		return nil, shila.PrependError(err, "Unable to load certificate.")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{quicProtocol},
+	}, nil
+}
+
+// Generates a self signed certificate, used if there is none configured.
+func generateCertificate() (tls.Certificate, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: quicProtocol},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, nil
+}
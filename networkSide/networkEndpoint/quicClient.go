@@ -0,0 +1,194 @@
+//
+package networkEndpoint
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/scionproto/scion/go/lib/snet"
+	"net"
+	"shila/capture"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/measurements"
+	"time"
+)
+
+var _ shila.NetworkClientEndpoint = (*QUICClient)(nil)
+
+type QUICClient struct {
+	Base
+	key             shila.TCPFlowKey
+	session         *quicClientSession
+	stream          quic.Stream
+	encoder         *gob.Encoder			// One encoder per stream, the server decodes the stream as a whole
+	tcpFlow         shila.TCPFlow
+	netFlow         shila.NetFlow
+	lAddrContactEnd shila.NetworkAddress 	// Just set for traffic client network endpoint
+}
+
+func NewQUICContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return &QUICClient{
+		Base: 						Base{
+										Role:    shila.ContactNetworkEndpoint,
+										Ingress: make(chan *shila.Packet, config.Config.NetworkEndpoint.SizeIngressBuffer),
+										Egress:  make(chan *shila.Packet, config.Config.NetworkEndpoint.SizeEgressBuffer),
+										State:   shila.NewEntityState(),
+										Issues:  issues,
+									},
+		key:     tcpFlow.Key(),
+		tcpFlow: tcpFlow,
+		netFlow: shila.NetFlow{Dst: rAddr, Path: path},
+	}
+}
+
+func NewQUICTrafficClient(lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow,
+	issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+
+	client := NewQUICContactClient(rAddr, path, tcpFlow, issues)
+
+	client.(*QUICClient).Base.Role 		 = shila.TrafficNetworkEndpoint
+	client.(*QUICClient).lAddrContactEnd = lAddrContactEnd
+
+	return client
+}
+
+func (client *QUICClient) SetupAndRun() (netFlow shila.NetFlow, err error) {
+
+	if client.State.Not(shila.Uninitialized) {
+		err = shila.CriticalError(fmt.Sprint("Entity in wrong State ", client.State, "."))
+		return
+	}
+
+	// Open a stream within the (possibly shared) session.
+	if err = client.establishStream(); err != nil {
+		return
+	}
+
+	// Send the control message. The stream is reliable, there is no need for an acknowledgment.
+	if err = client.sendControlMessage(); err != nil {
+		_ = client.stream.Close()
+		clientSessions.release(client.session)
+		return
+	}
+
+	// Start the ingress and egress machinery.
+	go client.serveIngress()
+	go client.serveEgress()
+
+	client.State.Set(shila.Running)
+
+	return client.netFlow, nil
+}
+
+func (client *QUICClient) establishStream() (err error) {
+
+	scionAddr := client.netFlow.Dst.(*snet.UDPAddr) // FIXME: cast!
+
+	if client.session, err = clientSessions.acquire(scionAddr, client.netFlow.Path); err != nil {
+		return shila.PrependError(ConnectionError(err.Error()), "Cannot establish session.")
+	}
+
+	if client.stream, err = client.session.session.OpenStreamSync(); err != nil {
+		clientSessions.discard(client.session)
+		clientSessions.release(client.session)
+		return shila.PrependError(ConnectionError(err.Error()), "Cannot open stream.")
+	}
+
+	client.encoder    = gob.NewEncoder(client.stream)
+	client.netFlow.Src = client.session.conn.LocalAddr().(*net.UDPAddr)	// FIXME: cast!
+	log.Verbose.Print(client.Says("Established stream."))
+
+	return
+}
+
+func (client *QUICClient) TearDown() error {
+
+	client.State.Set(shila.TornDown)
+
+	err := client.stream.Close() 			// Close the stream (stops the Ingress processing)
+	clientSessions.release(client.session)  // Closes the session if it is no longer used
+	close(client.Ingress)               	// Close the Ingress channel (Working side no longer processes this endpoint)
+
+	log.Verbose.Print(client.Says("Got torn down."))
+	return err
+}
+
+func (client *QUICClient) Role() shila.EndpointRole {
+	return client.Base.Role
+}
+
+func (client *QUICClient) Identifier() string {
+	return fmt.Sprint("QUIC Client ", client.Role(), " (", client.netFlow.Src, " -> ", client.netFlow.Dst, ")")
+}
+
+func (client *QUICClient) Says(str string) string {
+	return  fmt.Sprint(client.Identifier(), ": ", str)
+}
+
+func (client *QUICClient) Key() shila.TCPFlowKey {
+	return client.key
+}
+
+func (client *QUICClient) TrafficChannels() shila.PacketChannels {
+	return shila.PacketChannels{Ingress: client.Ingress, Egress: client.Egress}
+}
+
+func (client *QUICClient) serveIngress() {
+	decoder := gob.NewDecoder(client.stream)
+	for {
+		var pyldMsg payloadMessage
+		if err := decoder.Decode(&pyldMsg); err != nil {
+			go client.handleConnectionIssue(err)
+			// After an issue, we no longer serve ingress. Connection will shut down the client later.
+			return
+		}
+		p := shila.NewPacket(client, client.tcpFlow, pyldMsg.Payload)
+		capture.Record(client, capture.Ingress, p)
+		client.Ingress <- p
+	}
+}
+
+func (client *QUICClient) serveEgress() {
+	for p := range client.Egress {
+
+		capture.Record(client, capture.Egress, p)
+
+		go func(payload []byte) {
+			if config.Config.Logging.DoEgressTimestamping {
+				measurements.LogEgressTimestamp(payload)
+			}
+		}(p.Payload)
+
+		if err := client.encoder.Encode(payloadMessage{Payload: p.Payload}); err != nil {
+			go client.handleConnectionIssue(shila.PrependError(err, "Cannot encode payload message."))
+			// After an issue, we no longer server egress. Connection will shut down the client later.
+			return
+		}
+	}
+}
+
+func (client *QUICClient) sendControlMessage() error {
+
+	ctrlMsg := controlMessage{TcpFlow: client.tcpFlow, Attempt: 1}
+	if client.Role() == shila.TrafficNetworkEndpoint {
+		ctrlMsg.LAddrContactEnd = *client.lAddrContactEnd.(*net.UDPAddr)
+	}
+
+	if err := client.encoder.Encode(ctrlMsg); err != nil {
+		return shila.PrependError(ConnectionError(err.Error()), "Cannot encode control message.")
+	}
+
+	return nil
+}
+
+func (client *QUICClient) handleConnectionIssue(err error) {
+
+	// Wait a little bit - maybe the client is going to die anyway.
+	time.Sleep(time.Second * time.Duration(config.Config.NetworkEndpoint.WaitingTimeAfterConnectionIssue))
+	if client.State.Is(shila.Running) {
+		clientSessions.discard(client.session)
+		client.Issues <- shila.EndpointIssuePub{ Issuer: client, Key: client.Key(), Error: ConnectionError(err.Error()) }
+	}
+}
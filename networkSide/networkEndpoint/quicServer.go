@@ -0,0 +1,373 @@
+//
+package networkEndpoint
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/lucas-clemente/quic-go"
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/scionproto/scion/go/lib/snet"
+	"shila/capture"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/measurements"
+	"shila/networkSide/security"
+	"sync"
+	"time"
+)
+
+var _ shila.NetworkServerEndpoint = (*QUICServer)(nil)
+
+type QUICServer struct {
+	Base
+	key         shila.NetworkAddressKey
+	lAddress    shila.NetworkAddress
+	lConnection *snet.Conn
+	listener    quic.Listener
+	streams     map[shila.TCPFlowKey] *quicServerStream
+	active      map[quic.Stream] bool		// The streams served, registered or not. Guarded by the lock.
+	workers     sync.WaitGroup				// The workers serving the streams, they are the senders on the ingress channel.
+	closed      bool						// Set on tear down, no new streams are served afterwards. Guarded by the lock.
+	stop        chan struct{}				// Closed on tear down, releases the workers blocked on a full ingress channel.
+	lock        sync.Mutex
+	holdingArea *holdingArea
+}
+
+// Every stream carries the traffic of exactly one tcp flow.
+type quicServerStream struct {
+	stream  quic.Stream
+	encoder *gob.Encoder
+	tcpFlow shila.TCPFlow
+	netFlow shila.NetFlow 	// Net flow which is represented by this stream.
+}
+
+func NewQUICServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
+	return &QUICServer{
+		Base: 			Base{
+							Role:    role,
+							Ingress: make(shila.PacketChannel, config.Config.NetworkEndpoint.SizeIngressBuffer),
+							Egress:  make(shila.PacketChannel, config.Config.NetworkEndpoint.SizeEgressBuffer),
+							State:   shila.NewEntityState(),
+							Issues:  issues,
+						},
+		key:			shila.GetNetworkAddressKey(lAddr),
+		lAddress:		lAddr,
+		streams:		make(map[shila.TCPFlowKey] *quicServerStream),
+		active:			make(map[quic.Stream] bool),
+		stop:			make(chan struct{}),
+		holdingArea:	newHoldingArea(),
+	}
+}
+
+func (server *QUICServer) SetupAndRun() (err error) {
+
+	if server.State.Not(shila.Uninitialized) {
+		return shila.CriticalError(server.Says(fmt.Sprint("In wrong State ", server.State, ".")))
+	}
+
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	// Connection to listen for incoming sessions.
+	server.lConnection, err = appnet.Listen(server.lAddress.(*snet.UDPAddr).Host)
+	if err != nil {
+		return shila.PrependError(ConnectionError(err.Error()), "Unable to setup listener.")
+	}
+	server.listener, err = quic.Listen(server.lConnection, tlsConfig, quicConfig())
+	if err != nil {
+		_ = server.lConnection.Close()
+		return shila.PrependError(ConnectionError(err.Error()), "Unable to setup listener.")
+	}
+
+	go server.serveSessions() 		// Start listening for incoming sessions.
+	go server.serveEgress()  		// Start handling incoming packets.
+	go server.expireHeldPackets() 	// Start dropping packets held for too long
+	go server.logHoldingArea() 		// Start logging the statistics of the packets held
+
+	server.State.Set(shila.Running)
+	log.Verbose.Print(server.Says("Setup and running."))
+	return
+}
+
+func (server *QUICServer) TearDown() (err error) {
+
+	server.State.Set(shila.TornDown)
+	close(server.stop)
+
+	err = server.listener.Close()		// Server no longer accepts incoming sessions.
+
+	// Ends the pending reads of the workers.
+	server.lock.Lock()
+	server.closed = true
+	for stream := range server.active {
+		_ = stream.SetReadDeadline(time.Now())
+		_ = stream.Close()
+	}
+	server.streams = make(map[shila.TCPFlowKey] *quicServerStream)
+	server.lock.Unlock()
+
+	err = server.lConnection.Close()	// Terminates all existing sessions.
+	server.workers.Wait()
+	close(server.Ingress) 				// Close the Ingress channel (Working side no longer processes this endpoint)
+
+	log.Verbose.Print(server.Says(fmt.Sprint("Got torn down. Holding area: ", server.holdingArea.Counters(), ".")))
+	return err
+}
+
+func (server *QUICServer) TrafficChannels() shila.PacketChannels {
+	return shila.PacketChannels{Ingress: server.Ingress, Egress: server.Egress}
+}
+
+func (server *QUICServer) Role() shila.EndpointRole {
+	return server.Base.Role
+}
+
+func (server *QUICServer) Identifier() string {
+	return fmt.Sprint("QUIC Server ", server.Role(), " (", server.lAddress, " <- *)")
+}
+
+func (server *QUICServer) Says(str string) string {
+	return  fmt.Sprint(server.Identifier(), ": ", str)
+}
+
+func (server *QUICServer) Key() shila.NetworkAddressKey {
+	return server.key
+}
+
+func (server *QUICServer) serveSessions() {
+	for {
+		session, err := server.listener.Accept()
+		if err != nil {
+			go server.handleConnectionIssue(err)
+			return
+		}
+		go server.serveSession(session)
+	}
+}
+
+func (server *QUICServer) serveSession(session quic.Session) {
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			// Session is closed, all its streams are terminated as well.
+			return
+		}
+		server.lock.Lock()
+		if server.closed {
+			server.lock.Unlock()
+			_ = stream.Close()
+			return
+		}
+		server.active[stream] = true
+		server.workers.Add(1)
+		server.lock.Unlock()
+		go server.serveStream(session, stream)
+	}
+}
+
+func (server *QUICServer) serveStream(session quic.Session, stream quic.Stream) {
+
+	defer func() {
+		server.lock.Lock()
+		delete(server.active, stream)
+		server.lock.Unlock()
+		server.workers.Done()
+	}()
+
+	decoder := gob.NewDecoder(stream)
+
+	// The first message should be a control message which contains
+	// all the information necessary to setup the stream.
+	var ctrlMsg controlMessage
+	if err := decoder.Decode(&ctrlMsg); err != nil {
+		log.Error.Println(server.Says(shila.PrependError(ParsingError("Failed to decode control message."), err.Error()).Error()))
+		_ = stream.Close()
+		return
+	}
+
+	if server.Role() == shila.ContactNetworkEndpoint {
+		if err := security.AdmitContact(session.RemoteAddr(), ctrlMsg.TcpFlow); err != nil {
+			_ = stream.Close()
+			return
+		}
+	}
+
+	s := server.newStream(session, stream, ctrlMsg)
+	key := s.tcpFlow.Key()
+
+	// The packets waiting for this stream are sent out right away, ahead of the later ones.
+	if err := server.registerStream(key, s); err != nil {
+		_ = stream.Close()
+		go server.handleStreamIssue(key, err)
+		return
+	}
+
+	log.Verbose.Println(server.Says(fmt.Sprint("Accepted stream for ", s.tcpFlow.String(), ".")))
+
+	// Now we are ready to listen for and process payload.
+	for {
+		var pyldMsg payloadMessage
+		if err := decoder.Decode(&pyldMsg); err != nil {
+			server.removeStream(key, s)
+			return
+		}
+
+		go func(payload []byte) {
+			if config.Config.Logging.DoIngressTimestamping {
+				measurements.LogIngressTimestamp(payload)
+			}
+		}(pyldMsg.Payload)
+
+		p := shila.NewPacketWithNetFlowAndKind(server, s.tcpFlow.Swap(), s.netFlow.Swap(), pyldMsg.Payload)
+		capture.Record(server, capture.Ingress, p)
+		select {
+		case server.Ingress <- p:
+		case <-server.stop:
+			return
+		}
+	}
+}
+
+// Sends the packets held for the stream and registers it afterwards, such that the egress worker sends
+// the later packets through it. The egress worker holds the packets of a flow w/o stream while holding
+// the lock, the ones held in the meantime are sent before the stream is registered as well.
+func (server *QUICServer) registerStream(key shila.TCPFlowKey, s *quicServerStream) error {
+	for {
+		server.lock.Lock()
+		if server.closed {
+			server.lock.Unlock()
+			return ConnectionError("Server got torn down.")
+		}
+		held := server.holdingArea.release(string(key))
+		if len(held) == 0 {
+			server.streams[key] = s
+			server.lock.Unlock()
+			return nil
+		}
+		server.lock.Unlock()
+
+		for _, p := range held {
+			capture.Record(server, capture.Egress, p)
+			if err := s.encoder.Encode(payloadMessage{Payload: p.Payload}); err != nil {
+				return shila.PrependError(err, "Unable to send held packet.")
+			}
+		}
+	}
+}
+
+func (server *QUICServer) newStream(session quic.Session, stream quic.Stream, ctrlMsg controlMessage) *quicServerStream {
+
+	rAddress := session.RemoteAddr().(*snet.UDPAddr) // FIXME: cast!
+
+	s := &quicServerStream{
+		stream:  stream,
+		encoder: gob.NewEncoder(stream),
+		tcpFlow: ctrlMsg.TcpFlow.Swap(),
+		netFlow: shila.NetFlow{
+			Src:  server.lAddress.(*snet.UDPAddr).Copy(),
+			Path: rAddress.Path.Copy(),
+			Dst:  rAddress,
+		},
+	}
+
+	// The payload received through a stream of the contact server network endpoint is perceived
+	// as received through the corresponding traffic server network endpoint.
+	if server.Role() == shila.ContactNetworkEndpoint {
+		s.netFlow.Src.(*snet.UDPAddr).Host.Port = s.tcpFlow.Src.Port
+	}
+
+	return s
+}
+
+func (server *QUICServer) removeStream(key shila.TCPFlowKey, s *quicServerStream) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if current, ok := server.streams[key]; ok && current == s {
+		delete(server.streams, key)
+	}
+	_ = s.stream.Close()
+}
+
+func (server *QUICServer) serveEgress() {
+	for p := range server.Egress {
+
+		key := p.Flow.TCPFlow.Key()
+
+		server.lock.Lock()
+		s, ok := server.streams[key]
+		if !ok {
+			// No stream yet, it may take some time until the client on the other side is ready.
+			server.addToHoldingArea(p)
+		}
+		server.lock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		capture.Record(server, capture.Egress, p)
+		if err := s.encoder.Encode(payloadMessage{Payload: p.Payload}); err != nil {
+			server.removeStream(key, s)
+			// Just the connection using this stream is affected.
+			go server.handleStreamIssue(key, err)
+		}
+	}
+}
+
+func (server *QUICServer) expireHeldPackets() {
+	ticker := time.NewTicker(holdingAreaCheckInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		if server.State.Is(shila.TornDown) {
+			return
+		}
+		for key := range expiredTCPFlows(server.holdingArea.expire()) {
+			go server.handleStreamIssue(key, ConnectionError("Unable to send packet."))
+		}
+	}
+}
+
+func (server *QUICServer) addToHoldingArea(packet *shila.Packet) {
+	if !server.holdingArea.hold(string(packet.Flow.TCPFlow.Key()), packet) {
+		log.Verbose.Println(server.Says(fmt.Sprint("Holding area for ", packet.Flow.TCPFlow.String(), " is full, dropped packet.")))
+	}
+}
+
+func (server *QUICServer) logHoldingArea() {
+
+	interval := holdingAreaLogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var logged HoldingAreaCounters
+	for range ticker.C {
+		if server.State.Is(shila.TornDown) {
+			return
+		}
+		if counters := server.holdingArea.Counters(); counters != logged {
+			log.Info.Print(server.Says(fmt.Sprint("Holding area: ", counters, ".")))
+			logged = counters
+		}
+	}
+}
+
+func (server *QUICServer) handleStreamIssue(key shila.TCPFlowKey, err error) {
+	if server.State.Is(shila.Running) {
+		server.Issues <- shila.EndpointIssuePub{Issuer: server, Key: key, Error: ConnectionError(err.Error())}
+	}
+}
+
+func (server *QUICServer) handleConnectionIssue(err error) {
+	// Wait a little bit - maybe the server is going to die anyway.
+	time.Sleep(time.Duration(config.Config.NetworkEndpoint.WaitingTimeAfterConnectionIssue) * time.Second)
+	if server.State.Is(shila.Running) {
+		log.Error.Println(server.Says(fmt.Sprint("Publishes issue - ", err.Error())))
+		server.Issues <- shila.EndpointIssuePub{Issuer: server, Error: ConnectionError(err.Error())}
+	}
+}
@@ -0,0 +1,42 @@
+//
+package networkEndpoint
+
+import (
+	"fmt"
+	"shila/core/shila"
+	"sync"
+	"time"
+)
+
+// Smoothed round trip times per path, sampled by the heartbeats of the client network endpoints.
+var pathRTTs = rttSamples{ samples: make(map[string] time.Duration) }
+
+type rttSamples struct {
+	samples map[string] time.Duration
+	lock    sync.Mutex
+}
+
+// RTT returns the smoothed round trip time towards the address along the given path,
+// false if there is no sample yet.
+func RTT(rAddr shila.NetworkAddress, path shila.NetworkPath) (time.Duration, bool) {
+	pathRTTs.lock.Lock()
+	defer pathRTTs.lock.Unlock()
+	rtt, ok := pathRTTs.samples[getRTTKey(rAddr, path)]
+	return rtt, ok
+}
+
+// Same smoothing as for the TCP retransmission timer (RFC 6298).
+func (s *rttSamples) add(rAddr shila.NetworkAddress, path shila.NetworkPath, sample time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := getRTTKey(rAddr, path)
+	if rtt, ok := s.samples[key]; ok {
+		s.samples[key] = rtt - rtt / 8 + sample / 8
+	} else {
+		s.samples[key] = sample
+	}
+}
+
+func getRTTKey(rAddr shila.NetworkAddress, path shila.NetworkPath) string {
+	return fmt.Sprint(rAddr.String(), shila.KeyDelimiter, getPathKey(path))
+}
@@ -2,8 +2,7 @@ package networkEndpoint
 
 import (
 	"fmt"
-	"github.com/netsec-ethz/scion-apps/pkg/appnet"
-	"github.com/scionproto/scion/go/lib/snet"
+	"net"
 	"shila/config"
 	"shila/core/shila"
 	"shila/log"
@@ -16,15 +15,17 @@ var _ shila.NetworkServerEndpoint = (*Server)(nil)
 
 type Server struct{
 	Base
+	network				Network
 	key					shila.NetworkAddressKey
 	backboneConnections ServerBackboneConnections
 	lAddress            shila.NetworkAddress
-	lConnection         *snet.Conn
+	lConnection         net.PacketConn
 	lock                sync.Mutex
-	holdingArea         [] *shila.Packet
+	holdingArea         *holdingArea
+	stop                chan struct{}		// Closed on tear down, releases the decoders blocked on a full ingress channel.
 }
 
-func NewServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
+func NewServer(network Network, lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
 	return &Server{
 		Base: 			Base{
 									Role:    role,
@@ -33,9 +34,11 @@ func NewServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila
 									State:   shila.NewEntityState(),
 									Issues:  issues,
 								},
+		network:		network,
 		key:			shila.GetNetworkAddressKey(lAddr),
 		lAddress:       lAddr,
-		holdingArea:	make([] *shila.Packet, 0, config.Config.NetworkEndpoint.SizeHoldingArea),
+		holdingArea:	newHoldingArea(),
+		stop:			make(chan struct{}),
 		lock:           sync.Mutex{},
 	}
 }
@@ -50,14 +53,17 @@ func (server *Server) SetupAndRun() (err error) {
 	server.backboneConnections = NewBackboneConnections(server)
 
 	// Connection to listen for incoming backbone connections.
-	server.lConnection, err = appnet.Listen(server.lAddress.(*snet.UDPAddr).Host)
+	server.lConnection, err = server.network.Listen(server.lAddress)
 	if err != nil {
 		return shila.PrependError(ConnectionError(err.Error()), "Unable to setup listener.")
 	}
 
 	go server.serveIngress() 			// Start listening for incoming backbone connections.
 	go server.serveEgress()  			// Start handling incoming packets.
-	go server.resendFunctionality() 	// Start the resending functionality
+	go server.expireHeldPackets() 		// Start dropping packets held for too long
+	go server.logHoldingArea()			// Start logging the statistics of the packets held
+	go server.detectDeadPeers()			// Start removing backbone connections w/o heartbeats
+	go server.reapIdleConnections()		// Start removing backbone connections w/o payload
 
 	server.State.Set(shila.Running)
 	log.Verbose.Print(server.Says("Setup and running."))
@@ -67,13 +73,14 @@ func (server *Server) SetupAndRun() (err error) {
 func (server *Server) TearDown() (err error) {
 
 	server.State.Set(shila.TornDown)
+	close(server.stop)
 
 	err = server.lConnection.Close()            // Close the listening connection. (Server no longer receives incoming connections.)
-	err = server.backboneConnections.TearDown() // Properly terminate all existing backbone connections.
+	err = server.backboneConnections.TearDown() // Properly terminate all existing backbone connections, waits for their decoders.
 
 	close(server.Ingress) // Close the Ingress channel (Working side no longer processes this endpoint)
 
-	log.Verbose.Print(server.Says("Got torn down."))
+	log.Verbose.Print(server.Says(fmt.Sprint("Got torn down. Holding area: ", server.holdingArea.Counters(), ".")))
 	return err
 }
 
@@ -145,29 +152,104 @@ func (server *Server) serveEgress() {
 	}
 }
 
-func (server *Server) resendFunctionality() {
-	for {
-		time.Sleep(time.Duration(config.Config.NetworkEndpoint.ServerResendInterval) * time.Second)
-		server.lock.Lock()
-		for _, p := range server.holdingArea {
-			if p.TTL > 0 {
-				p.TTL--
-				server.Egress <- p
-			} else {
-				// Server network endpoint is "not able" to send out the given packet.
+func (server *Server) expireHeldPackets() {
+	ticker := time.NewTicker(holdingAreaCheckInterval())
+	defer ticker.Stop()
+	for range ticker.C {
+		if server.State.Is(shila.TornDown) {
+			return
+		}
+		// Server network endpoint is "not able" to send out the expired packets.
+		for key := range expiredTCPFlows(server.holdingArea.expire()) {
+			// An issue of the contact server network endpoint is fatal, the connection
+			// using it is closed by the vacuum anyway.
+			if server.Role() == shila.TrafficNetworkEndpoint {
 				err := ConnectionError("Unable to send packet.")
-				server.Issues <- shila.EndpointIssuePub { Issuer: server, Key: p.Flow.TCPFlow.Key(), Error: err } // TODO!
+				server.Issues <- shila.EndpointIssuePub { Issuer: server, Key: key, Error: err }
+			} else {
+				log.Error.Println(server.Says(fmt.Sprint("Unable to send packet of ", key, ".")))
+			}
+		}
+	}
+}
+
+func (server *Server) logHoldingArea() {
+
+	interval := holdingAreaLogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var logged HoldingAreaCounters
+	for range ticker.C {
+		if server.State.Is(shila.TornDown) {
+			return
+		}
+		if counters := server.holdingArea.Counters(); counters != logged {
+			log.Info.Print(server.Says(fmt.Sprint("Holding area: ", counters, ".")))
+			logged = counters
+		}
+	}
+}
+
+// Removes the backbone connections whose client did neither send heartbeats nor payload for
+// the configured number of heartbeat intervals. The connection in shila using a removed
+// traffic backbone connection is closed.
+func (server *Server) detectDeadPeers() {
+
+	interval := time.Duration(config.Config.NetworkEndpoint.HeartbeatInterval) * time.Millisecond
+	if interval <= 0 {
+		return
+	}
+	timeout := interval * time.Duration(config.Config.NetworkEndpoint.HeartbeatMissThreshold)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if server.State.Not(shila.Running) {
+			return
+		}
+		for _, conn := range server.backboneConnections.silent(timeout) {
+			log.Error.Println(conn.Says("Peer not responding, removing connection."))
+			conn.removeConnection()
+			// An issue of the contact server network endpoint is fatal, a dead peer does not justify that.
+			// Without a control message, the connection does not belong to any tcp flow yet.
+			if server.Role() == shila.TrafficNetworkEndpoint && conn.tcpFlow.Src.IP != nil {
+				err := ConnectionError("Peer not responding.")
+				server.Issues <- shila.EndpointIssuePub{ Issuer: server, Key: conn.tcpFlow.Key(), Error: err }
 			}
 		}
-		server.holdingArea = server.holdingArea[:0]
-		server.lock.Unlock()
+	}
+}
+
+// Removes the backbone connections which did neither receive nor send payload for the configured time.
+// The connection in shila using such a backbone connection is idle as well and is closed by the vacuum.
+func (server *Server) reapIdleConnections() {
+
+	timeout := time.Duration(config.Config.NetworkEndpoint.BackboneIdleTimeout) * time.Second
+	if timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		if server.State.Not(shila.Running) {
+			return
+		}
+		for _, conn := range server.backboneConnections.idle(timeout) {
+			log.Verbose.Println(conn.Says("Idle, removing connection."))
+			conn.removeConnection()
+		}
 	}
 }
 
 func (server *Server) addToHoldingArea(packet *shila.Packet) {
-	server.lock.Lock()
-	defer server.lock.Unlock()
-	server.holdingArea = append(server.holdingArea, packet)
+	if !server.holdingArea.hold(string(shila.GetNetworkAddressKey(packet.Flow.NetFlow.Dst)), packet) {
+		log.Verbose.Println(server.Says(fmt.Sprint("Holding area for ", packet.Flow.NetFlow.Dst, " is full, dropped packet.")))
+	}
 }
 
 func (server *Server) handleConnectionIssue(err error) {
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
-	"github.com/scionproto/scion/go/lib/snet"
 	"io"
+	"net"
+	"shila/capture"
 	"shila/core/shila"
 	"shila/log"
+	"shila/networkSide/security"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ServerBackboneConnectionsMapping map[shila.NetworkAddressKey] *ServerBackboneConnection
@@ -16,6 +20,8 @@ type ServerBackboneConnectionsMapping map[shila.NetworkAddressKey] *ServerBackbo
 type ServerBackboneConnections struct {
 	connections ServerBackboneConnectionsMapping
 	server      *Server
+	decoders    sync.WaitGroup		// The decoders of the connections, they are the senders on the ingress channel.
+	closed      bool				// Set on tear down, no new connections are created afterwards.
 	lock        sync.Mutex
 }
 
@@ -26,6 +32,7 @@ func NewBackboneConnections(server *Server) ServerBackboneConnections {
 	}
 }
 
+// The lock has to be held by the caller.
 func (conns *ServerBackboneConnections) retrieve(key shila.NetworkAddressKey) *ServerBackboneConnection {
 	if conn, ok := conns.connections[key]; ok {
 		return conn
@@ -34,18 +41,72 @@ func (conns *ServerBackboneConnections) retrieve(key shila.NetworkAddressKey) *S
 	}
 }
 
-func (conns *ServerBackboneConnections) remove(key shila.NetworkAddressKey) {
+// Just removes the mappings still pointing to the given connection, a key may
+// already be taken by a new connection from the same peer.
+func (conns *ServerBackboneConnections) remove(conn *ServerBackboneConnection) {
 	conns.lock.Lock()
 	defer conns.lock.Unlock()
-	delete(conns.connections, key)
+	for _, key := range conn.keys {
+		if current, ok := conns.connections[key]; ok && current == conn {
+			delete(conns.connections, key)
+		}
+	}
 }
 
+// The lock has to be held by the caller.
 func (conns *ServerBackboneConnections) add(key shila.NetworkAddressKey, conn *ServerBackboneConnection) {
 	conns.connections[key] = conn
 	return
 }
 
+// Maps the connection by a further key, unless it was removed in the meantime.
+func (conns *ServerBackboneConnections) addKey(key shila.NetworkAddressKey, conn *ServerBackboneConnection) {
+	conns.lock.Lock()
+	defer conns.lock.Unlock()
+	if atomic.LoadInt32(&conn.removed) != 0 {
+		return
+	}
+	conn.keys = append(conn.keys, key)
+	conns.add(key, conn)
+}
+
+// Returns the connections which did not receive anything within the given time.
+func (conns *ServerBackboneConnections) silent(timeout time.Duration) []*ServerBackboneConnection {
+	return conns.collect(func(conn *ServerBackboneConnection) bool {
+		return time.Since(time.Unix(0, atomic.LoadInt64(&conn.lastHeard))) > timeout
+	})
+}
+
+// Returns the connections which did neither receive nor send payload within the given time.
+func (conns *ServerBackboneConnections) idle(timeout time.Duration) []*ServerBackboneConnection {
+	return conns.collect(func(conn *ServerBackboneConnection) bool {
+		return time.Since(time.Unix(0, atomic.LoadInt64(&conn.lastActivity))) > timeout
+	})
+}
+
+func (conns *ServerBackboneConnections) collect(selected func(conn *ServerBackboneConnection) bool) []*ServerBackboneConnection {
+	conns.lock.Lock()
+	defer conns.lock.Unlock()
+	collected := make([]*ServerBackboneConnection, 0)
+	seen      := make(map[*ServerBackboneConnection]bool)		// A connection can be mapped by two keys.
+	for _, conn := range conns.connections {
+		if !seen[conn] && selected(conn) {
+			collected = append(collected, conn)
+		}
+		seen[conn] = true
+	}
+	return collected
+}
+
+// Removes all backbone connections and waits until their decoders terminated.
 func (conns *ServerBackboneConnections) TearDown() error {
+	conns.lock.Lock()
+	conns.closed = true
+	conns.lock.Unlock()
+	for _, conn := range conns.collect(func(conn *ServerBackboneConnection) bool { return true }) {
+		conn.removeConnection()
+	}
+	conns.decoders.Wait()
 	return nil
 }
 
@@ -54,11 +115,31 @@ func (conns *ServerBackboneConnections) WriteIngress(rAddress shila.NetworkAddre
 	conns.lock.Lock()
 	defer conns.lock.Unlock()
 
+	if conns.closed {
+		return
+	}
+
 	conn := conns.retrieve(shila.GetNetworkAddressKey(rAddress))
 	if conn == nil {
+		// If enabled, peers without valid credentials are rejected before any state is created.
+		var session *security.Session
+		if security.Enabled() {
+			var err error
+			if session, err = security.AcceptSession(rAddress, buff); err != nil {
+				log.Error.Println(conns.server.Says(fmt.Sprint("Rejected datagram from ", rAddress, ". ", err.Error())))
+				return
+			}
+		}
+		// A connection starts w/ the control message. Payload from a peer whose connection was removed
+		// (e.g. reaped as idle) is rejected, its client notices the missing heartbeat echoes and closes
+		// the connection. (If enabled, the session of a removed connection is not accepted again anyway.)
+		if session == nil && !isControlMessage(buff) {
+			log.Verbose.Println(conns.server.Says(fmt.Sprint("Rejected datagram from ", rAddress, ", no control message.")))
+			return
+		}
 		// Connection not yet exists, we first have to create a new one and add it to the mapping.
-		if conn = newBackboneConnection(rAddress, conns); conn == nil {
-			log.Error.Println(conn.server.Says("Failed to create a new backbone connection."))
+		if conn = newBackboneConnection(rAddress, conns, session); conn == nil {
+			log.Error.Println(conns.server.Says("Failed to create a new backbone connection."))
 			return
 		}
 		conns.add(conn.keys[0], conn)
@@ -77,6 +158,7 @@ func (conns *ServerBackboneConnections) WriteEgress(packet *shila.Packet) error
 
 	// We try to send out the data if there exists a backbone connection.
 	if conn := conns.retrieve(shila.GetNetworkAddressKey(packet.Flow.NetFlow.Dst)); conn != nil {
+		capture.Record(conns.server, capture.Egress, packet)
 		return conn.writeEgress(packet.Payload)		// If writing fails, then because of an issue with the connection.
 	}
 
@@ -93,26 +175,31 @@ type NetFlows struct {
 }
 
 type ServerBackboneConnection struct {
-	keys        [] shila.NetworkAddressKey
+	keys        [] shila.NetworkAddressKey		// Guarded by the lock of the connections.
 	netFlows    NetFlows
 	server      *Server
 	tcpFlow     shila.TCPFlow
 	inReader    *io.PipeReader
 	inWriter    *io.PipeWriter
 	connections *ServerBackboneConnections
+	session     *security.Session		// Just set if the backbone traffic is secured
+	lastHeard   int64					// Time (unix ns) the peer was heard of the last time, accessed atomically
+	lastActivity int64					// Time (unix ns) payload was received or sent the last time, accessed atomically
+	removed     int32					// Set once the connection is removed, accessed atomically
+	egressBatch *batch					// Just set if batching is enabled
 	lock        sync.Mutex
 }
 
-func newBackboneConnection(rAddress shila.NetworkAddress, conns *ServerBackboneConnections) *ServerBackboneConnection {
+func newBackboneConnection(rAddress shila.NetworkAddress, conns *ServerBackboneConnections, session *security.Session) *ServerBackboneConnection {
 
 	//log.Verbose.Print("New Backbone connection for: \n")
 	//log.Verbose.Print("| rAddress: ", rAddress, "\n")
 	//log.Verbose.Print("| Server: ", conns.server.Identifier(), "\n")
 
 	netFlow := shila.NetFlow{							// Net flow which is represented by this connection.
-		Src:  conns.server.lAddress.(*snet.UDPAddr).Copy(),
-		Path: rAddress.(*snet.UDPAddr).Path.Copy(),
-		Dst:  rAddress.(*snet.UDPAddr),
+		Src:  conns.server.lAddress,
+		Path: conns.server.network.Path(rAddress),
+		Dst:  rAddress,
 	}
 
 	inReader, inWriter := io.Pipe()
@@ -124,10 +211,20 @@ func newBackboneConnection(rAddress shila.NetworkAddress, conns *ServerBackboneC
 		inReader:    	inReader,
 		inWriter:    	inWriter,
 		connections: 	conns,
+		session:		session,
+		lastHeard:		time.Now().UnixNano(),
+		lastActivity:	time.Now().UnixNano(),
 	}
 
 	conn.keys = append(conn.keys, shila.GetNetworkAddressKey(rAddress))
 
+	if batchingEnabled() {
+		conn.egressBatch = newBatch(netFlow.Path, conn.sendBatchMessage, func(err error) {
+			log.Error.Println(conn.Says(shila.PrependError(err, "Unable to send batch.").Error()))
+		})
+	}
+
+	conns.decoders.Add(1)
 	go conn.decodeIngress()		// Start the decoder.
 								// If there is an issue in the decoding process then the process removes
 								// the connection from the mapping and terminates.
@@ -142,12 +239,13 @@ func newBackboneConnection(rAddress shila.NetworkAddress, conns *ServerBackboneC
 
 func (conn *ServerBackboneConnection) decodeIngress() {
 
+	defer conn.connections.decoders.Done()
+
 	// The first message should be a control message which contains
 	// all the information necessary to setup the backbone connection.
 	ctrlMsg, err := conn.retrieveControlMessage()
 	if err != nil {
-		log.Error.Println(conn.Says(err.Error()))
-		conn.removeConnection()
+		conn.fail(err)
 		return
 	}
 
@@ -155,16 +253,22 @@ func (conn *ServerBackboneConnection) decodeIngress() {
 
 	// Process the control message.
 	if err := conn.processControlMessage(ctrlMsg); err != nil {
-		log.Error.Println(conn.Says(err.Error()))
-		conn.removeConnection()
+		conn.fail(err)
 		return
 	}
 
+	// Acknowledge the control message, the client waits for it before sending any payload.
+	if err := conn.sendControlAckMessage(ctrlMsg.Attempt); err != nil {
+		log.Error.Println(conn.Says(err.Error()))
+	}
+
+	// The client is ready, the packets waiting for this connection are sent out right away.
+	conn.releaseHeldPackets()
+
 	// Now we are ready to listen for and process payload.
 	for {
 		if err := conn.processPayloadMessage(); err != nil {
-			log.Error.Println(conn.Says(err.Error()))
-			conn.removeConnection()
+			conn.fail(err)
 			return
 		}
 	}
@@ -195,6 +299,14 @@ func (conn *ServerBackboneConnection) processControlMessage(ctrlMsg controlMessa
 		return nil
 	}()
 
+	// A contacting connection creates state in shila (a traffic server network endpoint, kernel side
+	// state for the tcp flow), peers have to be admitted before that.
+	if conn.server.Role() == shila.ContactNetworkEndpoint {
+		if err := security.AdmitContact(conn.netFlows.effective.Dst, ctrlMsg.TcpFlow); err != nil {
+			return err
+		}
+	}
+
 	// Set the ip flow
 	conn.tcpFlow = ctrlMsg.TcpFlow.Swap()
 
@@ -203,63 +315,166 @@ func (conn *ServerBackboneConnection) processControlMessage(ctrlMsg controlMessa
 	// The representing flow is then updated accordingly such that the payload received through
 	// this connection is perceived as received through the traffic connection.
 	if conn.server.Role() == shila.ContactNetworkEndpoint {
-		conn.netFlows.represented.Src.(*snet.UDPAddr).Host.Port = conn.tcpFlow.Src.Port
+		conn.netFlows.represented.Src = conn.server.network.WithPort(conn.netFlows.represented.Src, conn.tcpFlow.Src.Port)
 	}
 
 	// If the backbone connect is part of a traffic server network endpoint, then the connection
 	// has to be found by messages send along the corresponding contact backbone connection as well.
 	if conn.server.Role() == shila.TrafficNetworkEndpoint {
 
-		lAddrContactEndFull 	:= conn.netFlows.effective.Dst.(*snet.UDPAddr).Copy()
-		lAddrContactEndFull.Host = &ctrlMsg.LAddrContactEnd
-		conn.keys = append(conn.keys, shila.GetNetworkAddressKey(lAddrContactEndFull))
-		conn.connections.add(conn.keys[1], conn) // lock here?
+		lAddrContactEndFull 	:= conn.server.network.WithHost(conn.netFlows.effective.Dst, ctrlMsg.LAddrContactEnd)
+		conn.connections.addKey(shila.GetNetworkAddressKey(lAddrContactEndFull), conn)
 	}
 
 	return nil
 }
 
+// Every message is sent within a single datagram, the first datagram of a connection holds the control message.
+func isControlMessage(datagram []byte) bool {
+	var ctrlMsg controlMessage
+	if err := gob.NewDecoder(bytes.NewReader(datagram)).Decode(&ctrlMsg); err != nil {
+		return false
+	}
+	return ctrlMsg.TcpFlow.Src.IP != nil
+}
+
 func (conn *ServerBackboneConnection) processPayloadMessage() error {
 
 	// Fetch the next payload message
 	var pyldMsg payloadMessage
 	if err := gob.NewDecoder(conn.inReader).Decode(&pyldMsg); err != nil {
-		err = shila.PrependError(ParsingError("Failed to decode payload message."), err.Error())
+		return shila.PrependError(ParsingError("Failed to decode payload message."), err.Error())
 	}
-	if len(pyldMsg.Payload) == 0 {
-		// From time to to we get a zero payload packet...?
-		//log.Error.Println(conn.Says("Received zero payload packet."))
-		return nil
+	if pyldMsg.Attempt != 0 {
+		// A retransmitted control message, the client did not receive our acknowledgment. The
+		// connection is already set up, so we just acknowledge it again.
+		return conn.sendControlAckMessage(pyldMsg.Attempt)
 	}
+	if pyldMsg.Heartbeat != 0 {
+		// Echo the heartbeat, the client uses it to detect a dead peer and to sample the round trip time.
+		return conn.sendHeartbeatEcho(pyldMsg.Heartbeat)
+	}
+	for _, payload := range pyldMsg.payloads() {
+		if len(payload) == 0 {
+			// From time to to we get a zero payload packet...?
+			//log.Error.Println(conn.Says("Received zero payload packet."))
+			continue
+		}
 
-	conn.server.Ingress <- shila.NewPacketWithNetFlowAndKind(conn.server,
-													  		 conn.tcpFlow.Swap(),
-													  		 conn.netFlows.represented.Swap(),
-													  		 pyldMsg.Payload)
+		atomic.StoreInt64(&conn.lastActivity, time.Now().UnixNano())
+		p := shila.NewPacketWithNetFlowAndKind(conn.server,
+											   conn.tcpFlow.Swap(),
+											   conn.netFlows.represented.Swap(),
+											   payload)
+		capture.Record(conn.server, capture.Ingress, p)
+		select {
+		case conn.server.Ingress <- p:
+		case <-conn.server.stop:
+			return ConnectionError("Server got torn down.")
+		}
+	}
 
 	return nil
 }
 
-func (conn *ServerBackboneConnection) removeConnection() {
-	for _, key := range conn.keys {
-		conn.connections.remove(key)
+func (conn *ServerBackboneConnection) releaseHeldPackets() {
+	conn.connections.lock.Lock()
+	keys := append([]shila.NetworkAddressKey(nil), conn.keys...)
+	conn.connections.lock.Unlock()
+	for _, key := range keys {
+		for _, p := range conn.server.holdingArea.release(string(key)) {
+			capture.Record(conn.server, capture.Egress, p)
+			if err := conn.writeEgress(p.Payload); err != nil {
+				log.Error.Println(conn.Says(shila.PrependError(err, "Unable to send held packet.").Error()))
+			}
+		}
 	}
 }
 
+// The decoder terminates after an error, the connection is then of no further use.
+func (conn *ServerBackboneConnection) fail(err error) {
+	// If the connection was removed on purpose (idle, dead peer, tear down),
+	// then the error is just the consequence of the closed pipe. A rejected
+	// peer is logged by the access control already, once per tcp flow.
+	_, rejected := err.(security.AccessError)
+	if atomic.LoadInt32(&conn.removed) == 0 && !rejected {
+		log.Error.Println(conn.Says(err.Error()))
+	}
+	conn.removeConnection()
+}
+
+func (conn *ServerBackboneConnection) removeConnection() {
+	atomic.StoreInt32(&conn.removed, 1)
+	// Close the pipe first, otherwise a pending write of ingress data blocks forever.
+	_ = conn.inReader.Close()
+	_ = conn.inWriter.Close()
+	conn.connections.remove(conn)
+}
+
 func (conn *ServerBackboneConnection) writeIngress(buff []byte) (err error) {
+	if conn.session != nil {
+		if buff, err = conn.session.Open(buff); err != nil {
+			return shila.PrependError(err, "Dropped datagram.")
+		}
+	}
+	atomic.StoreInt64(&conn.lastHeard, time.Now().UnixNano())
 	_, err = conn.inWriter.Write(buff)
 	return
 }
 
 func (conn *ServerBackboneConnection) writeEgress(payload []byte) (err error){
 
+	atomic.StoreInt64(&conn.lastActivity, time.Now().UnixNano())
+
+	// If enabled, small payloads are coalesced into a single datagram.
+	if conn.egressBatch != nil {
+		return conn.egressBatch.add(payload)
+	}
+
 	var buffer bytes.Buffer
 	if err := gob.NewEncoder(&buffer).Encode(payloadMessage{ Payload: payload }); err != nil {
 		return shila.PrependError(err, "Cannot encode payload message.")
 	}
 
-	_, err = conn.server.lConnection.WriteTo(buffer.Bytes(), conn.netFlows.effective.Dst.(*snet.UDPAddr))
+	return conn.write(buffer.Bytes())
+}
+
+func (conn *ServerBackboneConnection) sendBatchMessage(payloads [][]byte) (err error) {
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(payloadMessage{ Payloads: payloads }); err != nil {
+		return shila.PrependError(err, "Cannot encode payload message.")
+	}
+
+	return conn.write(buffer.Bytes())
+}
+
+func (conn *ServerBackboneConnection) sendControlAckMessage(attempt int) (err error) {
+
+	var buffer bytes.Buffer
+	ctrlAckMsg := controlAckMessage{ TcpFlow: conn.tcpFlow.Swap(), Attempt: attempt }
+	if err := gob.NewEncoder(&buffer).Encode(ctrlAckMsg); err != nil {
+		return shila.PrependError(err, "Cannot encode control acknowledgment message.")
+	}
+
+	return conn.write(buffer.Bytes())
+}
+
+func (conn *ServerBackboneConnection) sendHeartbeatEcho(heartbeat int64) (err error) {
 
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(payloadMessage{ Heartbeat: heartbeat }); err != nil {
+		return shila.PrependError(err, "Cannot encode heartbeat message.")
+	}
+
+	return conn.write(buffer.Bytes())
+}
+
+func (conn *ServerBackboneConnection) write(datagram []byte) (err error) {
+	if conn.session != nil {
+		datagram = conn.session.Seal(datagram)
+	}
+	_, err = conn.server.lConnection.WriteTo(datagram, conn.netFlows.effective.Dst.(net.Addr))	// FIXME: cast!
 	return
 }
 
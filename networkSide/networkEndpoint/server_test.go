@@ -0,0 +1,69 @@
+//
+package networkEndpoint
+
+import (
+	"net"
+	"os"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/networkSide/loopback"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.Init()
+	os.Exit(m.Run())
+}
+
+// The working side stops consuming the ingress of a server before tearing it down, decoders blocked on
+// the full ingress channel must neither keep the tear down waiting nor send on the closed channel.
+func TestTearDownWithBlockedDecoder(t *testing.T) {
+
+	config.Config.NetworkEndpoint.SizeIngressBuffer = 1
+
+	fabric := loopback.NewFabric(1, loopback.PathProperties{})
+	defer fabric.Close()
+	network := LoopbackNetwork{Fabric: fabric, IP: net.IPv4(10, 0, 0, 1)}
+	issues  := make(shila.EndpointIssuePubChannel, 16)
+
+	lAddr  := &net.UDPAddr{IP: network.IP, Port: 7000}
+	server := NewServer(network, lAddr, shila.TrafficNetworkEndpoint, issues).(*Server)
+	if err := server.SetupAndRun(); err != nil {
+		t.Fatal(err)
+	}
+
+	tcpFlow := shila.TCPFlow{
+		Src: net.TCPAddr{IP: net.IPv4(10, 7, 0, 9), Port: 40000},
+		Dst: net.TCPAddr{IP: net.IPv4(10, 7, 1, 1), Port: 80},
+	}
+	client := NewTrafficClient(network, &net.UDPAddr{IP: network.IP, Port: 7001}, lAddr, nil, tcpFlow, issues)
+	if _, err := client.SetupAndRun(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.TearDown()
+
+	for i := 0; i < 4; i++ {
+		client.TrafficChannels().Egress <- &shila.Packet{Payload: []byte{byte(i + 1)}}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Ingress) < cap(server.Ingress) {
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not receive the payload.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)	// The decoder is blocked on the next payload by now.
+
+	done := make(chan struct{})
+	go func() {
+		_ = server.TearDown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tear down blocked.")
+	}
+}
@@ -3,12 +3,14 @@ package networkSide
 
 import (
 	"fmt"
+	"shila/config"
 	"shila/core/shila"
+	"shila/networkSide/security"
 	"sync"
 )
 
 type Manager struct {
-	specificManager           SpecificManager
+	specificManager           shila.SpecificNetworkSideManager
 	contactServer             shila.NetworkServerEndpoint
 	serverTrafficEndpoints    shila.MappingNetworkServerEndpoint
 	clientContactingEndpoints shila.MappingNetworkClientEndpoint
@@ -21,8 +23,14 @@ type Manager struct {
 }
 
 func New(trafficChannelPubs shila.PacketChannelPubChannels, endpointIssues shila.EndpointIssuePubChannels) *Manager {
+	return NewWithSpecificManager(newSpecificManager(), trafficChannelPubs, endpointIssues)
+}
+
+// NewWithSpecificManager creates a network side using the given specific manager instead of the configured one.
+func NewWithSpecificManager(specificManager shila.SpecificNetworkSideManager, trafficChannelPubs shila.PacketChannelPubChannels,
+	endpointIssues shila.EndpointIssuePubChannels) *Manager {
 	return &Manager{
-		specificManager: 			NewSpecificManager(),
+		specificManager: 			specificManager,
 		trafficChannelPubs: 		trafficChannelPubs,
 		endpointIssues: 			endpointIssues,
 		serverEndpointIssues:		make(shila.EndpointIssuePubChannel),
@@ -40,6 +48,11 @@ func (manager *Manager) Setup() error {
 		return shila.CriticalError(fmt.Sprint("Entity in wrong state {", manager.state, "}."))
 	}
 
+	// The QUIC backbone does not seal its datagrams, its peers would not be authenticated at all.
+	if security.Enabled() && config.Config.NetworkSide.Backbone == quicBackbone {
+		return shila.CriticalError("Security is not supported by the QUIC backbone, use the UDP backbone instead.")
+	}
+
 	contactLocalAddr := manager.specificManager.ContactLocalAddr()
 	manager.contactServer   = manager.specificManager.NewServer(contactLocalAddr, shila.ContactNetworkEndpoint, manager.serverEndpointIssues)
 
@@ -79,8 +92,13 @@ func (manager *Manager) CleanUp() error {
 	err = manager.tearDownAndRemoveClientTrafficEndpoints()
 	err = manager.tearDownAndRemoveServerTrafficEndpoints()
 
-	err = manager.contactServer.TearDown()
-	manager.contactServer = nil
+	// Nil if a restart of the contact server is pending.
+	manager.lock.Lock()
+	if manager.contactServer != nil {
+		err = manager.contactServer.TearDown()
+		manager.contactServer = nil
+	}
+	manager.lock.Unlock()
 
 	// As soon as all server network endpoints are torn down
 	// the channel is no longer needed. (Shuts down the issue worker.)
@@ -17,16 +17,16 @@ func NewSpecificManager() SpecificManager {
 }
 
 func (specMng SpecificManager) NewContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, endpointIssues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
-	return networkEndpoint.NewContactClient(rAddr, path, tcpFlow, endpointIssues)
+	return networkEndpoint.NewContactClient(networkEndpoint.SCIONNetwork{}, rAddr, path, tcpFlow, endpointIssues)
 }
 
 func (specMng SpecificManager) NewTrafficClient(lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath,
 	tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
-	return networkEndpoint.NewTrafficClient(lAddrContactEnd, rAddr, path, tcpFlow, issues)
+	return networkEndpoint.NewTrafficClient(networkEndpoint.SCIONNetwork{}, lAddrContactEnd, rAddr, path, tcpFlow, issues)
 }
 
 func (specMng SpecificManager) NewServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
-	return networkEndpoint.NewServer(lAddr, role, issues)
+	return networkEndpoint.NewServer(networkEndpoint.SCIONNetwork{}, lAddr, role, issues)
 }
 
 func (specMng SpecificManager) ContactRemoteAddr(rAddressTraffic shila.NetworkAddress) shila.NetworkAddress {
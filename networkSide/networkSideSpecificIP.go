@@ -0,0 +1,41 @@
+//
+package networkSide
+
+import (
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/networkSide/networkEndpoint"
+)
+
+var _ shila.SpecificNetworkSideManager = (*IPSpecificManager)(nil)
+
+// Uses UDP over plain IP for the backbone connections, no SCION infrastructure required.
+type IPSpecificManager struct { }
+
+func NewIPSpecificManager() IPSpecificManager {
+	return IPSpecificManager{ }
+}
+
+func (specMng IPSpecificManager) NewContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, endpointIssues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return networkEndpoint.NewContactClient(networkEndpoint.IPNetwork{}, rAddr, path, tcpFlow, endpointIssues)
+}
+
+func (specMng IPSpecificManager) NewTrafficClient(lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath,
+	tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return networkEndpoint.NewTrafficClient(networkEndpoint.IPNetwork{}, lAddrContactEnd, rAddr, path, tcpFlow, issues)
+}
+
+func (specMng IPSpecificManager) NewServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
+	return networkEndpoint.NewServer(networkEndpoint.IPNetwork{}, lAddr, role, issues)
+}
+
+func (specMng IPSpecificManager) ContactRemoteAddr(rAddressTraffic shila.NetworkAddress) shila.NetworkAddress {
+	rAddressContact := *rAddressTraffic.(*net.UDPAddr)
+	rAddressContact.Port = config.Config.NetworkSide.ContactingServerPort
+	return &rAddressContact
+}
+
+func (specMng IPSpecificManager) ContactLocalAddr() shila.NetworkAddress {
+	return &net.UDPAddr{Port: config.Config.NetworkSide.ContactingServerPort}
+}
@@ -0,0 +1,78 @@
+//
+package networkSide
+
+import (
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/networkSide/loopback"
+	"shila/networkSide/networkEndpoint"
+	"time"
+)
+
+var _ shila.SpecificNetworkSideManager = (*LoopbackSpecificManager)(nil)
+
+// Connects Shila instances running within the same process through an in-memory network,
+// neither SCION nor any network interface is required.
+type LoopbackSpecificManager struct {
+	network networkEndpoint.LoopbackNetwork
+}
+
+// Fabric shared by all instances using the loopback backbone from the configuration.
+var defaultFabric *loopback.Fabric
+
+// NewLoopbackSpecificManager connects the instance w/ the given IP to the fabric.
+func NewLoopbackSpecificManager(fabric *loopback.Fabric, ip net.IP) LoopbackSpecificManager {
+	return LoopbackSpecificManager{
+		network: networkEndpoint.LoopbackNetwork{Fabric: fabric, IP: ip},
+	}
+}
+
+func newLoopbackSpecificManagerFromConfig() LoopbackSpecificManager {
+	if defaultFabric == nil {
+		defaultFabric = loopback.NewFabric(config.Config.Loopback.Seed, loopback.PathProperties{
+			Delay:        time.Duration(config.Config.Loopback.Delay) * time.Millisecond,
+			Loss:         config.Config.Loopback.Loss,
+			Reorder:      config.Config.Loopback.Reorder,
+			ReorderDelay: time.Duration(config.Config.Loopback.ReorderDelay) * time.Millisecond,
+		})
+		for _, path := range config.Config.Loopback.Paths {
+			local, remote := net.ParseIP(path.Local), net.ParseIP(path.Remote)
+			if local == nil || remote == nil {
+				log.Error.Print("Invalid loopback path ", path.Local, " <-> ", path.Remote, ", using the default properties.")
+				continue
+			}
+			defaultFabric.SetPathProperties(local, remote, loopback.PathProperties{
+				Delay:        time.Duration(path.Delay) * time.Millisecond,
+				Loss:         path.Loss,
+				Reorder:      path.Reorder,
+				ReorderDelay: time.Duration(path.ReorderDelay) * time.Millisecond,
+			})
+		}
+	}
+	return NewLoopbackSpecificManager(defaultFabric, net.ParseIP(config.Config.Loopback.IP))
+}
+
+func (specMng LoopbackSpecificManager) NewContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, endpointIssues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return networkEndpoint.NewContactClient(specMng.network, rAddr, path, tcpFlow, endpointIssues)
+}
+
+func (specMng LoopbackSpecificManager) NewTrafficClient(lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath,
+	tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return networkEndpoint.NewTrafficClient(specMng.network, lAddrContactEnd, rAddr, path, tcpFlow, issues)
+}
+
+func (specMng LoopbackSpecificManager) NewServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
+	return networkEndpoint.NewServer(specMng.network, lAddr, role, issues)
+}
+
+func (specMng LoopbackSpecificManager) ContactRemoteAddr(rAddressTraffic shila.NetworkAddress) shila.NetworkAddress {
+	rAddressContact := *rAddressTraffic.(*net.UDPAddr)
+	rAddressContact.Port = config.Config.NetworkSide.ContactingServerPort
+	return &rAddressContact
+}
+
+func (specMng LoopbackSpecificManager) ContactLocalAddr() shila.NetworkAddress {
+	return &net.UDPAddr{IP: specMng.network.IP, Port: config.Config.NetworkSide.ContactingServerPort}
+}
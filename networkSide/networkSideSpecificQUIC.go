@@ -0,0 +1,32 @@
+//
+package networkSide
+
+import (
+	"shila/core/shila"
+	"shila/networkSide/networkEndpoint"
+)
+
+var _ shila.SpecificNetworkSideManager = (*QUICSpecificManager)(nil)
+
+// Uses QUIC over SCION for the backbone connections. The addressing
+// is the same as for the plain UDP backbone connections.
+type QUICSpecificManager struct {
+	SpecificManager
+}
+
+func NewQUICSpecificManager() QUICSpecificManager {
+	return QUICSpecificManager{ SpecificManager: NewSpecificManager() }
+}
+
+func (specMng QUICSpecificManager) NewContactClient(rAddr shila.NetworkAddress, path shila.NetworkPath, tcpFlow shila.TCPFlow, endpointIssues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return networkEndpoint.NewQUICContactClient(rAddr, path, tcpFlow, endpointIssues)
+}
+
+func (specMng QUICSpecificManager) NewTrafficClient(lAddrContactEnd shila.NetworkAddress, rAddr shila.NetworkAddress, path shila.NetworkPath,
+	tcpFlow shila.TCPFlow, issues shila.EndpointIssuePubChannel) shila.NetworkClientEndpoint {
+	return networkEndpoint.NewQUICTrafficClient(lAddrContactEnd, rAddr, path, tcpFlow, issues)
+}
+
+func (specMng QUICSpecificManager) NewServer(lAddr shila.NetworkAddress, role shila.EndpointRole, issues shila.EndpointIssuePubChannel) shila.NetworkServerEndpoint {
+	return networkEndpoint.NewQUICServer(lAddr, role, issues)
+}
@@ -0,0 +1,220 @@
+//
+package security
+
+import (
+	"fmt"
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/snet"
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Access control for the contacting connections. A peer matching any of the deny patterns is
+// rejected, if there are allow patterns then the peer has to match one of them. A pattern looks
+// as follows:
+//
+//	*                     any peer
+//	<isd>-<as>            any host in the ISD-AS, "*" (or 0) as wildcard for ISD or AS
+//	<isd>-<as>,<host>     host (ip or cidr) in the ISD-AS
+//	<host>                host (ip or cidr) in any ISD-AS, the only form for the plain IP backbone
+
+type peerPattern struct {
+	isd   addr.ISD			// Zero matches any ISD.
+	as    addr.AS			// Zero matches any AS.
+	anyIA bool
+	hosts *net.IPNet		// Nil matches any host.
+}
+
+var (
+	allowPatterns []peerPattern
+	denyPatterns  []peerPattern
+	allowedPorts  map[int] bool
+	accessOnce    sync.Once
+	rejections    uint64
+	rejected      = rejectedFlows{ flows: make(map[string] time.Time) }
+)
+
+// The client retransmits its control message until it gives up, every retransmission reaches
+// the access control. A rejected tcp flow is remembered for as long as the client keeps trying.
+type rejectedFlows struct {
+	flows map[string] time.Time
+	lock  sync.Mutex
+}
+
+func loadAccessControl() {
+	allowPatterns = parsePeerPatterns(config.Config.AccessControl.Allow)
+	denyPatterns  = parsePeerPatterns(config.Config.AccessControl.Deny)
+	allowedPorts  = make(map[int] bool)
+	for _, port := range config.Config.AccessControl.AllowedPorts {
+		allowedPorts[port] = true
+	}
+}
+
+// AdmitContact checks whether the peer is allowed to reach the local tcp port of the tcp flow (as seen
+// by the peer) through the contacting server network endpoint. Every rejected tcp flow is logged and
+// counted once.
+func AdmitContact(peer shila.NetworkAddress, tcpFlow shila.TCPFlow) error {
+
+	if !config.Config.AccessControl.Enabled {
+		return nil
+	}
+	accessOnce.Do(loadAccessControl)
+
+	var err error
+	port := tcpFlow.Dst.Port
+	if matchesAny(denyPatterns, peer) {
+		err = AccessError(fmt.Sprint("Peer ", peer, " is denied."))
+	} else if len(allowPatterns) > 0 && !matchesAny(allowPatterns, peer) {
+		err = AccessError(fmt.Sprint("Peer ", peer, " is not allowed."))
+	} else if len(allowedPorts) > 0 && !allowedPorts[port] {
+		err = AccessError(fmt.Sprint("Port ", port, " is not allowed for peer ", peer, "."))
+	}
+
+	if err != nil && rejected.first(fmt.Sprint(peer, shila.KeyDelimiter, tcpFlow.Key())) {
+		n := atomic.AddUint64(&rejections, 1)
+		log.Error.Println(fmt.Sprint("Access control rejected contacting connection (", n, " so far). ", err.Error()))
+	}
+	return err
+}
+
+// Rejections returns the number of tcp flows rejected so far.
+func Rejections() uint64 {
+	return atomic.LoadUint64(&rejections)
+}
+
+// True if the tcp flow is rejected for the first time.
+func (r *rejectedFlows) first(key string) bool {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for flow, since := range r.flows {
+		if now.Sub(since) > retransmissionPeriod() {
+			delete(r.flows, flow)
+		}
+	}
+	if _, ok := r.flows[key]; ok {
+		return false
+	}
+	r.flows[key] = now
+	return true
+}
+
+// Time it takes a client to give up on its control message, the timeout doubles after every transmission.
+func retransmissionPeriod() time.Duration {
+	timeout := time.Duration(config.Config.NetworkEndpoint.HandshakeTimeout) * time.Millisecond
+	return timeout * time.Duration(1 << uint(config.Config.NetworkEndpoint.HandshakeAttempts) - 1)
+}
+
+func parsePeerPatterns(entries []string) []peerPattern {
+	patterns := make([]peerPattern, 0, len(entries))
+	for _, entry := range entries {
+		pattern, err := parsePeerPattern(entry)
+		if err != nil {
+			log.Error.Println(shila.PrependError(err, "Skipped access control pattern.").Error())
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func parsePeerPattern(entry string) (peerPattern, error) {
+
+	entry = strings.TrimSpace(entry)
+	if entry == "*" {
+		return peerPattern{ anyIA: true }, nil
+	}
+
+	// Just a host.
+	if hosts, err := parseHosts(entry); err == nil {
+		return peerPattern{ anyIA: true, hosts: hosts }, nil
+	}
+
+	parts := strings.SplitN(entry, ",", 2)
+	iaParts := strings.SplitN(strings.TrimSpace(parts[0]), "-", 2)
+	if len(iaParts) != 2 {
+		return peerPattern{}, AccessError(fmt.Sprint("Unable to parse pattern ", entry, "."))
+	}
+
+	pattern := peerPattern{}
+	if iaParts[0] != "*" {
+		isd, err := addr.ISDFromString(iaParts[0])
+		if err != nil {
+			return peerPattern{}, AccessError(fmt.Sprint("Unable to parse ISD of pattern ", entry, "."))
+		}
+		pattern.isd = isd
+	}
+	if iaParts[1] != "*" {
+		as, err := addr.ASFromString(iaParts[1])
+		if err != nil {
+			return peerPattern{}, AccessError(fmt.Sprint("Unable to parse AS of pattern ", entry, "."))
+		}
+		pattern.as = as
+	}
+
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "*" {
+		hosts, err := parseHosts(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return peerPattern{}, AccessError(fmt.Sprint("Unable to parse host of pattern ", entry, "."))
+		}
+		pattern.hosts = hosts
+	}
+
+	return pattern, nil
+}
+
+// A single ip is treated as a network w/ full mask.
+func parseHosts(entry string) (*net.IPNet, error) {
+	if _, hosts, err := net.ParseCIDR(entry); err == nil {
+		return hosts, nil
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, AccessError(fmt.Sprint("Unable to parse host ", entry, "."))
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8 * net.IPv4len
+	}
+	return &net.IPNet{ IP: ip, Mask: net.CIDRMask(bits, bits) }, nil
+}
+
+func matchesAny(patterns []peerPattern, peer shila.NetworkAddress) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(peer) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p peerPattern) matches(peer shila.NetworkAddress) bool {
+	switch addr := peer.(type) {
+	case *snet.UDPAddr:
+		if !p.anyIA && ((p.isd != 0 && p.isd != addr.IA.I) || (p.as != 0 && p.as != addr.IA.A)) {
+			return false
+		}
+		return p.hosts == nil || (addr.Host != nil && p.hosts.Contains(addr.Host.IP))
+	case *net.UDPAddr:
+		// There is no ISD-AS w/ plain IP, just patterns for any ISD-AS can match.
+		if !p.anyIA && (p.isd != 0 || p.as != 0) {
+			return false
+		}
+		return p.hosts == nil || p.hosts.Contains(addr.IP)
+	}
+	return false
+}
+
+// Access control issue, the contacting connection is rejected.
+type AccessError string
+func (e AccessError) Error() string {
+	return string(e)
+}
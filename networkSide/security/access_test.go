@@ -0,0 +1,48 @@
+//
+package security
+
+import (
+	"net"
+	"os"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.Init()
+	os.Exit(m.Run())
+}
+
+func TestRejectionCountedOncePerTCPFlow(t *testing.T) {
+
+	config.Config.AccessControl.Enabled = true
+	config.Config.AccessControl.Deny = []string{"*"}
+	defer func() { config.Config.AccessControl.Enabled = false }()
+
+	peer := &net.UDPAddr{IP: net.ParseIP("10.1.0.1"), Port: 50000}
+	tcpFlow := shila.TCPFlow{
+		Src: net.TCPAddr{IP: net.ParseIP("10.7.0.2"), Port: 40000},
+		Dst: net.TCPAddr{IP: net.ParseIP("10.7.0.9"), Port: 11111},
+	}
+	before := Rejections()
+
+	// The control message is retransmitted a few times.
+	for attempt := 0; attempt < 3; attempt++ {
+		if err := AdmitContact(peer, tcpFlow); err == nil {
+			t.Fatal("Denied peer admitted.")
+		}
+	}
+	if n := Rejections() - before; n != 1 {
+		t.Fatalf("Expected 1 rejection, counted %d.", n)
+	}
+
+	tcpFlow.Src.Port++
+	if err := AdmitContact(peer, tcpFlow); err == nil {
+		t.Fatal("Denied peer admitted.")
+	}
+	if n := Rejections() - before; n != 2 {
+		t.Fatalf("Expected 2 rejections, counted %d.", n)
+	}
+}
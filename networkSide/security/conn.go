@@ -0,0 +1,51 @@
+//
+package security
+
+import (
+	"net"
+	"shila/config"
+	"shila/log"
+)
+
+var _ net.Conn = (*Conn)(nil)
+
+// Conn seals every datagram written to and opens every datagram read from the underlying connection.
+type Conn struct {
+	net.Conn
+	session *Session
+	buffer  []byte
+	pending []byte
+}
+
+func NewConn(conn net.Conn, session *Session) *Conn {
+	return &Conn{
+		Conn:    conn,
+		session: session,
+		buffer:  make([]byte, config.Config.NetworkEndpoint.SizeRawIngressStorage),
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if _, err := c.Conn.Write(c.session.Seal(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		n, err := c.Conn.Read(c.buffer)
+		if err != nil {
+			return 0, err
+		}
+		// Datagrams which cannot be authenticated are dropped silently.
+		if plaintext, err := c.session.Open(c.buffer[:n]); err != nil {
+			log.Verbose.Println("Dropped datagram from ", c.RemoteAddr(), ". ", err.Error())
+		} else {
+			c.pending = plaintext
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
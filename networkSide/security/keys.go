@@ -0,0 +1,62 @@
+//
+package security
+
+import (
+	"fmt"
+	"github.com/scionproto/scion/go/lib/snet"
+	"net"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"sync"
+)
+
+var (
+	peerKeys     map[string] []byte
+	peerKeysOnce sync.Once
+)
+
+func loadPeerKeys() {
+	peerKeys = make(map[string] []byte)
+	for _, entry := range config.Config.Security.PeerKeys {
+		peer, err := entry.GetPeer()
+		if err != nil {
+			log.Error.Println(shila.PrependError(err, "Skipped insertion of peer key.").Error())
+			continue
+		}
+		key, err := entry.GetKey()
+		if err != nil {
+			log.Error.Println(shila.PrependError(err, fmt.Sprint("Skipped insertion of peer key for ", peer, ".")).Error())
+			continue
+		}
+		peerKeys[peer] = key
+	}
+}
+
+// A key specified for a host takes precedence over the one specified for its whole ISD-AS.
+// Peers reached over plain IP are identified by their IP only.
+func lookupPeerKey(peer shila.NetworkAddress) ([]byte, error) {
+
+	peerKeysOnce.Do(loadPeerKeys)
+
+	var candidates []string
+	switch addr := peer.(type) {
+	case *snet.UDPAddr:
+		if addr.Host != nil {
+			candidates = []string{fmt.Sprint(addr.IA, ",", addr.Host.IP), addr.IA.String()}
+		}
+	case *net.UDPAddr:
+		candidates = []string{addr.IP.String()}
+	}
+	if candidates == nil {
+		return nil, AuthenticationError(fmt.Sprint("Unsupported peer address ", peer, "."))
+	}
+
+	for _, candidate := range candidates {
+		if key, ok := peerKeys[candidate]; ok {
+			return key, nil
+		}
+	}
+
+	return nil, AuthenticationError(fmt.Sprint("No key for peer ", peer, "."))
+}
@@ -0,0 +1,70 @@
+//
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+const replayWindowSize = 64
+
+// Sliding window over the sequence numbers received within a session (RFC 4303, Appendix A).
+type replayWindow struct {
+	highest uint64
+	bitmap  uint64
+}
+
+func (w *replayWindow) valid(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	if seq > w.highest {
+		return true
+	}
+	diff := w.highest - seq
+	if diff >= replayWindowSize {
+		return false
+	}
+	return w.bitmap&(1<<diff) == 0
+}
+
+func (w *replayWindow) update(seq uint64) {
+	if seq > w.highest {
+		shift := seq - w.highest
+		if shift >= replayWindowSize {
+			w.bitmap = 1
+		} else {
+			w.bitmap = w.bitmap<<shift | 1
+		}
+		w.highest = seq
+		return
+	}
+	w.bitmap |= 1 << (w.highest - seq)
+}
+
+// Holds the ids of all sessions accepted within the acceptance window. Older sessions
+// are rejected anyway, therefore their ids can be forgotten.
+type sessionRegister struct {
+	sessions map[SessionID] time.Time
+	lock     sync.Mutex
+}
+
+var acceptedSessions = sessionRegister{sessions: make(map[SessionID] time.Time)}
+
+func (r *sessionRegister) insert(id SessionID, created time.Time, window time.Duration) bool {
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for key, t := range r.sessions {
+		if time.Since(t) > 2*window {
+			delete(r.sessions, key)
+		}
+	}
+
+	if _, ok := r.sessions[id]; ok {
+		return false
+	}
+	r.sessions[id] = created
+	return true
+}
@@ -0,0 +1,205 @@
+//
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"shila/config"
+	"shila/core/shila"
+	"sync"
+	"time"
+)
+
+// Every datagram sent over a secured backbone connection looks as follows:
+//
+// | session id (16 bytes) | sequence number (8 bytes) | sealed payload incl. tag |
+//
+// The session id consists of the creation time (unix nanoseconds) and 8 random bytes and is chosen
+// by the client network endpoint. The session key is derived from the pre-shared key of the peer
+// and the session id. Session id and sequence number are authenticated as additional data.
+
+const (
+	sessionIDLength = 16
+	sequenceLength  = 8
+	headerLength    = sessionIDLength + sequenceLength
+	keyLabel        = "shila backbone session key"
+)
+
+type SessionID [sessionIDLength]byte
+
+// The direction is part of the nonce, such that both sides can use the same session key.
+type direction uint8
+
+const (
+	initiator direction = 0
+	responder direction = 1
+)
+
+type Session struct {
+	id      SessionID
+	aead    cipher.AEAD
+	dir     direction
+	seq     uint64
+	window  replayWindow
+	lock    sync.Mutex
+}
+
+func Enabled() bool {
+	return config.Config.Security.Enabled
+}
+
+// NewInitiatorSession creates a fresh session towards the given peer. Used by the client network endpoints.
+func NewInitiatorSession(peer shila.NetworkAddress) (*Session, error) {
+
+	psk, err := lookupPeerKey(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	var id SessionID
+	binary.BigEndian.PutUint64(id[0:8], uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(id[8:]); err != nil {
+		return nil, shila.PrependError(err, "Unable to create session id.")
+	}
+
+	return newSession(id, psk, initiator)
+}
+
+// AcceptSession creates the session from the very first datagram received from a peer. Used by the server
+// network endpoints. The datagram has to be authentic, otherwise the peer is rejected. The datagram itself
+// is not consumed, it still has to be opened through the returned session.
+func AcceptSession(peer shila.NetworkAddress, datagram []byte) (*Session, error) {
+
+	if len(datagram) < headerLength {
+		return nil, AuthenticationError("Datagram too short.")
+	}
+
+	var id SessionID
+	copy(id[:], datagram[:sessionIDLength])
+
+	window := time.Duration(config.Config.Security.SessionAcceptanceWindow) * time.Second
+	created := time.Unix(0, int64(binary.BigEndian.Uint64(id[0:8])))
+	if age := time.Since(created); age > window || age < -window {
+		return nil, AuthenticationError(fmt.Sprint("Session created outside of acceptance window (age ", age, ")."))
+	}
+
+	psk, err := lookupPeerKey(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := newSession(id, psk, responder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := session.open(datagram, false); err != nil {
+		return nil, err
+	}
+
+	// A session can be accepted just once, otherwise a recorded handshake could be replayed.
+	if !acceptedSessions.insert(id, created, window) {
+		return nil, AuthenticationError("Session already accepted.")
+	}
+
+	return session, nil
+}
+
+func newSession(id SessionID, psk []byte, dir direction) (*Session, error) {
+
+	mac := hmac.New(sha256.New, psk)
+	mac.Write([]byte(keyLabel))
+	mac.Write(id[:])
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, shila.PrependError(err, "Unable to create cipher.")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, shila.PrependError(err, "Unable to create cipher.")
+	}
+
+	return &Session{id: id, aead: aead, dir: dir}, nil
+}
+
+func (s *Session) ID() SessionID {
+	return s.id
+}
+
+// Seal encrypts and authenticates the plaintext and returns the complete datagram.
+func (s *Session) Seal(plaintext []byte) []byte {
+
+	s.lock.Lock()
+	s.seq++
+	seq := s.seq
+	s.lock.Unlock()
+
+	datagram := make([]byte, headerLength, headerLength+len(plaintext)+s.aead.Overhead())
+	copy(datagram, s.id[:])
+	binary.BigEndian.PutUint64(datagram[sessionIDLength:headerLength], seq)
+
+	return s.aead.Seal(datagram, s.nonce(s.dir, seq), plaintext, datagram[:headerLength])
+}
+
+// Open verifies and decrypts the datagram (in place) and returns the plaintext.
+// Datagrams from other sessions and replayed datagrams are rejected.
+func (s *Session) Open(datagram []byte) ([]byte, error) {
+	return s.open(datagram, true)
+}
+
+func (s *Session) open(datagram []byte, consume bool) ([]byte, error) {
+
+	if len(datagram) < headerLength+s.aead.Overhead() {
+		return nil, AuthenticationError("Datagram too short.")
+	}
+	if !hmac.Equal(datagram[:sessionIDLength], s.id[:]) {
+		return nil, AuthenticationError("Datagram belongs to another session.")
+	}
+
+	seq := binary.BigEndian.Uint64(datagram[sessionIDLength:headerLength])
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.window.valid(seq) {
+		return nil, AuthenticationError(fmt.Sprint("Replayed datagram (sequence number ", seq, ")."))
+	}
+
+	nonce := s.nonce(1-s.dir, seq)
+	header := datagram[:headerLength]
+	ciphertext := datagram[headerLength:]
+
+	var plaintext []byte
+	var err error
+	if consume {
+		plaintext, err = s.aead.Open(ciphertext[:0], nonce, ciphertext, header)
+	} else {
+		plaintext, err = s.aead.Open(nil, nonce, ciphertext, header)
+	}
+	if err != nil {
+		return nil, AuthenticationError("Unable to authenticate datagram.")
+	}
+
+	if consume {
+		s.window.update(seq)
+	}
+	return plaintext, nil
+}
+
+func (s *Session) nonce(dir direction, seq uint64) []byte {
+	nonce := make([]byte, s.aead.NonceSize())
+	nonce[0] = byte(dir)
+	binary.BigEndian.PutUint64(nonce[len(nonce)-sequenceLength:], seq)
+	return nonce
+}
+
+// Authentication issue, the datagram is dropped.
+type AuthenticationError string
+func (e AuthenticationError) Error() string {
+	return string(e)
+}
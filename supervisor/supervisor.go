@@ -0,0 +1,101 @@
+//
+package supervisor
+
+import (
+	"fmt"
+	"shila/config"
+	"shila/core/shila"
+	"shila/log"
+	"shila/shutdown"
+	"sync"
+	"time"
+)
+
+// A supervisor restarts a failed entity (e.g. an endpoint) instead of shutting down shila. The restarts
+// are delayed by an exponential backoff. Just if the entity fails more often than configured within the
+// restart window, shila is shut down after all.
+
+type Supervisor struct {
+	name       string
+	lock       sync.Mutex
+	failures   []time.Time // Failures (incl. failed restarts) within the restart window.
+	restarting bool
+}
+
+func New(name string) *Supervisor {
+	return &Supervisor{name: name}
+}
+
+// Restart restarts the failed entity in the background, restart is called until it succeeds. A failure
+// reported while a restart is pending is covered by the pending restart and therefore ignored.
+func (s *Supervisor) Restart(cause error, restart func() error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.restarting {
+		return
+	}
+	s.restarting = true
+	go s.serveRestart(cause, restart)
+}
+
+func (s *Supervisor) serveRestart(cause error, restart func() error) {
+	for {
+		backoff, ok := s.fail()
+		if !ok {
+			shutdown.Fatal(shila.PrependError(cause, s.Says(fmt.Sprint("Failed more than ",
+				config.Config.Supervisor.MaxRestarts, " times within ", config.Config.Supervisor.RestartWindow, "s."))))
+			return
+		}
+		log.Error.Print(s.Says(fmt.Sprint("Failed, restart in ", backoff, ". ", cause.Error())))
+		time.Sleep(backoff)
+		if cause = restart(); cause == nil {
+			break
+		}
+	}
+
+	s.lock.Lock()
+	s.restarting = false
+	s.lock.Unlock()
+
+	log.Info.Print(s.Says("Restarted."))
+}
+
+// Records a failure and returns the time to wait before the next restart. False if there
+// were too many failures within the restart window.
+func (s *Supervisor) fail() (time.Duration, bool) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	window := time.Duration(config.Config.Supervisor.RestartWindow) * time.Second
+	recent := s.failures[:0]
+	for _, failure := range s.failures {
+		if now.Sub(failure) < window {
+			recent = append(recent, failure)
+		}
+	}
+	s.failures = append(recent, now)
+
+	if len(s.failures) > config.Config.Supervisor.MaxRestarts {
+		return 0, false
+	}
+
+	maxBackoff := time.Duration(config.Config.Supervisor.MaxBackoff) * time.Millisecond
+	backoff := time.Duration(config.Config.Supervisor.InitialBackoff) * time.Millisecond
+	for i := 1; i < len(s.failures) && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff, true
+}
+
+func (s *Supervisor) Says(str string) string {
+	return fmt.Sprint(s.Identifier(), ": ", str)
+}
+
+func (s *Supervisor) Identifier() string {
+	return fmt.Sprint("Supervisor of ", s.name)
+}
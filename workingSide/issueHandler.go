@@ -9,58 +9,64 @@ import (
 	"shila/shutdown"
 )
 
-// This issue handler handles all issues related to a connection.
+// This issue handler handles all issues related to a connection. An issue closes the affected
+// connection(s), the failed endpoints themselves are restarted by their side.
 func (manager *Manager) issueHandler() {
 	for issue := range manager.endpointIssues {
-		// Handle issues sent from the kernel endpoint
 		var ep interface{} = issue.Issuer
-		if server, ok := ep.(*networkEndpoint.Server); ok {
+		if server, ok := ep.(shila.NetworkServerEndpoint); ok {
 			manager.handleServerNetworkEndpointIssues(server, issue)
-			return
-		} else if client, ok := ep.(*networkEndpoint.Client); ok {
+		} else if client, ok := ep.(shila.NetworkClientEndpoint); ok {
 			manager.handleNetworkClientIssue(client, issue)
-			return
+		} else if issue.Issuer.Role() == shila.IngressKernelEndpoint ||
+				  issue.Issuer.Role() == shila.EgressKernelEndpoint {
+			manager.handleKernelEndpointIssue(issue)
+		} else {
+			shutdown.Fatal(shila.CriticalError("Received issue from unhandled endpoint type. Should not happen."))
 		}
-		shutdown.Fatal(shila.CriticalError("Received issue from unhandled endpoint type. Should not happen."))
 	}
 }
 
 func (manager *Manager) handleServerNetworkEndpointIssues(server shila.NetworkServerEndpoint, issue shila.EndpointIssuePub) {
-
-	if server.Role() == shila.TrafficNetworkEndpoint {
-		var err interface{} = issue.Error
-		if connErr, ok := err.(networkEndpoint.ConnectionError); ok {
-			log.Error.Print(server.Identifier(), " triggered connection error: ", connErr.Error())
-			manager.connections.Close(issue.Key, issue.Error)
-			return
-		} else if opErr, ok := err.(*snet.OpError); ok {
-			log.Error.Print(server.Identifier(), " received op error: ", opErr.Error())
-			fmt.Printf("SCMP header: %v\n", opErr.SCMP())
-			manager.connections.Close(issue.Key, issue.Error)
-			return
-		}
-		shutdown.Fatal(shila.CriticalError(fmt.Sprint("Received unknown issue from ", server.Identifier())))
+	if  server.Role() == shila.TrafficNetworkEndpoint ||
+		server.Role() == shila.ContactNetworkEndpoint {
+		manager.closeConnection(server, issue)
+		return
 	}
 	shutdown.Fatal(shila.CriticalError(fmt.Sprint("Received issue from endpoint with unhandled role: ", server.Identifier())))
 }
 
 func (manager *Manager) handleNetworkClientIssue(client shila.NetworkClientEndpoint, issue shila.EndpointIssuePub) {
-
 	if  client.Role() == shila.TrafficNetworkEndpoint ||
 		client.Role() == shila.ContactNetworkEndpoint {
-
-		var err interface{} = issue.Error
-		if connErr, ok := err.(networkEndpoint.ConnectionError); ok {
-			log.Error.Print(client.Identifier(), " triggered connection error: ", connErr.Error())
-			manager.connections.Close(issue.Key, issue.Error)
-			return
-		} else if opErr, ok := err.(*snet.OpError); ok {
-			log.Error.Print(client.Identifier(), " received op error: ", opErr.Error())
-			fmt.Printf("SCMP header: %v\n", opErr.SCMP())
-			manager.connections.Close(issue.Key, issue.Error)
-			return
-		}
-		shutdown.Fatal(shila.CriticalError(fmt.Sprint("Received unknown issue from: ", client.Identifier())))
+		manager.closeConnection(client, issue)
+		return
 	}
 	shutdown.Fatal(shila.CriticalError(fmt.Sprint("Received issue from endpoint with unhandled role: ", client.Identifier())))
-}
\ No newline at end of file
+}
+
+func (manager *Manager) handleKernelEndpointIssue(issue shila.EndpointIssuePub) {
+	log.Error.Print(issue.Issuer.Identifier(), " failed, closing its connections.")
+	manager.connections.CloseUsing(issue.Issuer, issue.Error)
+}
+
+// Closes the connection the issue of the network endpoint refers to.
+func (manager *Manager) closeConnection(endpoint shila.Endpoint, issue shila.EndpointIssuePub) {
+
+	var err interface{} = issue.Error
+	if connErr, ok := err.(networkEndpoint.ConnectionError); ok {
+		log.Error.Print(endpoint.Identifier(), " triggered connection error: ", connErr.Error())
+	} else if opErr, ok := err.(*snet.OpError); ok {
+		log.Error.Print(endpoint.Identifier(), " received op error: ", opErr.Error())
+		log.Verbose.Print(endpoint.Identifier(), " SCMP header: ", opErr.SCMP())
+	} else {
+		log.Error.Print(endpoint.Identifier(), " published unknown issue: ", issue.Error.Error())
+	}
+
+	// An issue w/o key concerns the endpoint as a whole, its connections get an issue on their own.
+	// A client serves a single connection, its issues always carry the key of the connection.
+	if issue.Key == "" {
+		return
+	}
+	manager.connections.Close(issue.Key, issue.Error)
+}
@@ -2,6 +2,7 @@ package workingSide
 
 import (
 	"github.com/bclicn/color"
+	"net"
 	"shila/config"
 	"shila/core/shila"
 	"shila/log"
@@ -14,10 +15,58 @@ func (manager *Manager) packetWorker() {
 	}
 }
 
+// With several workers, the packets are dispatched by the key of their tcp flow. All packets of a
+// flow are processed by the same worker in the order of their arrival, distinct flows in parallel.
 func (manager *Manager) servePacketChannel(buffer shila.PacketChannel, numberOfWorker int) {
-	for id := 0; id < numberOfWorker; id++ {
+
+	if numberOfWorker <= 1 {
 		go manager.handlePacketChannel(buffer)
+		return
+	}
+
+	shards := make([]shila.PacketChannel, numberOfWorker)
+	for id := range shards {
+		shards[id] = make(shila.PacketChannel, config.Config.WorkingSide.SizeWorkerBuffer)
+		go manager.handlePacketChannel(shards[id])
+	}
+
+	go dispatchPacketChannel(buffer, shards)
+}
+
+// The channel is closed by its endpoint, the workers stop as well once they have processed the remaining packets.
+func dispatchPacketChannel(buffer shila.PacketChannel, shards []shila.PacketChannel) {
+	for p := range buffer {
+		shards[shardOf(p, len(shards))] <- p
+	}
+	for _, shard := range shards {
+		close(shard)
+	}
+}
+
+// FNV-1a (32 bit)
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// Hashes the raw addresses and ports of the tcp flow, it is the same for both directions of the flow.
+func shardOf(p *shila.Packet, numberOfShards int) int {
+	hash := hashTCPAddr(p.Flow.TCPFlow.Src) ^ hashTCPAddr(p.Flow.TCPFlow.Dst)
+	return int(hash % uint32(numberOfShards))
+}
+
+func hashTCPAddr(addr net.TCPAddr) uint32 {
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	hash := uint32(fnvOffset32)
+	for _, b := range ip {
+		hash = (hash ^ uint32(b)) * fnvPrime32
 	}
+	hash = (hash ^ uint32(addr.Port >> 8 & 0xff)) * fnvPrime32
+	hash = (hash ^ uint32(addr.Port & 0xff)) * fnvPrime32
+	return hash
 }
 
 func (manager *Manager) handlePacketChannel(buffer shila.PacketChannel) {
@@ -0,0 +1,106 @@
+//
+package workingSide
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"shila/core/shila"
+	"sync"
+	"testing"
+	"time"
+)
+
+const numberOfFlows = 4096
+
+// Packets of distinct tcp flows, the ports vary as those of the connections of a single host.
+func packetsOfFlows(n int, payloadSize int) []*shila.Packet {
+	packets := make([]*shila.Packet, n)
+	payload := make([]byte, payloadSize)
+	for i := range packets {
+		packets[i] = shila.NewPacket(nil, shila.TCPFlow{
+			Src: net.TCPAddr{IP: net.IPv4(10, 7, 1, byte(1 + i % 3)), Port: 40000 + i},
+			Dst: net.TCPAddr{IP: net.IPv4(10, 7, 0, 9), Port: 11111},
+		}, payload)
+	}
+	return packets
+}
+
+func TestShardOfBothDirections(t *testing.T) {
+	for _, p := range packetsOfFlows(numberOfFlows, 0) {
+		reply := shila.NewPacket(nil, p.Flow.TCPFlow.Swap(), nil)
+		if shardOf(p, 8) != shardOf(reply, 8) {
+			t.Fatal("Directions of ", p.Flow.TCPFlow.String(), " in distinct shards.")
+		}
+	}
+}
+
+func TestShardOfBalanced(t *testing.T) {
+	const numberOfShards = 8
+	counts := make([]int, numberOfShards)
+	for _, p := range packetsOfFlows(numberOfFlows, 0) {
+		counts[shardOf(p, numberOfShards)]++
+	}
+	for shard, count := range counts {
+		if expected := numberOfFlows / numberOfShards; count < expected * 3 / 4 || count > expected * 5 / 4 {
+			t.Errorf("Shard %d got %d of %d flows.", shard, count, numberOfFlows)
+		}
+	}
+}
+
+func BenchmarkShardOf(b *testing.B) {
+	packets := packetsOfFlows(numberOfFlows, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		shardOf(packets[i % len(packets)], 8)
+	}
+}
+
+// Packets per second through a packet channel w/ the given number of workers. Each packet costs the worker
+// a hash of its payload, in place of the processing by its connection.
+func BenchmarkDispatch(b *testing.B) {
+	for _, numberOfWorker := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprint("workers=", numberOfWorker), func(b *testing.B) {
+			benchmarkDispatch(b, numberOfWorker)
+		})
+	}
+}
+
+func benchmarkDispatch(b *testing.B, numberOfWorker int) {
+
+	packets := packetsOfFlows(numberOfFlows, 1400)
+	buffer := make(shila.PacketChannel, 250)
+
+	var workers sync.WaitGroup
+	work := func(channel shila.PacketChannel) {
+		defer workers.Done()
+		for p := range channel {
+			sha256.Sum256(p.Payload)
+		}
+	}
+	if numberOfWorker <= 1 {
+		workers.Add(1)
+		go work(buffer)
+	} else {
+		shards := make([]shila.PacketChannel, numberOfWorker)
+		for id := range shards {
+			shards[id] = make(shila.PacketChannel, 250)
+			workers.Add(1)
+			go work(shards[id])
+		}
+		go dispatchPacketChannel(buffer, shards)
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		buffer <- packets[i % len(packets)]
+	}
+	close(buffer)
+	workers.Wait()
+	elapsed := time.Since(start).Seconds()
+	b.StopTimer()
+
+	b.ReportMetric(float64(b.N) / elapsed, "packets/s")
+}
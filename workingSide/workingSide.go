@@ -4,8 +4,11 @@ package workingSide
 import (
 	"fmt"
 	"shila/core/connection"
+	"shila/config"
 	"shila/core/shila"
+	"shila/log"
 	"shila/shutdown"
+	"time"
 )
 
 type Manager struct {
@@ -35,10 +38,32 @@ func (manager *Manager) Start() error {
 
 	go manager.packetWorker()
 	go manager.issueHandler()
+	go manager.logStats()
 
 	return nil
 }
 
+// Stats returns the stats of the connections handled by the working side.
+func (manager *Manager) Stats() []connection.Stats {
+	return manager.connections.Stats()
+}
+
+func (manager *Manager) logStats() {
+
+	interval := time.Duration(config.Config.Logging.StatsInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, stats := range manager.Stats() {
+			log.Info.Print(manager.Says(fmt.Sprint(stats.TCPFlow.String(), " (", stats.State, "): ", stats)))
+		}
+	}
+}
+
 func (manager *Manager) CleanUp() { }
 
 func (manager *Manager) Says(str string) string {